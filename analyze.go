@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/ad/go-parking/poly"
+	"github.com/ernyoke/imger/edgedetection"
+	"github.com/ernyoke/imger/effects"
+	"github.com/ernyoke/imger/grayscale"
+	"github.com/ernyoke/imger/resize"
+)
+
+const resizeScale = 0.5
+
+// Spot statuses.
+const (
+	StatusFree     = "free"
+	StatusOccupied = "occupied"
+	StatusUnknown  = "unknown"
+
+	// StatusOutOfService is set from the layout; such spots are not
+	// measured and not counted.
+	StatusOutOfService = "out_of_service"
+)
+
+// SpotResult is the measured state of a single parking spot.
+type SpotResult struct {
+	Index      int      `json:"index"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name,omitempty"`
+	Zones      []string `json:"zones,omitempty"`
+	Zero       int      `json:"zero"`
+	NonZero    int      `json:"non_zero"`
+	Percentage float64  `json:"percentage"`
+	Status     string   `json:"status"`
+
+	// Confidence is 0-1; LowConfidence is set below the configured minimum
+	Confidence    float64 `json:"confidence"`
+	LowConfidence bool    `json:"low_confidence,omitempty"`
+
+	// Violation tells why an occupied spot breaks its rule
+	Violation string `json:"violation,omitempty"`
+
+	// OccupiedSince and Dwell tell how long the spot has been occupied;
+	// OccupiedSince is left out while the car was parked before tracking
+	// began. Previous is set when the status changed with this frame.
+	OccupiedSince time.Time `json:"occupied_since,omitzero"`
+	Dwell         Duration  `json:"dwell,omitzero"`
+	Previous      string    `json:"previous,omitempty"`
+
+	// margin is the distance of the percentage to the decision boundary
+	// normalized to 0-1
+	margin float64
+}
+
+// Analysis is the outcome of processing one frame.
+type Analysis struct {
+	Frame   *image.RGBA   `json:"-"`
+	Image   *image.RGBA   `json:"-"`
+	Time    time.Time     `json:"time"`
+	Spots   []*SpotResult `json:"spots"`
+	Counts  ZoneCount     `json:"counts"`
+	Zones   []*ZoneCount  `json:"zones,omitempty"`
+	Quality *Quality      `json:"quality,omitempty"`
+	Took    time.Duration `json:"took"`
+
+	// thumb is a small grayscale copy of the spot region used by the
+	// image quality checks
+	thumb *image.Gray
+}
+
+// analyzeFrame measures the spots of layout on img. The imger filters it
+// uses split every call into blocks run on their own goroutines, so each
+// queue worker can keep more than one core busy.
+func analyzeFrame(img image.Image, layout *Layout, isDay bool, progress func(stage string)) (*Analysis, error) {
+	start := time.Now()
+
+	tresholdEmpty := 94.0
+	tresholdEdges := 128.0
+	if isDay {
+		tresholdEmpty = 96.0
+		tresholdEdges = 192.0
+	}
+
+	progress("edges")
+
+	b := img.Bounds()
+	imgRGBA := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(imgRGBA, imgRGBA.Bounds(), img, b.Min, draw.Src)
+
+	roi := detectionROI(layout, imgRGBA.Bounds())
+	if roi.Empty() {
+		return nil, fmt.Errorf("layout is outside of the %dx%d frame", b.Dx(), b.Dy())
+	}
+
+	imgEdges, gray, err := detectEdges(imgRGBA, roi, tresholdEdges)
+	if err != nil {
+		return nil, err
+	}
+
+	progress("scan")
+
+	integral := newRowIntegral(imgEdges, image.Pt(
+		int(float64(roi.Min.X)*resizeScale),
+		int(float64(roi.Min.Y)*resizeScale),
+	))
+
+	masks := layout.Masks(resizeScale)
+
+	spots := make([]*SpotResult, len(layout.Spots))
+	for i, mask := range masks {
+		edges, total := integral.count(mask)
+
+		spot := &SpotResult{
+			Index:   i,
+			ID:      layout.Spots[i].ID,
+			Name:    layout.Spots[i].Name,
+			Zones:   layout.Spots[i].Zones,
+			NonZero: edges,
+			Zero:    total - edges,
+			Status:  StatusOccupied,
+		}
+		switch {
+		case layout.Spots[i].OutOfService:
+			spot.Status = StatusOutOfService
+			spot.margin = 1
+		case total == 0:
+			// the spot is outside of the frame
+			spot.Status = StatusUnknown
+		case spot.Zero != 0:
+			spot.Percentage = 100 - float64(spot.NonZero)/float64(spot.Zero)*100
+			if spot.Percentage > tresholdEmpty {
+				spot.Status = StatusFree
+			}
+
+			spot.margin = decisionMargin(spot.Percentage, tresholdEmpty)
+		default:
+			// every pixel is an edge
+			spot.margin = 1
+		}
+
+		spots[i] = spot
+	}
+
+	thumb, err := resize.ResizeGray(gray, thumbScale, thumbScale, resize.InterNearest)
+	if err != nil {
+		return nil, fmt.Errorf("could not resize image: %w", err)
+	}
+
+	return &Analysis{
+		Frame: imgRGBA,
+		Spots: spots,
+		Took:  time.Since(start),
+		thumb: thumb,
+	}, nil
+}
+
+// occupiedSpread is the distance below the threshold, in percentage points,
+// at which an occupied verdict is considered certain.
+const occupiedSpread = 10.0
+
+// decisionMargin tells how far percentage is from threshold: 0 on the
+// boundary, 1 for an empty spot or one clearly below the threshold.
+func decisionMargin(percentage, threshold float64) float64 {
+	if percentage > threshold {
+		return min((percentage-threshold)/(100-threshold), 1)
+	}
+
+	return min((threshold-percentage)/occupiedSpread, 1)
+}
+
+// cropPadding is the margin in working resolution pixels kept around the
+// spots. The 3x3 sharpen runs before resizing and reaches one full
+// resolution pixel; after it the radius 1 Gaussian blur, the 3x3 Sobel and
+// non-max suppression each reach one working pixel, and hysteresis only
+// looks at the pixel itself. So the crop border changes the edge map up to 3
+// pixels inside it, and 8 keeps the spots well clear of that.
+const cropPadding = 8
+
+// detectionROI returns the part of the frame, in full resolution pixels, that
+// has to go through edge detection: the padded union of the spot bounding
+// boxes. The origin is aligned to the resize step, so nearest neighbour
+// resizing samples the same pixels as it does on the full frame.
+func detectionROI(layout *Layout, bounds image.Rectangle) image.Rectangle {
+	step := int(math.Round(1 / resizeScale))
+	pad := cropPadding * step
+
+	min, max := poly.MinMaxMany(layout.Polygons())
+
+	x0 := (int(min.X) - pad) / step * step
+	y0 := (int(min.Y) - pad) / step * step
+
+	return image.Rect(
+		x0, y0,
+		int(math.Ceil(max.X))+pad, int(math.Ceil(max.Y))+pad,
+	).Intersect(bounds)
+}
+
+// detectEdges runs the grayscale, sharpen, resize and Canny pipeline over roi
+// of img and returns the inverted edge map at working resolution along with
+// the grayscale crop. The results cover roi only: the (0, 0) of the edge map
+// is roi.Min scaled by resizeScale.
+func detectEdges(img *image.RGBA, roi image.Rectangle, tresholdEdges float64) (edges, gray *image.Gray, err error) {
+	// imger filters expect images starting at (0, 0)
+	cropped := image.NewRGBA(image.Rect(0, 0, roi.Dx(), roi.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, roi.Min, draw.Src)
+
+	gray = grayscale.Grayscale(cropped)
+	grayscaleImg, err := effects.SharpenGray(gray)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not sharpen image: %w", err)
+	}
+
+	if resizeScale != 1.0 {
+		// Resize image to half size for faster processing
+		grayscaleImg, err = resize.ResizeGray(grayscaleImg, resizeScale, resizeScale, resize.InterNearest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not resize image: %w", err)
+		}
+	}
+
+	// Edge detection
+	imgEdges, err := edgedetection.CannyGray(grayscaleImg, 1, tresholdEdges, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not detect edges: %w", err)
+	}
+
+	// Invert image
+	return effects.InvertGray(imgEdges), gray, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ad/go-parking/poly"
+)
+
+// blockFrame returns a frame of random gray blocks, so that edge detection
+// finds edges all over it.
+func blockFrame(w, h int) *image.RGBA {
+	rng := rand.New(rand.NewPCG(3, 4))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y += 5 {
+		for x := 0; x < w; x += 7 {
+			c := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 0xff}
+			for dy := range min(5, h-y) {
+				for dx := range min(7, w-x) {
+					img.SetRGBA(x+dx, y+dy, c)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// rect returns an axis aligned rectangle polygon.
+func rect(x0, y0, x1, y1 float64) *poly.Poly {
+	return &poly.Poly{XY: []poly.XY{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}}
+}
+
+// edgeCounts runs edge detection over roi of img and counts the edges of
+// every spot of layout.
+func edgeCounts(t *testing.T, img *image.RGBA, roi image.Rectangle, layout *Layout) [][2]int {
+	t.Helper()
+
+	edges, _, err := detectEdges(img, roi, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	integral := newRowIntegral(edges, image.Pt(
+		int(float64(roi.Min.X)*resizeScale),
+		int(float64(roi.Min.Y)*resizeScale),
+	))
+
+	var counts [][2]int
+	for _, mask := range layout.Masks(resizeScale) {
+		e, n := integral.count(mask)
+		counts = append(counts, [2]int{e, n})
+	}
+
+	return counts
+}
+
+func TestDetectionROIMatchesFullFrame(t *testing.T) {
+	img := blockFrame(480, 320)
+
+	// axis aligned spots on the edges of the union, so that whole mask
+	// rows and columns lie next to the crop border, at odd coordinates and
+	// one touching the frame border
+	layout := &Layout{Spots: []*Spot{
+		{ID: "left", Poly: rect(101, 131, 141, 171)},
+		{ID: "top", Poly: rect(181, 97, 233, 139)},
+		{ID: "right", Poly: rect(301, 151, 353, 203)},
+		{ID: "bottom", Poly: rect(207, 221, 263, 267)},
+		{ID: "edge", Poly: rect(420, 260, 480, 320)},
+	}}
+
+	roi := detectionROI(layout, img.Bounds())
+	if roi == img.Bounds() {
+		t.Fatalf("roi %v is the whole frame", roi)
+	}
+
+	full := edgeCounts(t, img, img.Bounds(), layout)
+	cropped := edgeCounts(t, img, roi, layout)
+
+	for i, spot := range layout.Spots {
+		if full[i][0] == 0 {
+			t.Errorf("spot %s: no edges, the frame does not test anything", spot.ID)
+		}
+
+		if cropped[i] != full[i] {
+			t.Errorf("spot %s: %d edges of %d in the crop, %d of %d in the full frame",
+				spot.ID, cropped[i][0], cropped[i][1], full[i][0], full[i][1])
+		}
+	}
+
+	// without the padding the spots near the crop border do change
+	min, max := poly.MinMaxMany(layout.Polygons())
+	tight := image.Rect(int(min.X), int(min.Y), int(max.X)+1, int(max.Y)+1)
+
+	differ := false
+	for i, c := range edgeCounts(t, img, tight, layout) {
+		differ = differ || c != full[i]
+	}
+
+	if !differ {
+		t.Error("an unpadded crop gives the same counts, the test cannot catch a short padding")
+	}
+}
+
+// TestDetectEdgesBorder checks how far the crop border reaches into the edge
+// map: the differences to the full frame have to stay inside cropPadding.
+func TestDetectEdgesBorder(t *testing.T) {
+	img := blockFrame(480, 320)
+
+	full, _, err := detectEdges(img, img.Bounds(), 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reach returns the largest distance from the crop border, in working
+	// resolution pixels, of a pixel that differs from the full frame
+	reach := func(roi image.Rectangle) int {
+		edges, _, err := detectEdges(img, roi, 128)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := image.Pt(int(float64(roi.Min.X)*resizeScale), int(float64(roi.Min.Y)*resizeScale))
+		size := edges.Bounds().Size()
+
+		d := -1
+		for y := range size.Y {
+			for x := range size.X {
+				if edges.GrayAt(x, y) != full.GrayAt(x+o.X, y+o.Y) {
+					d = max(d, min(x, y, size.X-1-x, size.Y-1-y))
+				}
+			}
+		}
+
+		return d
+	}
+
+	for _, origin := range []image.Point{{100, 100}, {60, 40}, {2, 180}} {
+		roi := image.Rectangle{Min: origin, Max: origin.Add(image.Pt(200, 120))}
+		if d := reach(roi); d >= cropPadding {
+			t.Errorf("crop %v differs %d pixels inside its border, padding is %d", roi, d, cropPadding)
+		}
+	}
+
+	// an origin off the resize step samples other pixels everywhere
+	if d := reach(image.Rect(101, 101, 301, 221)); d < cropPadding {
+		t.Errorf("unaligned crop differs only %d pixels inside its border", d)
+	}
+}
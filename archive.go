@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArchiveSettings configures the archive of annotated frames, the source of
+// time-lapses. Frames are archived only when Dir is set.
+type ArchiveSettings struct {
+	Dir string `json:"dir"`
+
+	// Every is the minimal time between archived frames of a camera.
+	Every     Duration `json:"every"`
+	Retention Duration `json:"retention"`
+}
+
+func (as *ArchiveSettings) init() {
+	if as.Every <= 0 {
+		as.Every = Duration(time.Minute)
+	}
+
+	if as.Retention <= 0 {
+		as.Retention = Duration(30 * 24 * time.Hour)
+	}
+}
+
+// ArchivedFrame is an entry of the archive index.
+type ArchivedFrame struct {
+	Time  time.Time `json:"t"`
+	File  string    `json:"file"`
+	Free  int       `json:"free"`
+	Total int       `json:"total"`
+
+	path string
+}
+
+// Archive keeps annotated frames as <dir>/<camera>/<date>/<time>.jpg with an
+// index.jsonl per day holding the free counts.
+type Archive struct {
+	settings ArchiveSettings
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func NewArchive(settings ArchiveSettings) *Archive {
+	return &Archive{settings: settings, last: map[string]time.Time{}}
+}
+
+// Store archives the annotated image of a unless the camera's last archived
+// frame is more recent than the archive interval.
+func (ar *Archive) Store(camera string, a *Analysis) error {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if last, ok := ar.last[camera]; ok && a.Time.Sub(last) < time.Duration(ar.settings.Every) && !a.Time.Before(last) {
+		return nil
+	}
+
+	t := a.Time.Local()
+	dir := filepath.Join(ar.settings.Dir, camera, t.Format(historyDayLayout))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	name := t.Format("150405.000") + ".jpg"
+
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	if err := jpeg.Encode(f, a.Image, &jpeg.Options{Quality: 80}); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	entry, err := json.Marshal(ArchivedFrame{Time: a.Time, File: name, Free: a.Counts.Free, Total: a.Counts.Total})
+	if err != nil {
+		return err
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, "index.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := index.Write(append(entry, '\n')); err != nil {
+		index.Close()
+
+		return err
+	}
+
+	ar.last[camera] = a.Time
+
+	return index.Close()
+}
+
+// Frames lists the archived frames of a camera from from up to to, in time
+// order.
+func (ar *Archive) Frames(camera string, from, to time.Time) ([]*ArchivedFrame, error) {
+	var frames []*ArchivedFrame
+
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	for day := first; day.Before(to); day = day.AddDate(0, 0, 1) {
+		dir := filepath.Join(ar.settings.Dir, camera, day.Format(historyDayLayout))
+
+		f, err := os.Open(filepath.Join(dir, "index.jsonl"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			frame := &ArchivedFrame{}
+			if err := json.Unmarshal(scanner.Bytes(), frame); err != nil {
+				continue
+			}
+
+			if !frame.Time.Before(from) && frame.Time.Before(to) {
+				frame.path = filepath.Join(dir, frame.File)
+				frames = append(frames, frame)
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+
+	return frames, nil
+}
+
+// cleanup removes the days older than the retention.
+func (ar *Archive) cleanup() {
+	cameras, err := os.ReadDir(ar.settings.Dir)
+	if err != nil {
+		return
+	}
+
+	oldest := time.Now().Add(-time.Duration(ar.settings.Retention)).Format(historyDayLayout)
+
+	for _, camera := range cameras {
+		days, err := os.ReadDir(filepath.Join(ar.settings.Dir, camera.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, day := range days {
+			if day.Name() < oldest {
+				if err := os.RemoveAll(filepath.Join(ar.settings.Dir, camera.Name(), day.Name())); err != nil {
+					fmt.Printf("archive: %s\n", err)
+				}
+			}
+		}
+	}
+}
+
+// runCleanup removes old frames once a day.
+func (ar *Archive) runCleanup() {
+	for {
+		ar.cleanup()
+		time.Sleep(24 * time.Hour)
+	}
+}
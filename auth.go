@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Scope limits what an API key is allowed to do. Each scope includes the
+// ones below it: admin > analyze > read.
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"
+	ScopeAnalyze Scope = "analyze"
+	ScopeAdmin   Scope = "admin"
+)
+
+const apiKeyCookie = "api_key"
+
+// ingressGateway is the address the Supervisor sends ingress requests from.
+const ingressGateway = "172.30.32.2"
+
+// ingressKey authenticates requests through ingress. Home Assistant has
+// already checked the user, and the panel is only shown to administrators.
+var ingressKey = &APIKey{Name: "ingress", Scope: ScopeAdmin}
+
+var scopeLevel = map[Scope]int{
+	ScopeRead:    1,
+	ScopeAnalyze: 2,
+	ScopeAdmin:   3,
+}
+
+func (s Scope) valid() bool {
+	_, ok := scopeLevel[s]
+
+	return ok
+}
+
+// allows reports whether a key with scope s may access an endpoint requiring
+// the required scope.
+func (s Scope) allows(required Scope) bool {
+	return scopeLevel[s] >= scopeLevel[required]
+}
+
+// csrfSecret signs CSRF tokens. It changes on every restart, so open forms
+// have to be reloaded after the server restarts.
+var csrfSecret = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return b
+}()
+
+// lookupKey returns the configured API key matching key, or nil.
+func (s *Settings) lookupKey(key string) *APIKey {
+	if key == "" {
+		return nil
+	}
+
+	var found *APIKey
+	for _, k := range s.APIKeys {
+		// compare against every key to keep timing independent of the match
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			found = k
+		}
+	}
+
+	return found
+}
+
+// requestKey extracts the API key from the request. Headers are preferred;
+// the cookie is only set by the browser login on /form.
+func requestKey(r *http.Request) (key string, fromCookie bool) {
+	if key = r.Header.Get("X-API-Key"); key != "" {
+		return key, false
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), false
+	}
+
+	if c, err := r.Cookie(apiKeyCookie); err == nil {
+		return c.Value, true
+	}
+
+	return "", false
+}
+
+// fromIngress reports whether r was proxied by Home Assistant ingress.
+func (s *server) fromIngress(r *http.Request) bool {
+	if !s.settings.Ingress || r.Header.Get("X-Ingress-Path") == "" {
+		return false
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	return host == ingressGateway
+}
+
+// basePath is the prefix of links sent to the browser: the X-Ingress-Path
+// through ingress, otherwise empty.
+func (s *server) basePath(r *http.Request) string {
+	if !s.fromIngress(r) {
+		return ""
+	}
+
+	return strings.TrimSuffix(r.Header.Get("X-Ingress-Path"), "/")
+}
+
+// originHosts are the hosts besides the request's own that browser pages
+// may open WebSockets from: that of the base URL and, through ingress, the
+// Home Assistant address the browser is at.
+func (s *server) originHosts(r *http.Request) []string {
+	var hosts []string
+	if u, err := url.Parse(s.settings.BaseURL); err == nil && u.Host != "" {
+		hosts = append(hosts, u.Host)
+	}
+
+	if s.fromIngress(r) {
+		hosts = append(hosts, r.Header.Get("X-Forwarded-Host"))
+	}
+
+	return hosts
+}
+
+// csrfToken derives the CSRF token for a browser session from its API key.
+func csrfToken(key string) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(key))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// requireScope wraps h so it only runs for requests carrying an API key with
+// at least the required scope. Requests authenticated by cookie must also
+// carry a valid CSRF token unless they are safe (GET/HEAD).
+func (s *server) requireScope(required Scope, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, fromCookie := requestKey(r)
+
+		apiKey := s.settings.lookupKey(key)
+		if apiKey == nil && s.fromIngress(r) {
+			// a browser session like a cookie, so forms need the CSRF token
+			key, fromCookie, apiKey = "", true, ingressKey
+		}
+
+		if apiKey == nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		if !apiKey.Scope.allows(required) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		if fromCookie && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if !hmac.Equal([]byte(r.FormValue("csrf_token")), []byte(csrfToken(key))) {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// login stores the key passed as ?key= in an HttpOnly cookie and redirects
+// to the same page without the key in the URL, so browsers can use the form.
+func (s *server) login(w http.ResponseWriter, r *http.Request) bool {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return false
+	}
+
+	if s.settings.lookupKey(key) == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return true
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     apiKeyCookie,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	u := *r.URL
+	q := u.Query()
+	q.Del("key")
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, s.basePath(r)+u.String(), http.StatusSeeOther)
+
+	return true
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newAuthServer() *server {
+	return &server{settings: &Settings{
+		Targets: map[string]*Target{`<script>alert(1)</script>`: {}},
+		APIKeys: []*APIKey{
+			{Name: "reader", Key: "read-key", Scope: ScopeRead},
+			{Name: "camera", Key: "analyze-key", Scope: ScopeAnalyze},
+			{Name: "admin", Key: "admin-key", Scope: ScopeAdmin},
+		},
+	}}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestScopeAllows(t *testing.T) {
+	scopes := []Scope{ScopeRead, ScopeAnalyze, ScopeAdmin}
+	for i, have := range scopes {
+		for j, required := range scopes {
+			if got, want := have.allows(required), i >= j; got != want {
+				t.Errorf("%s.allows(%s) = %v, want %v", have, required, got, want)
+			}
+		}
+	}
+
+	if Scope("root").valid() || Scope("").allows(ScopeRead) {
+		t.Error("unknown scopes must not be valid or allow anything")
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	s := newAuthServer()
+
+	tests := []struct {
+		name     string
+		required Scope
+		header   string
+		value    string
+		want     int
+	}{
+		{"no key", ScopeRead, "", "", http.StatusUnauthorized},
+		{"wrong key", ScopeRead, "X-API-Key", "nope", http.StatusUnauthorized},
+		{"read on read", ScopeRead, "X-API-Key", "read-key", http.StatusNoContent},
+		{"read on analyze", ScopeAnalyze, "X-API-Key", "read-key", http.StatusForbidden},
+		{"analyze on analyze", ScopeAnalyze, "Authorization", "Bearer analyze-key", http.StatusNoContent},
+		{"analyze on admin", ScopeAdmin, "Authorization", "Bearer analyze-key", http.StatusForbidden},
+		{"admin on admin", ScopeAdmin, "X-API-Key", "admin-key", http.StatusNoContent},
+		{"admin on read", ScopeRead, "X-API-Key", "admin-key", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/process", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+
+			w := httptest.NewRecorder()
+			s.requireScope(tt.required, okHandler)(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := newAuthServer()
+
+	w := httptest.NewRecorder()
+	if !s.login(w, httptest.NewRequest(http.MethodGet, "/form?key=analyze-key&target=home", nil)) {
+		t.Fatal("login did not handle ?key=")
+	}
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	if loc := w.Header().Get("Location"); loc != "/form?target=home" {
+		t.Errorf("redirect to %q, want the key removed", loc)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+
+	c := cookies[0]
+	if c.Name != apiKeyCookie || c.Value != "analyze-key" || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie = %+v, want an HttpOnly strict cookie with the key", c)
+	}
+
+	w = httptest.NewRecorder()
+	if !s.login(w, httptest.NewRequest(http.MethodGet, "/form?key=nope", nil)) || w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("unknown key must not set a cookie")
+	}
+
+	if s.login(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/form", nil)) {
+		t.Error("login handled a request without ?key=")
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	s := newAuthServer()
+
+	post := func(token string, cookie bool) int {
+		form := url.Values{}
+		if token != "" {
+			form.Set("csrf_token", token)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/process", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if cookie {
+			r.AddCookie(&http.Cookie{Name: apiKeyCookie, Value: "analyze-key"})
+		} else {
+			r.Header.Set("X-API-Key", "analyze-key")
+		}
+
+		w := httptest.NewRecorder()
+		s.requireScope(ScopeAnalyze, okHandler)(w, r)
+
+		return w.Code
+	}
+
+	if code := post("", true); code != http.StatusForbidden {
+		t.Errorf("cookie without token: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := post(csrfToken("admin-key"), true); code != http.StatusForbidden {
+		t.Errorf("cookie with token of another key: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := post(csrfToken("analyze-key"), true); code != http.StatusNoContent {
+		t.Errorf("cookie with token: status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	if code := post("", false); code != http.StatusNoContent {
+		t.Errorf("header key without token: status = %d, want %d", code, http.StatusNoContent)
+	}
+
+	// safe methods need no token
+	r := httptest.NewRequest(http.MethodGet, "/form", nil)
+	r.AddCookie(&http.Cookie{Name: apiKeyCookie, Value: "analyze-key"})
+
+	w := httptest.NewRecorder()
+	s.requireScope(ScopeAnalyze, okHandler)(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("cookie GET: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestFormEscaping(t *testing.T) {
+	s := newAuthServer()
+
+	r := httptest.NewRequest(http.MethodGet, `/form?target=%22%3E%3Cb%3E`, nil)
+	r.AddCookie(&http.Cookie{Name: apiKeyCookie, Value: "analyze-key"})
+
+	w := httptest.NewRecorder()
+	s.renderForm(w, r, `<img src=x onerror=alert(1)>`)
+
+	body := w.Body.String()
+	for _, raw := range []string{"<script>", "<img src=x", `"><b>`} {
+		if strings.Contains(body, raw) {
+			t.Errorf("form contains unescaped %q", raw)
+		}
+	}
+
+	if !strings.Contains(body, `value="`+csrfToken("analyze-key")+`"`) {
+		t.Error("form does not carry the CSRF token of the session")
+	}
+}
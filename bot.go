@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// botPollTimeout is how long a getUpdates call waits for messages. It stays
+// below the timeout of notifyClient.
+const botPollTimeout = 25 * time.Second
+
+// botRetryDelay is the pause after a failed getUpdates call.
+const botRetryDelay = 5 * time.Second
+
+const botHelp = `/forecast [camera] [time] - chance of a free spot, e.g. "/forecast 09:00" or "/forecast yard 2h"`
+
+// runBots answers bot commands in the chats of telegram targets with
+// commands enabled. Targets sharing a bot token share one poller, as the
+// Bot API allows only one getUpdates caller per bot.
+func (s *server) runBots(ctx context.Context) {
+	bots := map[string]map[int64]*telegramNotifier{}
+	for _, t := range s.settings.Targets {
+		tn, ok := t.notifier.(*telegramNotifier)
+		if !ok || !t.Commands {
+			continue
+		}
+
+		if bots[tn.api] == nil {
+			bots[tn.api] = map[int64]*telegramNotifier{}
+		}
+		bots[tn.api][tn.chatID] = tn
+	}
+
+	for _, chats := range bots {
+		go s.runBot(ctx, chats)
+	}
+}
+
+// runBot polls one bot for messages and replies to commands coming from
+// chats. Messages from other chats are ignored.
+func (s *server) runBot(ctx context.Context, chats map[int64]*telegramNotifier) {
+	var bot *telegramNotifier
+	for _, tn := range chats {
+		bot = tn
+
+		break
+	}
+
+	var offset int64
+	for {
+		updates, err := bot.getUpdates(ctx, offset, botPollTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("telegram bot: could not get updates: %s\n", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(botRetryDelay):
+			}
+
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+
+			if u.Message == nil {
+				continue
+			}
+
+			tn, ok := chats[u.Message.Chat.ID]
+			if !ok {
+				continue
+			}
+
+			reply := s.botCommand(u.Message.Text, time.Now())
+			if reply == "" {
+				continue
+			}
+
+			if err := tn.sendMessage(reply); err != nil {
+				fmt.Printf("telegram bot: could not reply: %s\n", err)
+			}
+		}
+	}
+}
+
+// botCommand returns the reply to a chat message, or "" for messages that
+// are not commands of this bot.
+func (s *server) botCommand(text string, now time.Time) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	// in groups commands come as /forecast@botname
+	command, _, _ := strings.Cut(fields[0], "@")
+
+	switch command {
+	case "/forecast":
+		return s.forecastCommand(fields[1:], now)
+	case "/start", "/help":
+		return botHelp
+	default:
+		return ""
+	}
+}
+
+// forecastCommand answers /forecast [camera] [time]. The camera can be left
+// out when there is only one.
+func (s *server) forecastCommand(args []string, now time.Time) string {
+	if s.history == nil {
+		return "history is not configured, there is nothing to forecast from"
+	}
+
+	var camera string
+	if len(args) > 0 {
+		if _, ok := s.settings.Cameras[args[0]]; ok {
+			camera, args = args[0], args[1:]
+		}
+	}
+
+	if camera == "" {
+		if len(s.settings.Cameras) != 1 {
+			cameras := slices.Sorted(maps.Keys(s.settings.Cameras))
+
+			return fmt.Sprintf("which camera? %s\ncameras: %s", botHelp, strings.Join(cameras, ", "))
+		}
+
+		for id := range s.settings.Cameras {
+			camera = id
+		}
+	}
+
+	at := now
+	if len(args) > 0 {
+		var err error
+		if at, err = parseForecastTime(strings.Join(args, " "), now); err != nil {
+			return err.Error()
+		}
+	}
+
+	f, err := s.forecast(camera, at)
+	if err != nil {
+		return fmt.Sprintf("could not forecast: %s", err)
+	}
+
+	return f.String()
+}
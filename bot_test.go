@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBotCommand(t *testing.T) {
+	s := historyServer(t, 1)
+	now := time.Now()
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/forecast", "Forecast for yard at " + now.Local().Format("Mon 15:04")},
+		{"/forecast 2h", "Forecast for yard at " + now.Add(2*time.Hour).Local().Format("Mon 15:04")},
+		{"/forecast@parking_bot yard 2h", "Forecast for yard at " + now.Add(2*time.Hour).Local().Format("Mon 15:04")},
+		{"/forecast tomorrow", `bad time "tomorrow"`},
+		{"/help", "/forecast [camera] [time]"},
+		{"/start", "/forecast [camera] [time]"},
+		{"/unknown", ""},
+		{"hello", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got := s.botCommand(tt.text, now)
+		if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+			t.Errorf("%q: reply %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	s.settings.Cameras["gate"] = &Camera{}
+	if got := s.botCommand("/forecast 09:00", now); !strings.Contains(got, "cameras: gate, yard") {
+		t.Errorf("two cameras: reply %q, want the camera list", got)
+	}
+
+	s.history = nil
+	if got := s.botCommand("/forecast", now); !strings.Contains(got, "history is not configured") {
+		t.Errorf("no history: reply %q", got)
+	}
+}
+
+// fakeBotAPI serves getUpdates from updates, once, and records the messages
+// sent.
+type fakeBotAPI struct {
+	updates []telegramUpdate
+	offsets chan string
+	sent    chan [2]string
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		select {
+		case f.offsets <- r.FormValue("offset"):
+		default:
+		}
+
+		updates := f.updates
+		f.updates = nil
+
+		if len(updates) == 0 {
+			// a long poll that ends without news
+			select {
+			case <-r.Context().Done():
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": updates})
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		f.sent <- [2]string{r.FormValue("chat_id"), r.FormValue("text")}
+
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunBot(t *testing.T) {
+	var updates []telegramUpdate
+	if err := json.Unmarshal([]byte(`[
+		{"update_id": 10, "message": {"text": "/help", "chat": {"id": -100}}},
+		{"update_id": 11, "message": {"text": "/help", "chat": {"id": 666}}},
+		{"update_id": 12, "message": {"text": "just chatting", "chat": {"id": -100}}},
+		{"update_id": 13}
+	]`), &updates); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &fakeBotAPI{updates: updates, offsets: make(chan string, 16), sent: make(chan [2]string, 16)}
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	home := &Target{Token: "123:abc", ChatID: -100, URL: ts.URL, Commands: true}
+	quiet := &Target{Token: "123:abc", ChatID: -200, URL: ts.URL}
+	for _, target := range []*Target{home, quiet} {
+		if err := target.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &server{settings: &Settings{Targets: map[string]*Target{"home": home, "quiet": quiet}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.runBots(ctx)
+
+	if offset := <-api.offsets; offset != "0" {
+		t.Errorf("first offset %s, want 0", offset)
+	}
+
+	// the next poll confirms every update, answered or not
+	if offset := <-api.offsets; offset != "14" {
+		t.Errorf("second offset %s, want 14", offset)
+	}
+
+	select {
+	case sent := <-api.sent:
+		if sent[0] != "-100" || !strings.Contains(sent[1], "/forecast") {
+			t.Errorf("sent %q to %s, want the help to -100", sent[1], sent[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+
+	select {
+	case sent := <-api.sent:
+		t.Errorf("sent %q to %s, want a single reply", sent[1], sent[0])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCommandsOnlyForTelegram(t *testing.T) {
+	target := &Target{Type: "ntfy", URL: "http://ntfy", Topic: "parking", Commands: true}
+	if err := target.init(); err == nil {
+		t.Error("commands on an ntfy target were accepted")
+	}
+}
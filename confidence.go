@@ -0,0 +1,132 @@
+package main
+
+// What consumers do with spots below the minimal confidence.
+const (
+	LowConfidenceFlag = "flag"
+	LowConfidenceHide = "hide"
+)
+
+// ConfidenceSettings control how per-spot confidence is scored and shown.
+type ConfidenceSettings struct {
+	// Min is the confidence below which a spot is low confidence.
+	Min float64 `json:"min"`
+
+	// Mode is "flag" to mark low confidence spots or "hide" to leave them
+	// out of the annotated image and API responses.
+	Mode string `json:"mode"`
+
+	// History is the number of recent frames checked for agreement.
+	History int `json:"history"`
+
+	// MinPixels is the spot size, in working resolution pixels, below which
+	// a spot is too small to be judged with full confidence.
+	MinPixels int `json:"min_pixels"`
+}
+
+func (cs *ConfidenceSettings) init() {
+	if cs.Min <= 0 {
+		cs.Min = 0.5
+	}
+
+	if cs.Mode == "" {
+		cs.Mode = LowConfidenceFlag
+	}
+
+	if cs.History <= 0 {
+		cs.History = 5
+	}
+
+	if cs.MinPixels <= 0 {
+		cs.MinPixels = 300
+	}
+}
+
+// Weights of the confidence components.
+const (
+	marginWeight    = 0.5
+	agreementWeight = 0.3
+	pixelsWeight    = 0.2
+)
+
+// scoreConfidence sets the confidence of every spot of a from the distance
+// to the decision boundary, the agreement with the camera's recent frames
+// and the spot size. Frames that failed the quality checks get no confidence
+// at all. It must run after checkQuality.
+func (state *cameraState) scoreConfidence(a *Analysis, settings *ConfidenceSettings) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	reliable := a.Quality == nil || a.Quality.Reliable
+
+	for i, spot := range a.Spots {
+		if spot.Status == StatusOutOfService {
+			spot.Confidence = 1
+
+			continue
+		}
+
+		if !reliable || spot.Status == StatusUnknown {
+			spot.Confidence = 0
+			spot.LowConfidence = true
+
+			continue
+		}
+
+		// a spot that keeps its status over recent frames is more
+		// trustworthy than one that flickers
+		agreement, agreed, seen := 0.5, 0, 0
+		for _, statuses := range state.recent {
+			if i < len(statuses) && statuses[i] != StatusUnknown {
+				seen++
+				if statuses[i] == spot.Status {
+					agreed++
+				}
+			}
+		}
+
+		// with nothing to compare to yet, agreement stays neutral
+		if seen > 0 {
+			agreement = float64(agreed) / float64(seen)
+		}
+
+		pixels := min(float64(spot.Zero+spot.NonZero)/float64(settings.MinPixels), 1)
+
+		spot.Confidence = marginWeight*spot.margin + agreementWeight*agreement + pixelsWeight*pixels
+		spot.LowConfidence = spot.Confidence < settings.Min
+	}
+
+	if !reliable {
+		return
+	}
+
+	statuses := make([]string, len(a.Spots))
+	for i, spot := range a.Spots {
+		statuses[i] = spot.Status
+	}
+
+	state.recent = append(state.recent, statuses)
+	if len(state.recent) > settings.History {
+		state.recent = state.recent[len(state.recent)-settings.History:]
+	}
+}
+
+// visibleSpots returns the spots consumers should show under the given
+// settings: low confidence spots are left out in "hide" mode.
+func visibleSpots(spots []*SpotResult, settings *ConfidenceSettings, min float64) []*SpotResult {
+	if min <= 0 && settings.Mode != LowConfidenceHide {
+		return spots
+	}
+
+	if min <= 0 {
+		min = settings.Min
+	}
+
+	visible := make([]*SpotResult, 0, len(spots))
+	for _, spot := range spots {
+		if spot.Confidence >= min {
+			visible = append(visible, spot)
+		}
+	}
+
+	return visible
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecisionMargin(t *testing.T) {
+	for _, tt := range []struct {
+		percentage, threshold, margin float64
+	}{
+		{94, 94, 0},
+		{97, 94, 0.5},
+		{100, 94, 1},
+		{89, 94, 0.5},
+		{84, 94, 1},
+		{10, 94, 1},
+	} {
+		if got := decisionMargin(tt.percentage, tt.threshold); !near(got, tt.margin) {
+			t.Errorf("margin of %v at %v: %v, want %v", tt.percentage, tt.threshold, got, tt.margin)
+		}
+	}
+}
+
+func TestScoreConfidence(t *testing.T) {
+	settings := &ConfidenceSettings{}
+	settings.init()
+
+	spot := func(status string, margin float64, pixels int) *SpotResult {
+		return &SpotResult{Status: status, margin: margin, Zero: pixels}
+	}
+
+	tests := []struct {
+		name       string
+		recent     [][]string
+		quality    *Quality
+		spot       *SpotResult
+		confidence float64
+	}{
+		// without history the agreement is neutral
+		{"certain", nil, nil, spot(StatusFree, 1, 300), 0.5 + 0.15 + 0.2},
+		{"on the boundary", nil, nil, spot(StatusFree, 0, 300), 0.15 + 0.2},
+		{"small", nil, nil, spot(StatusFree, 1, 150), 0.5 + 0.15 + 0.1},
+		{"large", nil, nil, spot(StatusFree, 1, 3000), 0.5 + 0.15 + 0.2},
+		{"agreeing", [][]string{{StatusFree}, {StatusFree}}, nil, spot(StatusFree, 1, 300), 1},
+		{"flickering", [][]string{{StatusOccupied}, {StatusFree}, {StatusUnknown}}, nil, spot(StatusFree, 1, 300), 0.5 + 0.15 + 0.2},
+		{"changed", [][]string{{StatusOccupied}, {StatusOccupied}}, nil, spot(StatusFree, 1, 300), 0.5 + 0.2},
+		{"unknown", nil, nil, spot(StatusUnknown, 1, 300), 0},
+		{"unreliable frame", nil, &Quality{}, spot(StatusFree, 1, 300), 0},
+		{"out of service", nil, &Quality{}, spot(StatusOutOfService, 0, 0), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &cameraState{recent: tt.recent}
+			state.scoreConfidence(&Analysis{Quality: tt.quality, Spots: []*SpotResult{tt.spot}}, settings)
+
+			if !near(tt.spot.Confidence, tt.confidence) {
+				t.Errorf("confidence %v, want %v", tt.spot.Confidence, tt.confidence)
+			}
+
+			if tt.spot.LowConfidence != (tt.spot.Confidence < settings.Min) {
+				t.Errorf("low confidence %v at %v", tt.spot.LowConfidence, tt.spot.Confidence)
+			}
+		})
+	}
+}
+
+func TestLowConfidenceThreshold(t *testing.T) {
+	score := func(min float64) *SpotResult {
+		spot := &SpotResult{Status: StatusOccupied, margin: 0.4, Zero: 200}
+		(&cameraState{}).scoreConfidence(&Analysis{Spots: []*SpotResult{spot}}, &ConfidenceSettings{Min: min, History: 5, MinPixels: 300})
+
+		return spot
+	}
+
+	confidence := score(0.5).Confidence
+
+	// the minimum itself is enough
+	if spot := score(confidence); spot.LowConfidence {
+		t.Errorf("%v is low confidence at the minimum", spot.Confidence)
+	}
+
+	if spot := score(math.Nextafter(confidence, 1)); !spot.LowConfidence {
+		t.Errorf("%v is not low confidence just below the minimum", spot.Confidence)
+	}
+}
+
+func TestScoreConfidenceHistory(t *testing.T) {
+	settings := &ConfidenceSettings{History: 2}
+	settings.init()
+
+	state := &cameraState{}
+	for _, status := range []string{StatusFree, StatusOccupied, StatusOccupied} {
+		state.scoreConfidence(&Analysis{Spots: []*SpotResult{{Status: status}}}, settings)
+	}
+
+	// only the last frames are kept, unreliable ones not at all
+	state.scoreConfidence(&Analysis{Quality: &Quality{}, Spots: []*SpotResult{{Status: StatusFree}}}, settings)
+
+	if len(state.recent) != 2 || state.recent[0][0] != StatusOccupied || state.recent[1][0] != StatusOccupied {
+		t.Errorf("recent %v", state.recent)
+	}
+}
+
+func TestVisibleSpots(t *testing.T) {
+	spots := []*SpotResult{{ID: "a", Confidence: 0.2}, {ID: "b", Confidence: 0.5}, {ID: "c", Confidence: 0.9}}
+
+	tests := []struct {
+		mode    string
+		min     float64
+		visible int
+	}{
+		{LowConfidenceFlag, 0, 3},
+		{LowConfidenceHide, 0, 2},
+		{LowConfidenceFlag, 0.9, 1},
+		{LowConfidenceHide, 0.1, 3},
+		{LowConfidenceHide, 1, 0},
+	}
+
+	for _, tt := range tests {
+		settings := &ConfidenceSettings{Mode: tt.mode}
+		settings.init()
+
+		if visible := visibleSpots(spots, settings, tt.min); len(visible) != tt.visible {
+			t.Errorf("%s with min %v: %d visible, want %d", tt.mode, tt.min, len(visible), tt.visible)
+		}
+	}
+}
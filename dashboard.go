@@ -0,0 +1,205 @@
+package main
+
+import (
+	"html/template"
+	"image/jpeg"
+	"net/http"
+	"sort"
+)
+
+// dashboardTemplate is a single page that follows /stream. URLs are relative
+// to the page, so it also works behind a reverse proxy under a path prefix;
+// through ingress they start with the ingress path.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>go-parking</title>
+<style>
+:root { color-scheme: light dark; --ok: #2e7d32; --bad: #c62828; --warn: #ef6c00; --muted: #888; }
+body { margin: 0; font: 15px/1.4 system-ui, sans-serif; }
+header { display: flex; align-items: center; gap: 12px; padding: 10px 16px; border-bottom: 1px solid #8884; }
+header h1 { font-size: 18px; margin: 0; flex: 1; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax(min(100%, 480px), 1fr)); gap: 16px; padding: 16px; }
+section { border: 1px solid #8884; border-radius: 8px; overflow: hidden; }
+section h2 { font-size: 16px; margin: 0; padding: 10px 12px; display: flex; gap: 8px; align-items: baseline; }
+section h2 .free { margin-left: auto; font-weight: normal; }
+img { display: block; width: 100%; background: #8882; min-height: 80px; }
+table { width: 100%; border-collapse: collapse; }
+td, th { padding: 4px 12px; text-align: left; border-top: 1px solid #8882; }
+.health { padding: 6px 12px; font-size: 13px; }
+.zones { padding: 6px 12px; display: flex; flex-wrap: wrap; gap: 6px; }
+.zones span { border: 1px solid #8886; border-radius: 12px; padding: 1px 8px; font-size: 13px; }
+.free, .status-free { color: var(--ok); }
+.status-occupied, .bad { color: var(--bad); }
+.status-unknown, .warn { color: var(--warn); }
+.status-out_of_service, .muted { color: var(--muted); }
+#events { margin: 0 16px 16px; padding: 0; list-style: none; font-size: 13px; }
+#events li { padding: 3px 0; border-top: 1px solid #8882; }
+#events time { color: var(--muted); margin-right: 8px; }
+#state.bad::before, #state.ok::before { content: "● "; }
+#state.ok { color: var(--ok); }
+</style>
+</head>
+<body>
+<header><h1>go-parking</h1><span id="state">connecting…</span></header>
+<main id="cameras"></main>
+<h3 style="margin: 0 16px">Recent events</h3>
+<ul id="events"></ul>
+<script>
+const base = {{.Base}};
+const cameras = {{.Cameras}};
+const state = {};
+const images = {};
+const el = (tag, attrs = {}, ...children) => {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs);
+  e.append(...children);
+  return e;
+};
+
+function since(t) {
+  const s = Math.max(0, (Date.now() - new Date(t)) / 1000);
+  if (s < 60) return Math.round(s) + "s";
+  if (s < 3600) return Math.round(s / 60) + "m";
+  return Math.floor(s / 3600) + "h " + Math.round(s % 3600 / 60) + "m";
+}
+
+function section(id) {
+  let s = document.getElementById("camera-" + id);
+  if (!s) {
+    s = el("section", {id: "camera-" + id});
+    document.getElementById("cameras").append(s);
+  }
+  return s;
+}
+
+function render(id) {
+  const a = state[id];
+  const s = section(id);
+  s.replaceChildren();
+
+  const title = el("h2", {}, id);
+  if (a) title.append(el("span", {className: "free"}, a.counts.free + " / " + a.counts.total + " free"));
+  s.append(title);
+
+  if (!a) {
+    s.append(el("div", {className: "health muted"}, "no frames yet"));
+    return;
+  }
+
+  // reuse the image between renders, so it only loads for new frames
+  const src = base + "cameras/" + encodeURIComponent(id) + "/image?t=" + Date.parse(a.time);
+  if (!images[id] || images[id].getAttribute("src") !== src) images[id] = el("img", {src: src, alt: id});
+  s.append(images[id]);
+
+  const q = a.quality;
+  const health = q && !q.reliable
+    ? el("div", {className: "health bad"}, "unreliable: " + (q.issues || []).join(", "))
+    : el("div", {className: "health"}, "ok");
+  health.append(el("span", {className: "muted"}, " · last frame " + since(a.time) + " ago"));
+  s.append(health);
+
+  if (a.zones && a.zones.length) {
+    s.append(el("div", {className: "zones"}, ...a.zones.map(z => el("span", {}, (z.name || z.id) + ": " + z.free + "/" + z.total))));
+  }
+
+  const rows = a.spots.map(spot => el("tr", {},
+    el("td", {}, spot.name ? spot.id + " " + spot.name : spot.id),
+    el("td", {className: "status-" + spot.status}, spot.status.replace(/_/g, " ") + (spot.low_confidence ? " ?" : "") + (spot.violation ? " – " + spot.violation : "")),
+    el("td", {}, spot.occupied_since ? since(spot.occupied_since) : "")));
+  s.append(el("table", {}, el("tr", {}, el("th", {}, "Spot"), el("th", {}, "Status"), el("th", {}, "Dwell")), ...rows));
+}
+
+function logEvent(ev) {
+  const list = document.getElementById("events");
+  const cls = /problem|violation|overstay|full/.test(ev.type) ? "bad" : "";
+  list.prepend(el("li", {className: cls}, el("time", {}, new Date(ev.time).toLocaleString()), ev.message));
+  while (list.children.length > 50) list.lastChild.remove();
+}
+
+function connect() {
+  const source = new EventSource(base + "stream?events=*");
+  const status = document.getElementById("state");
+
+  source.onopen = () => { status.textContent = "live"; status.className = "ok"; };
+  source.onerror = () => { status.textContent = "reconnecting…"; status.className = "bad"; };
+
+  source.addEventListener("snapshot", e => {
+    const snap = JSON.parse(e.data);
+    document.getElementById("events").replaceChildren();
+    snap.events.forEach(logEvent);
+    for (const id of new Set([...cameras, ...Object.keys(snap.cameras)])) {
+      if (id === "") continue;
+      state[id] = snap.cameras[id];
+      render(id);
+    }
+  });
+
+  source.addEventListener("frame.analyzed", e => {
+    const ev = JSON.parse(e.data);
+    if (!ev.camera) return;
+    state[ev.camera] = ev.data;
+    render(ev.camera);
+  });
+
+  for (const type of ["spot.changed", "spot.violation", "spot.overstay", "lot.full", "lot.available", "camera.problem", "camera.recovered"]) {
+    source.addEventListener(type, e => logEvent(JSON.parse(e.data)));
+  }
+}
+
+connect();
+
+// keep the "ago" and dwell times fresh between frames
+setInterval(() => Object.keys(state).forEach(render), 30000);
+</script>
+</body>
+</html>
+`))
+
+type dashboardData struct {
+	Base    string
+	Cameras []string
+}
+
+// dashboard serves the live dashboard page.
+func (s *server) dashboard(w http.ResponseWriter, r *http.Request) {
+	cameras := make([]string, 0, len(s.settings.Cameras))
+	for id := range s.settings.Cameras {
+		cameras = append(cameras, id)
+	}
+	sort.Strings(cameras)
+
+	data := dashboardData{Cameras: cameras}
+	if base := s.basePath(r); base != "" {
+		data.Base = base + "/"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getCameraImage returns the last annotated image of a camera.
+func (s *server) getCameraImage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	a := s.cameras.get(id).lastAnalysis()
+	if a == nil || a.Image == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	jpeg.Encode(w, a.Image, nil)
+}
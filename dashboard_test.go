@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardIngress(t *testing.T) {
+	s := &server{settings: &Settings{Ingress: true, Cameras: map[string]*Camera{"yard": {}, "gate": {}}}}
+
+	tests := []struct {
+		remote string
+		base   string
+	}{
+		{ingressGateway + ":51234", "/api/hassio_ingress/abc/"},
+		// the ingress path is only trusted from the ingress gateway
+		{"192.0.2.1:51234", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Ingress-Path", "/api/hassio_ingress/abc/")
+		r.RemoteAddr = tt.remote
+
+		w := httptest.NewRecorder()
+		s.dashboard(w, r)
+
+		page := w.Body.String()
+		if w.Code != http.StatusOK || !strings.Contains(page, `const base = "`+tt.base+`";`) || !strings.Contains(page, `const cameras = ["gate","yard"];`) {
+			t.Fatalf("from %s: %d %s", tt.remote, w.Code, page)
+		}
+
+		// the image and stream URLs all start with the base
+		for _, url := range []string{`base + "cameras/"`, `base + "stream?`} {
+			if !strings.Contains(page, url) {
+				t.Errorf("no %s", url)
+			}
+		}
+
+		for _, absolute := range []string{`"/cameras`, `"/stream`, `src="/`, `href="/`} {
+			if strings.Contains(page, absolute) {
+				t.Errorf("absolute URL %s", absolute)
+			}
+		}
+	}
+}
@@ -38,3 +38,18 @@ func DrawStrokeText(imgGG *gg.Context, text string, x, y float64, textColor, str
 	imgGG.SetColor(textColor)
 	imgGG.DrawStringAnchored(text, x, y, 0.5, 0.5)
 }
+
+func DrawFilledPolygon(imgGG *gg.Context, p *poly.Poly, col color.Color) {
+	if len(p.XY) == 0 {
+		return
+	}
+
+	imgGG.MoveTo(p.XY[0].X, p.XY[0].Y)
+	for _, xy := range p.XY[1:] {
+		imgGG.LineTo(xy.X, xy.Y)
+	}
+	imgGG.ClosePath()
+
+	imgGG.SetColor(col)
+	imgGG.Fill()
+}
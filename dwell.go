@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dwellBuckets are the upper bounds of the dwell time histogram; longer
+// stays fall into a last, open bucket.
+var dwellBuckets = []time.Duration{
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	4 * time.Hour,
+	8 * time.Hour,
+	24 * time.Hour,
+}
+
+// DwellStats is the distribution of finished occupancy intervals of a spot.
+type DwellStats struct {
+	Count   int           `json:"count"`
+	Mean    Duration      `json:"mean"`
+	Max     Duration      `json:"max"`
+	Buckets []DwellBucket `json:"buckets"`
+
+	total time.Duration
+}
+
+// DwellBucket counts the stays up to Le; the last bucket has no bound.
+type DwellBucket struct {
+	Le    Duration `json:"le,omitzero"`
+	Count int      `json:"count"`
+}
+
+func (ds *DwellStats) add(d time.Duration) {
+	if ds.Buckets == nil {
+		ds.Buckets = make([]DwellBucket, len(dwellBuckets)+1)
+		for i, le := range dwellBuckets {
+			ds.Buckets[i].Le = Duration(le)
+		}
+	}
+
+	i := sort.Search(len(dwellBuckets), func(i int) bool { return d <= dwellBuckets[i] })
+	ds.Buckets[i].Count++
+
+	ds.Count++
+	ds.total += d
+	ds.Mean = Duration(ds.total / time.Duration(ds.Count))
+	ds.Max = max(ds.Max, Duration(d))
+}
+
+// spotTrack follows the status of one spot over the frames of a camera.
+type spotTrack struct {
+	name     string
+	status   string
+	since    time.Time
+	lastSeen time.Time
+
+	// known is false while the start of the current interval was not seen,
+	// e.g. a car that was already parked when tracking began
+	known      bool
+	overstayed bool
+
+	stats DwellStats
+}
+
+// trackDwell follows the occupancy intervals of the spots and sets the
+// current dwell time of occupied spots. It returns the spots that changed
+// status and those that just exceeded their maximum stay. Unreliable frames,
+// low confidence spots and frames older than the last one are ignored, so
+// they neither end nor start an interval.
+func (state *cameraState) trackDwell(a *Analysis, layout *Layout) (changed, overstayed []*SpotResult) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if a.Quality != nil && !a.Quality.Reliable {
+		return nil, nil
+	}
+
+	if state.tracks == nil {
+		state.tracks = map[string]*spotTrack{}
+	}
+
+	for i, spot := range a.Spots {
+		if spot.Status == StatusUnknown || spot.Status == StatusOutOfService || spot.LowConfidence {
+			continue
+		}
+
+		track, ok := state.tracks[spot.ID]
+		if !ok {
+			track = &spotTrack{status: spot.Status, since: a.Time}
+			state.tracks[spot.ID] = track
+		}
+
+		if a.Time.Before(track.lastSeen) {
+			continue
+		}
+
+		track.name = spot.Name
+		track.lastSeen = a.Time
+
+		if track.status != spot.Status {
+			if track.status == StatusOccupied && track.known {
+				track.stats.add(a.Time.Sub(track.since))
+			}
+
+			spot.Previous = track.status
+			spot.Dwell = Duration(a.Time.Sub(track.since))
+			changed = append(changed, spot)
+
+			track.status = spot.Status
+			track.since = a.Time
+			track.known = true
+			track.overstayed = false
+		}
+
+		if spot.Status != StatusOccupied {
+			continue
+		}
+
+		// without the start of the interval the dwell time is only a lower
+		// bound and there is no start to report
+		if track.known {
+			spot.OccupiedSince = track.since
+		}
+		spot.Dwell = Duration(a.Time.Sub(track.since))
+
+		if maxStay := layout.MaxStay(i); maxStay > 0 && time.Duration(spot.Dwell) > maxStay && !track.overstayed {
+			track.overstayed = true
+			overstayed = append(overstayed, spot)
+		}
+	}
+
+	return changed, overstayed
+}
+
+// MaxStay returns the maximum stay of the i-th spot: its own rule wins over
+// the limits of its zones, of which the shortest applies.
+func (l *Layout) MaxStay(i int) time.Duration {
+	spot := l.Spots[i]
+	if spot.Rule != nil && spot.Rule.MaxStay > 0 {
+		return time.Duration(spot.Rule.MaxStay)
+	}
+
+	var limit time.Duration
+	for _, zone := range spot.Zones {
+		if d := time.Duration(l.MaxStays[zone]); d > 0 && (limit == 0 || d < limit) {
+			limit = d
+		}
+	}
+
+	return limit
+}
+
+// publishDwell reports status changes and overstays of spots.
+func (s *server) publishDwell(camera string, a *Analysis, changed, overstayed []*SpotResult) {
+	name := camera
+	if name == "" {
+		name = "uploads"
+	}
+
+	for _, spot := range changed {
+		s.bus.Publish(Event{
+			Type:    EventSpotChanged,
+			Camera:  camera,
+			Time:    a.Time,
+			Message: fmt.Sprintf("Camera %s: spot %s is %s", name, spotLabel(spot), spot.Status),
+			Data:    spot,
+		})
+	}
+
+	for _, spot := range overstayed {
+		s.bus.Publish(Event{
+			Type:    EventSpotOverstay,
+			Camera:  camera,
+			Time:    a.Time,
+			Message: fmt.Sprintf("Camera %s: spot %s is occupied for %s", name, spotLabel(spot), time.Duration(spot.Dwell).Round(time.Minute)),
+			Data:    spot,
+		})
+	}
+}
+
+func spotLabel(spot *SpotResult) string {
+	if spot.Name != "" {
+		return spot.Name
+	}
+
+	return spot.ID
+}
+
+// SpotDwell is the current occupancy and dwell time distribution of a spot.
+type SpotDwell struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name,omitempty"`
+	Status        string     `json:"status"`
+	Since         time.Time  `json:"since"`
+	OccupiedSince time.Time  `json:"occupied_since,omitzero"`
+	Dwell         Duration   `json:"dwell,omitzero"`
+	Stats         DwellStats `json:"stats"`
+}
+
+// getDwell reports current dwell times and their distributions per spot.
+func (s *server) getDwell(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	state := s.cameras.get(id)
+
+	state.mu.Lock()
+	spots := make([]*SpotDwell, 0, len(state.tracks))
+	for spotID, track := range state.tracks {
+		sd := &SpotDwell{
+			ID:     spotID,
+			Name:   track.name,
+			Status: track.status,
+			Since:  track.since,
+			Stats:  track.stats,
+		}
+		sd.Stats.Buckets = slices.Clone(track.stats.Buckets)
+
+		// frames may come with their own time, so dwell is measured to
+		// the last frame instead of now
+		if track.status == StatusOccupied {
+			if track.known {
+				sd.OccupiedSince = track.since
+			}
+			sd.Dwell = Duration(track.lastSeen.Sub(track.since))
+		}
+
+		spots = append(spots, sd)
+	}
+	state.mu.Unlock()
+
+	slices.SortFunc(spots, func(a, b *SpotDwell) int { return strings.Compare(a.ID, b.ID) })
+
+	writeJSON(w, http.StatusOK, map[string]any{"camera": id, "spots": spots})
+}
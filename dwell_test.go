@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackDwell(t *testing.T) {
+	layout := &Layout{
+		Spots:    []*Spot{{ID: "a", Zones: []string{"visitors"}}},
+		MaxStays: map[string]Duration{"visitors": Duration(time.Hour)},
+	}
+
+	t0 := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+	state := &cameraState{}
+
+	frame := func(minutes int, status string) (*SpotResult, []*SpotResult, []*SpotResult) {
+		spot := &SpotResult{ID: "a", Status: status}
+		changed, overstayed := state.trackDwell(&Analysis{Time: t0.Add(time.Duration(minutes) * time.Minute), Spots: []*SpotResult{spot}}, layout)
+
+		return spot, changed, overstayed
+	}
+
+	// parked before tracking began: the start is not known
+	for _, minutes := range []int{0, 10} {
+		spot, changed, _ := frame(minutes, StatusOccupied)
+		if len(changed) != 0 || !spot.OccupiedSince.IsZero() || spot.Dwell != Duration(time.Duration(minutes)*time.Minute) {
+			t.Errorf("minute %d: changed %d, since %s, dwell %v", minutes, len(changed), spot.OccupiedSince, spot.Dwell)
+		}
+	}
+
+	spot, changed, _ := frame(20, StatusFree)
+	if len(changed) != 1 || spot.Previous != StatusOccupied || spot.Dwell != Duration(20*time.Minute) {
+		t.Errorf("left: changed %d, previous %s, dwell %v", len(changed), spot.Previous, spot.Dwell)
+	}
+
+	// an interval without its start is not in the stats
+	if stats := state.tracks["a"].stats; stats.Count != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	spot, changed, _ = frame(30, StatusOccupied)
+	if len(changed) != 1 || !spot.OccupiedSince.Equal(t0.Add(30*time.Minute)) || spot.Dwell != 0 {
+		t.Errorf("parked: changed %d, since %s, dwell %v", len(changed), spot.OccupiedSince, spot.Dwell)
+	}
+
+	// unreliable frames, unknown and low confidence spots and late frames
+	// don't end the interval
+	state.trackDwell(&Analysis{Time: t0.Add(40 * time.Minute), Quality: &Quality{}, Spots: []*SpotResult{{ID: "a", Status: StatusFree}}}, layout)
+	frame(50, StatusUnknown)
+	state.trackDwell(&Analysis{Time: t0.Add(60 * time.Minute), Spots: []*SpotResult{{ID: "a", Status: StatusFree, LowConfidence: true}}}, layout)
+
+	if _, _, overstayed := frame(91, StatusOccupied); len(overstayed) != 1 {
+		t.Errorf("%d overstays after 1h", len(overstayed))
+	}
+
+	if _, changed, _ := frame(80, StatusFree); len(changed) != 0 {
+		t.Error("a late frame changed the status")
+	}
+
+	// an overstay is reported once
+	if _, _, overstayed := frame(96, StatusOccupied); len(overstayed) != 0 {
+		t.Errorf("overstay reported again")
+	}
+
+	frame(100, StatusFree)
+
+	if stats := state.tracks["a"].stats; stats.Count != 1 || stats.Mean != Duration(70*time.Minute) || stats.Buckets[3].Count != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestDwellStats(t *testing.T) {
+	var ds DwellStats
+	for _, d := range []time.Duration{15 * time.Minute, 16 * time.Minute, 25 * time.Hour} {
+		ds.add(d)
+	}
+
+	if ds.Count != 3 || ds.Max != Duration(25*time.Hour) || ds.Mean != Duration((15*time.Minute+16*time.Minute+25*time.Hour)/3) {
+		t.Errorf("stats %+v", ds)
+	}
+
+	// bounds are inclusive, the last bucket is open
+	if ds.Buckets[0].Count != 1 || ds.Buckets[1].Count != 1 || ds.Buckets[len(dwellBuckets)].Count != 1 || ds.Buckets[len(dwellBuckets)].Le != 0 {
+		t.Errorf("buckets %+v", ds.Buckets)
+	}
+}
+
+func TestMaxStay(t *testing.T) {
+	layout := &Layout{
+		Spots: []*Spot{
+			{ID: "a", Zones: []string{"visitors", "short"}},
+			{ID: "b", Zones: []string{"short"}, Rule: &SpotRule{MaxStay: Duration(3 * time.Hour)}},
+			{ID: "c", Zones: []string{"staff"}},
+		},
+		MaxStays: map[string]Duration{"visitors": Duration(2 * time.Hour), "short": Duration(time.Hour)},
+	}
+
+	for i, want := range []time.Duration{time.Hour, 3 * time.Hour, 0} {
+		if got := layout.MaxStay(i); got != want {
+			t.Errorf("spot %d: %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestGetDwell(t *testing.T) {
+	s := &server{settings: &Settings{Cameras: map[string]*Camera{"yard": {}}}}
+
+	layout := &Layout{Spots: []*Spot{{ID: "a"}, {ID: "b"}}}
+	t0 := time.Date(2024, 5, 6, 8, 0, 0, 0, time.UTC)
+
+	state := s.cameras.get("yard")
+	state.trackDwell(&Analysis{Time: t0, Spots: []*SpotResult{{ID: "a", Status: StatusOccupied}, {ID: "b", Status: StatusFree}}}, layout)
+	state.trackDwell(&Analysis{Time: t0.Add(time.Hour), Spots: []*SpotResult{{ID: "a", Status: StatusOccupied}, {ID: "b", Status: StatusOccupied}}}, layout)
+	state.trackDwell(&Analysis{Time: t0.Add(90 * time.Minute), Spots: []*SpotResult{{ID: "a", Status: StatusOccupied}, {ID: "b", Status: StatusOccupied}}}, layout)
+
+	r := httptest.NewRequest(http.MethodGet, "/cameras/yard/dwell", nil)
+	r.SetPathValue("id", "yard")
+
+	w := httptest.NewRecorder()
+	s.getDwell(w, r)
+
+	var body struct {
+		Spots []map[string]any `json:"spots"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Spots) != 2 {
+		t.Fatalf("%s, %v", w.Body, err)
+	}
+
+	// a was occupied from the first frame on
+	if a := body.Spots[0]; a["occupied_since"] != nil || a["dwell"] != "1h30m0s" {
+		t.Errorf("a: %v", a)
+	}
+
+	if b := body.Spots[1]; b["occupied_since"] != "2024-05-06T09:00:00Z" || b["dwell"] != "30m0s" {
+		t.Errorf("b: %v", b)
+	}
+
+	r.SetPathValue("id", "gate")
+
+	w = httptest.NewRecorder()
+	if s.getDwell(w, r); w.Code != http.StatusNotFound {
+		t.Errorf("unknown camera: %d", w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	EventCameraProblem   = "camera.problem"
+	EventCameraRecovered = "camera.recovered"
+	EventSpotViolation   = "spot.violation"
+	EventSpotChanged     = "spot.changed"
+	EventSpotOverstay    = "spot.overstay"
+	EventFrameAnalyzed   = "frame.analyzed"
+	EventLotFull         = "lot.full"
+	EventLotAvailable    = "lot.available"
+)
+
+// Event is something notifiers and API clients may want to know about.
+type Event struct {
+	Type    string    `json:"type"`
+	Camera  string    `json:"camera,omitempty"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Data    any       `json:"data,omitempty"`
+}
+
+// Bus delivers events to subscribers.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*subscriber
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+// Subscribe registers fn for all future events. Subscribers are called
+// synchronously in publishing order and must not block. The returned
+// function unsubscribes fn again.
+func (b *Bus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{fn: fn}
+	b.subs = append(b.subs, sub)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		b.subs = slices.DeleteFunc(b.subs, func(s *subscriber) bool { return s == sub })
+	}
+}
+
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sub := range b.subs {
+		sub.fn(ev)
+	}
+}
+
+// eventLogSize is the number of recent events kept for new stream clients.
+const eventLogSize = 50
+
+// EventLog keeps the most recent events. Analyzed frames are left out, the
+// last analysis of each camera is kept by its state.
+type EventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *EventLog) add(ev Event) {
+	if ev.Type == EventFrameAnalyzed {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, ev)
+	if len(l.events) > eventLogSize {
+		l.events = slices.Delete(l.events, 0, len(l.events)-eventLogSize)
+	}
+}
+
+// Recent returns the logged events for which keep is true, oldest first.
+func (l *EventLog) Recent(keep func(Event) bool) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []Event{}
+	for _, ev := range l.events {
+		if keep(ev) {
+			events = append(events, ev)
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// EXIF tags used by the service.
+const (
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// exifInfo is the part of the EXIF metadata the service cares about.
+type exifInfo struct {
+	Orientation int
+	Time        time.Time
+}
+
+// readExif extracts orientation and capture time from JPEG, PNG, WebP and
+// TIFF files. Missing or broken metadata is not an error: the zero value is
+// returned instead.
+func readExif(data []byte) exifInfo {
+	tiff := findExif(data)
+	if tiff == nil {
+		return exifInfo{}
+	}
+
+	info, err := parseExif(tiff)
+	if err != nil {
+		return exifInfo{}
+	}
+
+	return info
+}
+
+// findExif returns the TIFF structured EXIF block embedded in data. A TIFF
+// file is such a block itself: its first IFD carries the tags.
+func findExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		// JPEG: walk the markers up to the start of scan looking for APP1
+		for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+			marker := data[i+1]
+			size := int(binary.BigEndian.Uint16(data[i+2:]))
+			if marker == 0xda || i+2+size > len(data) {
+				return nil
+			}
+
+			segment := data[i+4 : i+2+size]
+			if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+
+			i += 2 + size
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[i:]))
+			if i+12+size > len(data) {
+				return nil
+			}
+
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+size]
+			}
+
+			i += 12 + size
+		}
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			size := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if i+8+size > len(data) {
+				return nil
+			}
+
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+size], []byte("Exif\x00\x00"))
+			}
+
+			i += 8 + size + size%2
+		}
+	}
+
+	return nil
+}
+
+// parseExif reads IFD0 and the EXIF sub-IFD of a TIFF structured block.
+func parseExif(tiff []byte) (exifInfo, error) {
+	var info exifInfo
+
+	if len(tiff) < 8 {
+		return info, errors.New("exif block is too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return info, errors.New("bad exif byte order")
+	}
+
+	var dateTime, dateTimeOriginal, offset string
+
+	ifd0 := order.Uint32(tiff[4:])
+	err := walkIFD(tiff, order, ifd0, func(tag, typ uint16, count uint32, value []byte) {
+		switch tag {
+		case tagOrientation:
+			info.Orientation = int(order.Uint16(value))
+		case tagDateTime:
+			dateTime = exifString(tiff, order, typ, count, value)
+		case tagExifIFD:
+			walkIFD(tiff, order, order.Uint32(value), func(tag, typ uint16, count uint32, value []byte) {
+				switch tag {
+				case tagDateTimeOriginal:
+					dateTimeOriginal = exifString(tiff, order, typ, count, value)
+				case tagOffsetTimeOriginal:
+					offset = exifString(tiff, order, typ, count, value)
+				}
+			})
+		}
+	})
+	if err != nil {
+		return info, err
+	}
+
+	if dateTimeOriginal == "" {
+		dateTimeOriginal, offset = dateTime, ""
+	}
+
+	if dateTimeOriginal != "" {
+		if offset != "" {
+			info.Time, _ = time.Parse(exifTimeLayout+"-07:00", dateTimeOriginal+offset)
+		} else {
+			// EXIF times without an offset are the camera's local time
+			info.Time, _ = time.ParseInLocation(exifTimeLayout, dateTimeOriginal, time.Local)
+		}
+	}
+
+	return info, nil
+}
+
+// walkIFD calls fn for every entry of the IFD at offset. value holds the
+// 4 byte value/offset field of the entry.
+func walkIFD(tiff []byte, order binary.ByteOrder, offset uint32, fn func(tag, typ uint16, count uint32, value []byte)) error {
+	if int(offset)+2 > len(tiff) {
+		return errors.New("ifd offset out of range")
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+entries*12 > len(tiff) {
+		return errors.New("ifd is truncated")
+	}
+
+	for i := 0; i < entries; i++ {
+		entry := tiff[start+i*12:]
+		fn(order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:]), entry[8:12])
+	}
+
+	return nil
+}
+
+// exifString decodes an ASCII entry. Strings longer than 4 bytes are stored
+// at an offset instead of inline.
+func exifString(tiff []byte, order binary.ByteOrder, typ uint16, count uint32, value []byte) string {
+	const typeASCII = 2
+	if typ != typeASCII {
+		return ""
+	}
+
+	raw := value
+	if count > 4 {
+		offset := order.Uint32(value)
+		if uint64(offset)+uint64(count) > uint64(len(tiff)) {
+			return ""
+		}
+
+		raw = tiff[offset : offset+count]
+	} else if int(count) <= len(raw) {
+		raw = raw[:count]
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(raw), "\x00"))
+}
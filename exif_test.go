@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/image/tiff"
+)
+
+// exifBlock builds a TIFF structured EXIF block. Empty values are left out;
+// DateTimeOriginal and OffsetTimeOriginal go to the EXIF sub-IFD.
+func exifBlock(order binary.ByteOrder, orientation int, dateTime, original, offset string) []byte {
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    []byte
+	}
+
+	ascii := func(tag uint16, s string) entry {
+		return entry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+	}
+
+	var ifd0, sub []entry
+	if orientation != 0 {
+		v := make([]byte, 4)
+		order.PutUint16(v, uint16(orientation))
+		ifd0 = append(ifd0, entry{tagOrientation, 3, 1, v})
+	}
+
+	if dateTime != "" {
+		ifd0 = append(ifd0, ascii(tagDateTime, dateTime))
+	}
+
+	if original != "" {
+		sub = append(sub, ascii(tagDateTimeOriginal, original))
+	}
+
+	if offset != "" {
+		sub = append(sub, ascii(tagOffsetTimeOriginal, offset))
+	}
+
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+
+	subOffset := 8 + ifdSize(len(ifd0)+1)
+	if len(sub) > 0 {
+		v := make([]byte, 4)
+		order.PutUint32(v, uint32(subOffset))
+		ifd0 = append(ifd0, entry{tagExifIFD, 4, 1, v})
+	}
+
+	buf := make([]byte, subOffset+ifdSize(len(sub)))
+	if order == binary.LittleEndian {
+		copy(buf, "II")
+	} else {
+		copy(buf, "MM")
+	}
+	order.PutUint16(buf[2:], 42)
+	order.PutUint32(buf[4:], 8)
+
+	writeIFD := func(offset int, entries []entry) {
+		order.PutUint16(buf[offset:], uint16(len(entries)))
+		for i, e := range entries {
+			p := offset + 2 + 12*i
+			order.PutUint16(buf[p:], e.tag)
+			order.PutUint16(buf[p+2:], e.typ)
+			order.PutUint32(buf[p+4:], e.count)
+
+			if len(e.value) > 4 {
+				order.PutUint32(buf[p+8:], uint32(len(buf)))
+				buf = append(buf, e.value...)
+			} else {
+				copy(buf[p+8:p+12], e.value)
+			}
+		}
+	}
+
+	writeIFD(8, ifd0)
+	writeIFD(subOffset, sub)
+
+	return buf
+}
+
+// jpegWithExif encodes img as a JPEG with block in an APP1 segment.
+func jpegWithExif(t *testing.T, img image.Image, block []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := append([]byte("Exif\x00\x00"), block...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+len(segment)))
+
+	data := b.Bytes()
+
+	return append(append(append([]byte{}, data[:2]...), append(app1, segment...)...), data[2:]...)
+}
+
+// pngWithExif encodes img as a PNG with block in an eXIf chunk after IHDR.
+func pngWithExif(t *testing.T, img image.Image, block []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := make([]byte, 8, 12+len(block))
+	binary.BigEndian.PutUint32(chunk, uint32(len(block)))
+	copy(chunk[4:], "eXIf")
+	chunk = append(chunk, block...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	// signature and IHDR
+	data := b.Bytes()
+	head := 8 + 8 + 13 + 4
+
+	return append(append(append([]byte{}, data[:head]...), chunk...), data[head:]...)
+}
+
+// webpWithExif wraps block in a RIFF container with an EXIF chunk; it is
+// not a decodable image, findExif only walks the chunks.
+func webpWithExif(block []byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00")
+	data = append(data, make([]byte, 10)...)
+
+	data = append(data, "EXIF"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(block)))
+	data = append(data, block...)
+	if len(block)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	return data
+}
+
+func TestFindExif(t *testing.T) {
+	block := exifBlock(binary.BigEndian, 6, "", "", "")
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+
+	var tiffFile bytes.Buffer
+	if err := tiff.Encode(&tiffFile, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"jpeg", jpegWithExif(t, img, block), 6},
+		{"png", pngWithExif(t, img, block), 6},
+		{"webp", webpWithExif(block), 6},
+		{"tiff", block, 6},
+		{"tiff without orientation", tiffFile.Bytes(), 0},
+		{"empty exif", jpegWithExif(t, img, nil), 0},
+		{"truncated", jpegWithExif(t, img, block)[:20], 0},
+		{"garbage", []byte("not an image"), 0},
+	}
+
+	for _, tt := range tests {
+		if got := readExif(tt.data).Orientation; got != tt.want {
+			t.Errorf("%s: orientation %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExifTime(t *testing.T) {
+	msk := time.FixedZone("", 3*60*60)
+
+	tests := []struct {
+		name                       string
+		dateTime, original, offset string
+		want                       time.Time
+	}{
+		{"original with offset", "", "2024:05:06 07:08:09", "+03:00", time.Date(2024, 5, 6, 7, 8, 9, 0, msk)},
+		{"original without offset", "", "2024:05:06 07:08:09", "", time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)},
+		{"original wins", "2020:01:01 00:00:00", "2024:05:06 07:08:09", "", time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local)},
+		// the offset belongs to the original time, not to DateTime
+		{"datetime only", "2020:01:02 03:04:05", "", "+03:00", time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)},
+		{"broken", "", "yesterday", "", time.Time{}},
+		{"none", "", "", "", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			info, err := parseExif(exifBlock(order, 1, tt.dateTime, tt.original, tt.offset))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+
+			if !info.Time.Equal(tt.want) {
+				t.Errorf("%s, %v: time %v, want %v", tt.name, order, info.Time, tt.want)
+			}
+		}
+	}
+
+	if _, err := parseExif([]byte("XX\x00\x2a\x00\x00\x00\x08")); err == nil {
+		t.Error("a bad byte order parsed")
+	}
+}
+
+// testPattern returns a 3x2 image with a distinct color in every pixel.
+func testPattern() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := range 2 {
+		for x := range 3 {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 80), uint8(y * 80), 0, 0xff})
+		}
+	}
+
+	return img
+}
+
+// rotateCW and flipH are the primitives the EXIF orientations are defined
+// with.
+func rotateCW(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			dst.SetRGBA(b.Dy()-1-y, x, img.RGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+func flipH(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			dst.SetRGBA(b.Dx()-1-x, y, img.RGBAAt(x, y))
+		}
+	}
+
+	return dst
+}
+
+func TestOrient(t *testing.T) {
+	stored := testPattern()
+
+	cw := rotateCW(stored)
+	rot180 := rotateCW(cw)
+	ccw := rotateCW(rot180)
+
+	// what the viewer has to do with the stored image, see the EXIF spec
+	want := map[int]*image.RGBA{
+		0: stored,
+		1: stored,
+		2: flipH(stored),
+		3: rot180,
+		4: flipH(rot180),
+		5: flipH(cw),
+		6: cw,
+		7: flipH(ccw),
+		8: ccw,
+		9: stored,
+	}
+
+	for orientation, w := range want {
+		got := orient(stored, orientation)
+		if got.Bounds() != w.Bounds() {
+			t.Errorf("orientation %d: bounds %v, want %v", orientation, got.Bounds(), w.Bounds())
+
+			continue
+		}
+
+		for y := range w.Bounds().Dy() {
+			for x := range w.Bounds().Dx() {
+				if c := color.RGBAModel.Convert(got.At(x, y)); c != w.RGBAAt(x, y) {
+					t.Errorf("orientation %d: pixel (%d, %d) = %v, want %v", orientation, x, y, c, w.RGBAAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestRawUploadExif(t *testing.T) {
+	type decoded struct {
+		bounds image.Rectangle
+		pixel  color.RGBA
+		time   time.Time
+	}
+
+	results := make(chan decoded, 2)
+	q := NewQueue(QueueSettings{Workers: 1}, func(job *Job) error {
+		img, captured, err := decodeFrame(job.data)
+		if err != nil {
+			return err
+		}
+
+		results <- decoded{img.Bounds(), color.RGBAModel.Convert(img.At(0, 0)).(color.RGBA), captured}
+
+		return nil
+	})
+
+	s := &server{
+		settings: &Settings{Targets: map[string]*Target{"home": {}}},
+		queue:    q,
+	}
+
+	block := exifBlock(binary.LittleEndian, 6, "", "2024:05:06 07:08:09", "+03:00")
+	captured := time.Date(2024, 5, 6, 4, 8, 9, 0, time.UTC)
+
+	for _, upload := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"image/png", pngWithExif(t, testPattern(), block)},
+		{"application/octet-stream", pngWithExif(t, testPattern(), block)},
+		{"image/jpeg", jpegWithExif(t, testPattern(), block)},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/process?target=home", bytes.NewReader(upload.data))
+		r.Header.Set("Content-Type", upload.contentType)
+
+		w := httptest.NewRecorder()
+		s.processImage(w, r)
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d: %s", upload.contentType, w.Code, w.Body)
+		}
+
+		got := <-results
+		if got.bounds != image.Rect(0, 0, 2, 3) {
+			t.Errorf("%s: bounds %v, want the frame turned upright", upload.contentType, got.bounds)
+		}
+
+		if !got.time.Equal(captured) {
+			t.Errorf("%s: capture time %v, want %v", upload.contentType, got.time, captured)
+		}
+
+		// rotated clockwise the bottom left pixel comes first; JPEG is lossy
+		if want := testPattern().RGBAAt(0, 1); upload.contentType != "image/jpeg" && got.pixel != want {
+			t.Errorf("%s: top left pixel %v, want %v", upload.contentType, got.pixel, want)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profilePrior is how many samples the time of day profile is worth when
+// the weekday profile of a slot has few samples.
+const profilePrior = 4.0
+
+// ForecastSettings tune the occupancy forecast.
+type ForecastSettings struct {
+	// Weeks of history the seasonal profiles are built from.
+	Weeks int `json:"weeks"`
+
+	// Slot is the length of the time of day slots of the profiles, shorter
+	// than a day.
+	Slot Duration `json:"slot"`
+
+	// TrendDecay is how fast the deviation of the current state from the
+	// profile fades out of the forecast.
+	TrendDecay Duration `json:"trend_decay"`
+}
+
+func (fs *ForecastSettings) init() error {
+	if fs.Weeks <= 0 {
+		fs.Weeks = 8
+	}
+
+	if fs.Slot < 0 || fs.Slot >= Duration(24*time.Hour) {
+		return fmt.Errorf("slot must be within 0-24h, got %s", time.Duration(fs.Slot))
+	}
+
+	if fs.Slot == 0 {
+		fs.Slot = Duration(30 * time.Minute)
+	}
+
+	if fs.TrendDecay <= 0 {
+		fs.TrendDecay = Duration(time.Hour)
+	}
+
+	return nil
+}
+
+// slotCounts counts how often a spot was seen and seen free per slot.
+type slotCounts struct {
+	free, seen []int
+}
+
+func newSlotCounts(slots int) *slotCounts {
+	return &slotCounts{free: make([]int, slots), seen: make([]int, slots)}
+}
+
+// spotProfile is the seasonal occupancy profile of one spot: per weekday and
+// time of day, and per time of day alone for slots with few samples.
+type spotProfile struct {
+	weekday [7]*slotCounts
+	day     *slotCounts
+}
+
+// profile holds the seasonal profiles of all spots of a camera.
+type profile struct {
+	slot  time.Duration
+	slots int
+	spots map[string]*spotProfile
+}
+
+func newProfile(slot time.Duration) *profile {
+	return &profile{slot: slot, slots: int(24 * time.Hour / slot), spots: map[string]*spotProfile{}}
+}
+
+func (p *profile) index(t time.Time) (time.Weekday, int) {
+	t = t.Local()
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	return t.Weekday(), min(int(since/p.slot), p.slots-1)
+}
+
+func (p *profile) add(rec Record) {
+	wd, i := p.index(rec.Time)
+
+	count := func(ids []string, free bool) {
+		for _, id := range ids {
+			sp, ok := p.spots[id]
+			if !ok {
+				sp = &spotProfile{day: newSlotCounts(p.slots)}
+				for d := range sp.weekday {
+					sp.weekday[d] = newSlotCounts(p.slots)
+				}
+				p.spots[id] = sp
+			}
+
+			sp.weekday[wd].seen[i]++
+			sp.day.seen[i]++
+			if free {
+				sp.weekday[wd].free[i]++
+				sp.day.free[i]++
+			}
+		}
+	}
+
+	count(rec.Free, true)
+	count(rec.Occupied, false)
+}
+
+// free returns the probability from the profile that a spot is free at t.
+// The weekday profile is shrunk towards the time of day profile, which is
+// itself shrunk towards one half.
+func (p *profile) free(id string, t time.Time) float64 {
+	sp, ok := p.spots[id]
+	if !ok {
+		return 0.5
+	}
+
+	wd, i := p.index(t)
+
+	day := (float64(sp.day.free[i]) + 1) / (float64(sp.day.seen[i]) + 2)
+	week := sp.weekday[wd]
+
+	return (float64(week.free[i]) + profilePrior*day) / (float64(week.seen[i]) + profilePrior)
+}
+
+// forecastFree predicts the probability that a spot is free at at. When the
+// spot was observed at now, the deviation of that observation from the
+// profile carries over into the near future.
+func (p *profile) forecastFree(id string, at, now time.Time, observed string, decay time.Duration) float64 {
+	prob := p.free(id, at)
+
+	if observed == StatusFree || observed == StatusOccupied {
+		actual := 0.0
+		if observed == StatusFree {
+			actual = 1
+		}
+
+		ahead := max(at.Sub(now), 0)
+		prob += (actual - p.free(id, now)) * math.Exp(-float64(ahead)/float64(decay))
+	}
+
+	return min(max(prob, 0), 1)
+}
+
+// statuses returns the spot statuses of a record.
+func (rec *Record) statuses() map[string]string {
+	m := make(map[string]string, len(rec.Free)+len(rec.Occupied))
+	for _, id := range rec.Free {
+		m[id] = StatusFree
+	}
+
+	for _, id := range rec.Occupied {
+		m[id] = StatusOccupied
+	}
+
+	return m
+}
+
+// latestBefore returns the last record at or before t that is not older than
+// maxAge.
+func latestBefore(records []Record, t time.Time, maxAge time.Duration) *Record {
+	i := sort.Search(len(records), func(i int) bool { return records[i].Time.After(t) })
+	if i == 0 || t.Sub(records[i-1].Time) > maxAge {
+		return nil
+	}
+
+	return &records[i-1]
+}
+
+// SpotForecast is the predicted chance that a spot is free.
+type SpotForecast struct {
+	ID   string  `json:"id"`
+	Name string  `json:"name,omitempty"`
+	Free float64 `json:"free"`
+}
+
+// ZoneForecast is the expected number of free spots of a zone and the chance
+// that at least one of them is free.
+type ZoneForecast struct {
+	ID           string  `json:"id,omitempty"`
+	Name         string  `json:"name,omitempty"`
+	Total        int     `json:"total"`
+	ExpectedFree float64 `json:"expected_free"`
+	AnyFree      float64 `json:"any_free"`
+}
+
+func (zf *ZoneForecast) add(free float64) {
+	zf.Total++
+	zf.ExpectedFree += free
+
+	// spots are taken as independent, which overstates the chance a bit
+	// when the whole lot fills up at once
+	zf.AnyFree = 1 - (1-zf.AnyFree)*(1-free)
+}
+
+func (zf *ZoneForecast) String() string {
+	name := zf.Name
+	if name == "" {
+		name = zf.ID
+	}
+
+	return fmt.Sprintf("%s: %.1f of %d free, %.0f%% chance of a free spot", name, zf.ExpectedFree, zf.Total, zf.AnyFree*100)
+}
+
+// Forecast is the predicted occupancy of a camera's spots at a future time.
+type Forecast struct {
+	Camera  string          `json:"camera"`
+	At      time.Time       `json:"at"`
+	Samples int             `json:"samples"`
+	Spots   []*SpotForecast `json:"spots"`
+	Zones   []*ZoneForecast `json:"zones,omitempty"`
+	Lot     *ZoneForecast   `json:"lot"`
+}
+
+func (f *Forecast) String() string {
+	lines := []string{fmt.Sprintf("Forecast for %s at %s:", f.Camera, f.At.Local().Format("Mon 15:04"))}
+
+	lines = append(lines, f.Lot.String())
+	for _, zf := range f.Zones {
+		lines = append(lines, zf.String())
+	}
+
+	if f.Samples == 0 {
+		lines = append(lines, "no history yet, the forecast is a guess")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// forecast predicts the occupancy of a camera's layout at at.
+func (s *server) forecast(camera string, at time.Time) (*Forecast, error) {
+	settings := &s.settings.Forecast
+	now := time.Now()
+
+	records, err := s.history.Read(camera, now.AddDate(0, 0, -7*settings.Weeks), now)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newProfile(time.Duration(settings.Slot))
+	for _, rec := range records {
+		p.add(rec)
+	}
+
+	var observed map[string]string
+	if rec := latestBefore(records, now, time.Duration(settings.Slot)); rec != nil {
+		observed = rec.statuses()
+	}
+
+	layout := s.layoutFor(camera)
+
+	f := &Forecast{Camera: camera, At: at, Samples: len(records), Lot: &ZoneForecast{Name: "all spots"}}
+
+	zones := map[string]*ZoneForecast{}
+	for _, id := range layout.ZoneIDs() {
+		zf := &ZoneForecast{ID: id, Name: layout.ZoneName(id)}
+		zones[id] = zf
+		f.Zones = append(f.Zones, zf)
+	}
+
+	for _, spot := range layout.Spots {
+		if spot.OutOfService {
+			continue
+		}
+
+		free := p.forecastFree(spot.ID, at, now, observed[spot.ID], time.Duration(settings.TrendDecay))
+
+		f.Spots = append(f.Spots, &SpotForecast{ID: spot.ID, Name: spot.Name, Free: free})
+		f.Lot.add(free)
+
+		for _, zone := range spot.Zones {
+			zones[zone].add(free)
+		}
+	}
+
+	return f, nil
+}
+
+// parseForecastTime takes an RFC 3339 time, a time of day like "09:00",
+// meaning its next occurrence, or a duration from now like "2h".
+func parseForecastTime(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("15:04", v, time.Local); err == nil {
+		now = now.Local()
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if at.Before(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+
+		return at, nil
+	}
+
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("bad time %q, want RFC 3339, HH:MM or a duration", v)
+}
+
+// getForecast reports the predicted occupancy at ?at=. With ?format=text the
+// answer is the message the /forecast bot command replies with.
+func (s *server) getForecast(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if s.history == nil {
+		http.Error(w, "history is not configured", http.StatusNotFound)
+
+		return
+	}
+
+	at := time.Now()
+	if v := r.FormValue("at"); v != "" {
+		var err error
+		if at, err = parseForecastTime(v, at); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	f, err := s.forecast(id, at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, f.String())
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, f)
+}
+
+// Backtest is the accuracy of forecasts made horizon ahead over past days,
+// each day predicted from the history before it. The persistence baseline
+// assumes every spot keeps its status.
+type Backtest struct {
+	Camera              string   `json:"camera"`
+	Days                int      `json:"days"`
+	Horizon             Duration `json:"horizon"`
+	Samples             int      `json:"samples"`
+	Brier               float64  `json:"brier"`
+	Accuracy            float64  `json:"accuracy"`
+	PersistenceAccuracy float64  `json:"persistence_accuracy"`
+	FreeCountError      float64  `json:"free_count_error"`
+}
+
+// backtest replays the forecast over the last days of history, taking one
+// sample per slot.
+func (s *server) backtest(camera string, days int, horizon time.Duration) (*Backtest, error) {
+	settings := &s.settings.Forecast
+	slot := time.Duration(settings.Slot)
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	testFrom := today.AddDate(0, 0, -days)
+
+	records, err := s.history.Read(camera, testFrom.AddDate(0, 0, -7*settings.Weeks), today)
+	if err != nil {
+		return nil, err
+	}
+
+	bt := &Backtest{Camera: camera, Days: days, Horizon: Duration(horizon)}
+
+	var brier, countErr float64
+	var correct, persistent, lotSamples int
+
+	for day := testFrom; day.Before(today); day = day.AddDate(0, 0, 1) {
+		trainFrom := day.AddDate(0, 0, -7*settings.Weeks)
+
+		p := newProfile(slot)
+		for _, rec := range records {
+			if !rec.Time.Before(trainFrom) && rec.Time.Before(day) {
+				p.add(rec)
+			}
+		}
+
+		next := day.AddDate(0, 0, 1)
+		for at := day; at.Before(next); at = at.Add(slot) {
+			actual := latestBefore(records, at, slot)
+			if actual == nil || actual.Time.Before(day) {
+				continue
+			}
+
+			made := at.Add(-horizon)
+
+			var observed map[string]string
+			if rec := latestBefore(records, made, slot); rec != nil {
+				observed = rec.statuses()
+			}
+
+			var expected float64
+			for id, status := range actual.statuses() {
+				free := p.forecastFree(id, at, made, observed[id], time.Duration(settings.TrendDecay))
+
+				outcome := 0.0
+				if status == StatusFree {
+					outcome = 1
+				}
+
+				brier += (free - outcome) * (free - outcome)
+				expected += free
+
+				if (free > 0.5) == (status == StatusFree) {
+					correct++
+				}
+
+				// spots not seen when the forecast was made fall back
+				// to the profile
+				was, ok := observed[id]
+				if !ok && p.free(id, at) > 0.5 || ok && was == StatusFree {
+					was = StatusFree
+				} else {
+					was = StatusOccupied
+				}
+
+				if was == status {
+					persistent++
+				}
+
+				bt.Samples++
+			}
+
+			countErr += math.Abs(expected - float64(len(actual.Free)))
+			lotSamples++
+		}
+	}
+
+	if bt.Samples == 0 {
+		return nil, errors.New("not enough history to backtest")
+	}
+
+	n := float64(bt.Samples)
+	bt.Brier = brier / n
+	bt.Accuracy = float64(correct) / n
+	bt.PersistenceAccuracy = float64(persistent) / n
+	bt.FreeCountError = countErr / float64(lotSamples)
+
+	return bt, nil
+}
+
+// getBacktest reports the forecast accuracy over the last ?days= days for
+// forecasts made ?horizon= ahead.
+func (s *server) getBacktest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if s.history == nil {
+		http.Error(w, "history is not configured", http.StatusNotFound)
+
+		return
+	}
+
+	// more days than the profiles look back would replay the same weeks
+	// and take longer the more is asked
+	days := 7
+	if v := r.FormValue("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 1 {
+			http.Error(w, "bad days", http.StatusBadRequest)
+
+			return
+		}
+
+		if limit := 7 * s.settings.Forecast.Weeks; days > limit {
+			http.Error(w, fmt.Sprintf("days must be at most %d", limit), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	horizon := time.Hour
+	if v := r.FormValue("horizon"); v != "" {
+		var err error
+		if horizon, err = time.ParseDuration(v); err != nil || horizon < 0 {
+			http.Error(w, "bad horizon", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	bt, err := s.backtest(id, days, horizon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bt)
+}
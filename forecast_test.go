@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// officeFree is the synthetic pattern of spot "a": taken on weekdays from
+// 08:00 to 18:00 and free otherwise. Spot "b" is always taken.
+func officeFree(t time.Time) bool {
+	weekend := t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+
+	return weekend || t.Hour() < 8 || t.Hour() >= 18
+}
+
+// syntheticRecords returns one record per half hour slot for the given days
+// before until.
+func syntheticRecords(until time.Time, days int) []Record {
+	var records []Record
+
+	start := time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -days)
+	for d := range days {
+		day := start.AddDate(0, 0, d)
+		for slot := range 48 {
+			t := time.Date(day.Year(), day.Month(), day.Day(), slot/2, slot%2*30, 0, 0, time.Local)
+
+			rec := Record{Time: t, Occupied: []string{"b"}}
+			if officeFree(t) {
+				rec.Free = []string{"a"}
+			} else {
+				rec.Occupied = append(rec.Occupied, "a")
+			}
+
+			records = append(records, rec)
+		}
+	}
+
+	return records
+}
+
+// monday returns 4 weeks of history ending on a Monday and that Monday.
+func monday() (*profile, time.Time) {
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.Local)
+
+	p := newProfile(30 * time.Minute)
+	for _, rec := range syntheticRecords(day, 28) {
+		p.add(rec)
+	}
+
+	return p, day
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestProfileFree(t *testing.T) {
+	p, day := monday()
+
+	// at 10:00 "a" was free on the 8 weekend days of 28: the time of day
+	// profile is (8+1)/(28+2) and the 4 Mondays, all taken, are shrunk
+	// towards it
+	timeOfDay := 9.0 / 30
+	if got, want := p.free("a", day.Add(10*time.Hour)), (0+profilePrior*timeOfDay)/(4+profilePrior); !near(got, want) {
+		t.Errorf("monday 10:00: %g, want %g", got, want)
+	}
+
+	saturday := day.AddDate(0, 0, 5).Add(10 * time.Hour)
+	if got, want := p.free("a", saturday), (4+profilePrior*timeOfDay)/(4+profilePrior); !near(got, want) {
+		t.Errorf("saturday 10:00: %g, want %g", got, want)
+	}
+
+	if got := p.free("a", day.Add(3*time.Hour)); got < 0.95 {
+		t.Errorf("monday 03:00: %g, want nearly free", got)
+	}
+
+	if got := p.free("b", day.Add(3*time.Hour)); got > 0.05 {
+		t.Errorf("spot b: %g, want nearly taken", got)
+	}
+
+	if got := p.free("unknown", day); got != 0.5 {
+		t.Errorf("unknown spot: %g, want 0.5", got)
+	}
+}
+
+func TestForecastFree(t *testing.T) {
+	p, day := monday()
+	decay := time.Hour
+
+	// "a" is unexpectedly free on monday at 10:00
+	now := day.Add(10 * time.Hour)
+	base := p.free("a", now)
+	deviation := 1 - base
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		observed string
+		want     float64
+	}{
+		{"now", now, StatusFree, 1},
+		{"one decay later", now.Add(decay), StatusFree, p.free("a", now.Add(decay)) + deviation*math.Exp(-1)},
+		{"three decays later", now.Add(3 * decay), StatusFree, p.free("a", now.Add(3*decay)) + deviation*math.Exp(-3)},
+		{"next week", now.AddDate(0, 0, 7), StatusFree, base},
+		{"not observed", now.Add(decay), "", p.free("a", now.Add(decay))},
+		{"unknown status", now.Add(decay), StatusUnknown, p.free("a", now.Add(decay))},
+		// the past is forecast like now
+		{"before now", now.Add(-decay), StatusFree, p.free("a", now.Add(-decay)) + deviation},
+	}
+
+	for _, tt := range tests {
+		if got := p.forecastFree("a", tt.at, now, tt.observed, decay); !near(got, tt.want) {
+			t.Errorf("%s: %g, want %g", tt.name, got, tt.want)
+		}
+	}
+
+	// the deviation fades out: every hour the forecast is closer to the
+	// profile
+	last := math.Inf(1)
+	for h := range 6 {
+		at := now.Add(time.Duration(h) * time.Hour)
+		gap := p.forecastFree("a", at, now, StatusFree, decay) - p.free("a", at)
+		if gap <= 0 || gap >= last {
+			t.Errorf("%d hours ahead: %g above the profile, %g an hour before", h, gap, last)
+		}
+		last = gap
+	}
+
+	// taken at night when it is nearly always free, clamped at zero
+	night := day.Add(3 * time.Hour)
+	if got := p.forecastFree("a", night, night, StatusOccupied, decay); got != 0 {
+		t.Errorf("taken at night: %g, want 0", got)
+	}
+}
+
+// historyServer returns a server with a camera "yard" whose history holds
+// the synthetic pattern of the last weeks.
+func historyServer(t *testing.T, weeks int) *server {
+	t.Helper()
+
+	s := &server{
+		settings: &Settings{
+			Cameras: map[string]*Camera{"yard": {Layout: "lot"}},
+			Layouts: map[string]*Layout{"lot": {
+				Spots: []*Spot{{ID: "a", Zones: []string{"office"}}, {ID: "b"}},
+				Zones: map[string]string{"office": "Office"},
+			}},
+			Forecast: ForecastSettings{Weeks: weeks},
+		},
+		history: NewHistory(HistorySettings{Dir: t.TempDir()}),
+	}
+	if err := s.settings.Forecast.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rec := range syntheticRecords(time.Now(), 7*weeks) {
+		a := &Analysis{Time: rec.Time}
+		for _, id := range rec.Free {
+			a.Spots = append(a.Spots, &SpotResult{ID: id, Status: StatusFree})
+		}
+		for _, id := range rec.Occupied {
+			a.Spots = append(a.Spots, &SpotResult{ID: id, Status: StatusOccupied})
+		}
+
+		if err := s.history.Append("yard", a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return s
+}
+
+func TestBacktest(t *testing.T) {
+	s := historyServer(t, 3)
+
+	bt, err := s.backtest("yard", 7, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 7 days of 48 slots with 2 spots
+	if bt.Samples != 7*48*2 {
+		t.Errorf("samples = %d, want %d", bt.Samples, 7*48*2)
+	}
+
+	// the pattern repeats every week, so the forecast is nearly always
+	// right, while assuming no change misses the hours after 08:00 and
+	// 18:00 on weekdays
+	if bt.Accuracy < 0.98 || bt.Brier > 0.05 {
+		t.Errorf("accuracy %g, brier %g, want a nearly perfect forecast", bt.Accuracy, bt.Brier)
+	}
+
+	if bt.PersistenceAccuracy >= bt.Accuracy {
+		t.Errorf("persistence accuracy %g is not below the forecast's %g", bt.PersistenceAccuracy, bt.Accuracy)
+	}
+
+	if bt.FreeCountError > 0.2 {
+		t.Errorf("free count error %g", bt.FreeCountError)
+	}
+
+	if _, err := s.backtest("empty", 7, time.Hour); err == nil {
+		t.Error("a backtest without history succeeded")
+	}
+}
+
+func TestGetBacktestDays(t *testing.T) {
+	s := historyServer(t, 1)
+
+	for _, tt := range []struct {
+		query string
+		code  int
+	}{
+		{"days=1&horizon=30m", http.StatusOK},
+		{"days=7", http.StatusOK},
+		{"days=8", http.StatusBadRequest},
+		{"days=100000", http.StatusBadRequest},
+		{"days=0", http.StatusBadRequest},
+		{"horizon=-1h", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/cameras/yard/forecast/backtest?"+tt.query, nil)
+		r.SetPathValue("id", "yard")
+
+		w := httptest.NewRecorder()
+		if s.getBacktest(w, r); w.Code != tt.code {
+			t.Errorf("%s: %d %s, want %d", tt.query, w.Code, w.Body, tt.code)
+		}
+	}
+}
+
+func TestForecastSlot(t *testing.T) {
+	for _, slot := range []time.Duration{-time.Minute, 24 * time.Hour, 25 * time.Hour} {
+		fs := &ForecastSettings{Slot: Duration(slot)}
+		if err := fs.init(); err == nil {
+			t.Errorf("slot %s was accepted", slot)
+		}
+	}
+
+	fs := &ForecastSettings{}
+	if err := fs.init(); err != nil || fs.Slot != Duration(30*time.Minute) {
+		t.Errorf("default slot %v, %v", fs.Slot, err)
+	}
+
+	// a slot longer than half a day takes the whole day
+	fs = &ForecastSettings{Slot: Duration(23 * time.Hour)}
+	if err := fs.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newProfile(time.Duration(fs.Slot))
+	day := time.Date(2024, 5, 6, 0, 0, 0, 0, time.Local)
+	for _, at := range []time.Time{day, day.Add(23*time.Hour + 59*time.Minute)} {
+		if _, slot := p.index(at); slot != 0 {
+			t.Errorf("%s is in slot %d", at, slot)
+		}
+	}
+}
+
+func TestForecastText(t *testing.T) {
+	s := historyServer(t, 1)
+
+	f, err := s.forecast("yard", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Samples == 0 || len(f.Spots) != 2 || f.Lot.Total != 2 || len(f.Zones) != 1 || f.Zones[0].Total != 1 {
+		t.Fatalf("forecast = %+v", f)
+	}
+
+	text := f.String()
+	for _, want := range []string{"Forecast for yard", "all spots:", "Office:"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text %q does not contain %q", text, want)
+		}
+	}
+}
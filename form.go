@@ -0,0 +1,60 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+)
+
+var formTemplate = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form action="{{.Base}}/process" method="post" enctype="multipart/form-data">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<select name="target">
+{{range .Targets}}<option value="{{.}}"{{if eq . $.Target}} selected{{end}}>{{.}}</option>
+{{end}}</select>
+<input type="checkbox" name="day" value="1"{{if .Day}} checked{{end}}> is day
+<input type="hidden" name="day" value="0">
+<input type="file" name="file" />
+<input type="submit" value="Upload" />
+</form>
+</body>
+</html>
+`))
+
+type formData struct {
+	Base      string
+	Message   string
+	CSRFToken string
+	Targets   []string
+	Target    string
+	Day       bool
+}
+
+// renderForm writes the upload form, keeping the previously submitted values.
+func (s *server) renderForm(w http.ResponseWriter, r *http.Request, message string) {
+	targets := make([]string, 0, len(s.settings.Targets))
+	for name := range s.settings.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	key, _ := requestKey(r)
+
+	data := formData{
+		Base:      s.basePath(r),
+		Message:   message,
+		CSRFToken: csrfToken(key),
+		Targets:   targets,
+		Target:    r.FormValue("target"),
+		Day:       r.FormValue("day") != "0",
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := formTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ad/go-parking/poly"
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// heatmapAlpha is the opacity of the spot fill over the camera image.
+const heatmapAlpha = 150
+
+// HeatmapSchedule posts the heatmap of a camera to a target at a time of
+// day, e.g. every monday at 09:00 for the past week.
+type HeatmapSchedule struct {
+	Camera string   `json:"camera"`
+	Target string   `json:"target"`
+	Period string   `json:"period"`
+	At     string   `json:"at"`
+	Days   []string `json:"days,omitempty"`
+
+	window *Window
+}
+
+func (hs *HeatmapSchedule) init() error {
+	if hs.Period == "" {
+		hs.Period = "day"
+	}
+
+	if _, _, err := heatmapPeriod(hs.Period, "", "", time.Now()); err != nil {
+		return err
+	}
+
+	// a one minute window at the posting time
+	at, err := parseClock(hs.At)
+	if err != nil {
+		return err
+	}
+
+	hs.window = &Window{Days: hs.Days, From: hs.At, To: fmt.Sprintf("%02d:%02d", (at+1)/60%24, (at+1)%60)}
+
+	return hs.window.init()
+}
+
+// heatmapPeriod resolves a named period ending now, "day", "week" or
+// "month", or a custom range given by from and to as RFC 3339 times or dates.
+func heatmapPeriod(period, from, to string, now time.Time) (time.Time, time.Time, error) {
+	if from != "" {
+		start, err := parseHeatmapTime(from)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+
+		end := now
+		if to != "" {
+			if end, err = parseHeatmapTime(to); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+		}
+
+		if !start.Before(end) {
+			return time.Time{}, time.Time{}, errors.New("from must be before to")
+		}
+
+		return start, end, nil
+	}
+
+	switch period {
+	case "", "day":
+		return now.AddDate(0, 0, -1), now, nil
+	case "week":
+		return now.AddDate(0, 0, -7), now, nil
+	case "month":
+		return now.AddDate(0, -1, 0), now, nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q", period)
+}
+
+func parseHeatmapTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, want RFC 3339 or YYYY-MM-DD", v)
+	}
+
+	return t, nil
+}
+
+// occupancyRates returns the share of observations in which each spot was
+// occupied.
+func occupancyRates(records []Record) map[string]float64 {
+	occupied := map[string]int{}
+	seen := map[string]int{}
+
+	for _, rec := range records {
+		for _, id := range rec.Free {
+			seen[id]++
+		}
+
+		for _, id := range rec.Occupied {
+			seen[id]++
+			occupied[id]++
+		}
+	}
+
+	rates := make(map[string]float64, len(seen))
+	for id, n := range seen {
+		rates[id] = float64(occupied[id]) / float64(n)
+	}
+
+	return rates
+}
+
+// heatColor maps an occupancy rate to green, yellow and red.
+func heatColor(rate float64, alpha uint8) color.NRGBA {
+	rate = min(max(rate, 0), 1)
+
+	if rate < 0.5 {
+		return color.NRGBA{uint8(510 * rate), 200, 0, alpha}
+	}
+
+	return color.NRGBA{255, uint8(200 * (2 - 2*rate)), 0, alpha}
+}
+
+// renderHeatmap fills every spot of layout over frame with the color of its
+// occupancy rate and adds per-spot percentages, a title and a legend. Spots
+// without observations are only outlined. The privacy regions of the frame
+// are hidden; without a frame the spots are drawn on a dark background.
+func renderHeatmap(frame *image.RGBA, layout *Layout, rates map[string]float64, title string, font *truetype.Font) *image.RGBA {
+	var imgRGBA *image.RGBA
+	if frame != nil {
+		imgRGBA = image.NewRGBA(frame.Bounds())
+		copy(imgRGBA.Pix, frame.Pix)
+		layout.hidePrivate(imgRGBA, nil)
+	} else {
+		_, max := poly.MinMaxMany(layout.Polygons())
+		imgRGBA = image.NewRGBA(image.Rect(0, 0, int(math.Ceil(max.X))+40, int(math.Ceil(max.Y))+40))
+		for i := range imgRGBA.Pix {
+			imgRGBA.Pix[i] = 40
+			if i%4 == 3 {
+				imgRGBA.Pix[i] = 255
+			}
+		}
+	}
+
+	imgGG := gg.NewContextForRGBA(imgRGBA)
+
+	imgGG.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: 18}))
+
+	for _, spot := range layout.Spots {
+		rate, ok := rates[spot.ID]
+		if !ok {
+			DrawPolygon(imgGG, spot.Poly, color.RGBA{200, 200, 200, 255}, 2)
+
+			continue
+		}
+
+		DrawFilledPolygon(imgGG, spot.Poly, heatColor(rate, heatmapAlpha))
+		DrawPolygon(imgGG, spot.Poly, color.RGBA(heatColor(rate, 255)), 2)
+
+		center := spot.Poly.Center()
+		DrawStrokeText(imgGG, fmt.Sprintf("%.0f%%", rate*100), center.X, center.Y, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}, 3)
+	}
+
+	drawLegend(imgGG, font, title)
+
+	return imgGG.Image().(*image.RGBA)
+}
+
+// drawLegend draws the title and the color scale in the top left corner.
+func drawLegend(imgGG *gg.Context, font *truetype.Font, title string) {
+	const (
+		barWidth  = 300.0
+		barHeight = 16.0
+		margin    = 20.0
+	)
+
+	imgGG.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: 22}))
+	w, h := imgGG.MeasureString(title)
+	w = max(w, barWidth)
+
+	imgGG.SetColor(color.RGBA{0, 0, 0, 170})
+	imgGG.DrawRectangle(0, 0, w+2*margin, h+barHeight+4*margin)
+	imgGG.Fill()
+
+	imgGG.SetColor(color.White)
+	imgGG.DrawStringAnchored(title, margin, margin+h/2, 0, 0.35)
+
+	top := h + 1.5*margin
+	for x := 0.0; x < barWidth; x++ {
+		imgGG.SetColor(heatColor(x/barWidth, 255))
+		imgGG.DrawRectangle(margin+x, top, 1, barHeight)
+		imgGG.Fill()
+	}
+
+	imgGG.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: 14}))
+	imgGG.SetColor(color.White)
+	for _, mark := range []float64{0, 0.5, 1} {
+		imgGG.DrawStringAnchored(fmt.Sprintf("%.0f%%", mark*100), margin+mark*barWidth, top+barHeight+12, 0.5, 0.5)
+	}
+}
+
+// heatmap renders the occupancy heatmap of a camera between from and to.
+func (s *server) heatmap(camera string, from, to time.Time) (*image.RGBA, error) {
+	records, err := s.history.Read(camera, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("%s occupancy %s – %s", camera, from.Local().Format("Jan 2 15:04"), to.Local().Format("Jan 2 15:04"))
+	if len(records) == 0 {
+		title += ", no data"
+	}
+
+	frame := s.cameras.get(camera).lastFrame()
+
+	return renderHeatmap(frame, s.layoutFor(camera), occupancyRates(records), title, s.settings.Theme.font), nil
+}
+
+// getHeatmap returns the heatmap as JPEG for ?period=day|week|month or a
+// custom ?from=&to= range.
+func (s *server) getHeatmap(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if s.history == nil {
+		http.Error(w, "history is not configured", http.StatusNotFound)
+
+		return
+	}
+
+	from, to, err := heatmapPeriod(r.FormValue("period"), r.FormValue("from"), r.FormValue("to"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	img, err := s.heatmap(id, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	if err := jpeg.Encode(w, img, nil); err != nil {
+		fmt.Printf("could not write heatmap: %s\n", err)
+	}
+}
+
+// runHeatmaps posts the scheduled heatmaps, checking the schedules once a
+// minute.
+func (s *server) runHeatmaps() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	posted := map[*HeatmapSchedule]time.Time{}
+
+	for now := range ticker.C {
+		for _, hs := range s.settings.Heatmaps {
+			if !hs.window.covers(now) || now.Sub(posted[hs]) < time.Hour {
+				continue
+			}
+			posted[hs] = now
+
+			from, to, _ := heatmapPeriod(hs.Period, "", "", now)
+
+			img, err := s.heatmap(hs.Camera, from, to)
+			if err == nil {
+				caption := fmt.Sprintf("%s occupancy, last %s", hs.Camera, strings.ToLower(hs.Period))
+				err = s.settings.Targets[hs.Target].notify(&Notification{
+					Event: Event{Type: EventHeatmap, Camera: hs.Camera, Time: now, Message: caption},
+					Text:  caption,
+					Image: img,
+				})
+			}
+
+			if err != nil {
+				fmt.Printf("could not post heatmap of %s: %s\n", hs.Camera, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOccupancyRates(t *testing.T) {
+	rates := occupancyRates([]Record{
+		{Free: []string{"a", "b"}},
+		{Free: []string{"b"}, Occupied: []string{"a"}},
+		{Occupied: []string{"a", "c"}},
+		{Free: []string{"a"}},
+	})
+
+	// spots are rated by the frames they were seen in
+	want := map[string]float64{"a": 0.5, "b": 0, "c": 1}
+	if len(rates) != len(want) {
+		t.Errorf("rates %v", rates)
+	}
+
+	for id, rate := range want {
+		if got, ok := rates[id]; !ok || !near(got, rate) {
+			t.Errorf("%s: %v, want %v", id, got, rate)
+		}
+	}
+
+	if rates := occupancyRates(nil); len(rates) != 0 {
+		t.Errorf("rates without records %v", rates)
+	}
+}
+
+func TestHeatColor(t *testing.T) {
+	for _, tt := range []struct {
+		rate float64
+		want color.NRGBA
+	}{
+		{-1, color.NRGBA{0, 200, 0, 9}},
+		{0, color.NRGBA{0, 200, 0, 9}},
+		{0.5, color.NRGBA{255, 200, 0, 9}},
+		{1, color.NRGBA{255, 0, 0, 9}},
+		{2, color.NRGBA{255, 0, 0, 9}},
+	} {
+		if got := heatColor(tt.rate, 9); got != tt.want {
+			t.Errorf("%v: %v, want %v", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestHeatmapPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		period, from, to string
+		start, end       time.Time
+	}{
+		{"", "", "", now.AddDate(0, 0, -1), now},
+		{"day", "", "", time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC), now},
+		{"week", "", "", time.Date(2024, 3, 24, 12, 0, 0, 0, time.UTC), now},
+		// a month back from the 31st of march normalizes to march 2nd
+		{"month", "", "", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), now},
+		// a custom range wins over the period
+		{"week", "2024-03-01T08:00:00Z", "2024-03-02T08:00:00Z", time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)},
+		{"", "2024-03-01", "", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), now},
+	}
+
+	for _, tt := range tests {
+		start, end, err := heatmapPeriod(tt.period, tt.from, tt.to, now)
+		if err != nil || !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%q %q %q: %s – %s, %v", tt.period, tt.from, tt.to, start, end, err)
+		}
+	}
+
+	for _, bad := range [][3]string{
+		{"year", "", ""},
+		{"", "yesterday", ""},
+		{"", "2024-03-01", "soon"},
+		{"", "2024-03-02", "2024-03-01"},
+		{"", "2024-03-01", "2024-03-01"},
+	} {
+		if _, _, err := heatmapPeriod(bad[0], bad[1], bad[2], now); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestHeatmapSchedule(t *testing.T) {
+	// 2024-05-06 is a monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 30, 0, time.Local)
+	}
+
+	tests := []struct {
+		schedule *HeatmapSchedule
+		posted   []time.Time
+		skipped  []time.Time
+	}{
+		{
+			&HeatmapSchedule{Period: "week", At: "09:00", Days: []string{"mon"}},
+			[]time.Time{at(6, 9, 0), at(13, 9, 0)},
+			[]time.Time{at(6, 8, 59), at(6, 9, 1), at(7, 9, 0)},
+		},
+		{
+			&HeatmapSchedule{At: "23:59"},
+			[]time.Time{at(6, 23, 59), at(7, 23, 59)},
+			[]time.Time{at(7, 0, 0), at(6, 23, 58)},
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.schedule.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, t0 := range tt.posted {
+			if !tt.schedule.window.covers(t0) {
+				t.Errorf("%s is not posted at %s", tt.schedule.At, t0)
+			}
+		}
+
+		for _, t0 := range tt.skipped {
+			if tt.schedule.window.covers(t0) {
+				t.Errorf("%s is posted at %s", tt.schedule.At, t0)
+			}
+		}
+	}
+
+	if hs := (&HeatmapSchedule{At: "09:00"}); hs.init() != nil || hs.Period != "day" {
+		t.Errorf("default period %q", hs.Period)
+	}
+
+	for _, bad := range []*HeatmapSchedule{
+		{Period: "year", At: "09:00"},
+		{At: "9am"},
+		{At: "09:00", Days: []string{"someday"}},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
+
+func TestGetHeatmapPeriod(t *testing.T) {
+	s := historyServer(t, 1)
+
+	for _, query := range []string{"period=year", "from=2024-03-02&to=2024-03-01"} {
+		r := httptest.NewRequest(http.MethodGet, "/cameras/yard/heatmap?"+query, nil)
+		r.SetPathValue("id", "yard")
+
+		w := httptest.NewRecorder()
+		if s.getHeatmap(w, r); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const historyDayLayout = "2006-01-02"
+
+// HistorySettings configures the occupancy history used for forecasts and
+// reports. History is kept only when Dir is set.
+type HistorySettings struct {
+	Dir       string   `json:"dir"`
+	Retention Duration `json:"retention"`
+}
+
+func (hs *HistorySettings) init() {
+	if hs.Retention <= 0 {
+		hs.Retention = Duration(90 * 24 * time.Hour)
+	}
+}
+
+// Record is the occupancy of the spots of a camera in one frame. Spots that
+// were unknown or of low confidence are left out.
+type Record struct {
+	Time     time.Time `json:"t"`
+	Free     []string  `json:"free,omitempty"`
+	Occupied []string  `json:"occupied,omitempty"`
+}
+
+// History stores one JSON line per reliable frame in a file per camera and
+// day: <dir>/<camera>/<date>.jsonl.
+type History struct {
+	settings HistorySettings
+	mu       sync.Mutex
+}
+
+func NewHistory(settings HistorySettings) *History {
+	return &History{settings: settings}
+}
+
+// Append records the spot statuses of a.
+func (h *History) Append(camera string, a *Analysis) error {
+	if a.Quality != nil && !a.Quality.Reliable {
+		return nil
+	}
+
+	rec := Record{Time: a.Time}
+	for _, spot := range a.Spots {
+		if spot.LowConfidence {
+			continue
+		}
+
+		switch spot.Status {
+		case StatusFree:
+			rec.Free = append(rec.Free, spot.ID)
+		case StatusOccupied:
+			rec.Occupied = append(rec.Occupied, spot.ID)
+		}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	path := h.path(camera, a.Time)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func (h *History) path(camera string, t time.Time) string {
+	return filepath.Join(h.settings.Dir, camera, t.Local().Format(historyDayLayout)+".jsonl")
+}
+
+// Read returns the records of a camera from from up to to, in time order.
+func (h *History) Read(camera string, from, to time.Time) ([]Record, error) {
+	var records []Record
+
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	for day := first; day.Before(to); day = day.AddDate(0, 0, 1) {
+		f, err := os.Open(h.path(camera, day))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				// a line cut short by a crash
+				continue
+			}
+
+			if !rec.Time.Before(from) && rec.Time.Before(to) {
+				records = append(records, rec)
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// frames of watched folders may arrive out of order
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	return records, nil
+}
+
+// cleanup removes the days older than the retention.
+func (h *History) cleanup() {
+	cameras, err := os.ReadDir(h.settings.Dir)
+	if err != nil {
+		return
+	}
+
+	oldest := time.Now().Add(-time.Duration(h.settings.Retention)).Format(historyDayLayout)
+
+	for _, camera := range cameras {
+		days, err := os.ReadDir(filepath.Join(h.settings.Dir, camera.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, day := range days {
+			name := strings.TrimSuffix(day.Name(), ".jsonl")
+			if name < oldest {
+				if err := os.Remove(filepath.Join(h.settings.Dir, camera.Name(), day.Name())); err != nil {
+					fmt.Printf("history: %s\n", err)
+				}
+			}
+		}
+	}
+}
+
+// runCleanup removes old history once a day.
+func (h *History) runCleanup() {
+	for {
+		h.cleanup()
+		time.Sleep(24 * time.Hour)
+	}
+}
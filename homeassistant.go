@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// supervisorCoreURL reaches the Home Assistant API from inside an add-on.
+const supervisorCoreURL = "http://supervisor/core"
+
+// HomeAssistantSettings configure pushing states and events to Home
+// Assistant over its REST API. Inside the add-on the Supervisor token and
+// URL are used, so nothing needs to be set.
+type HomeAssistantSettings struct {
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+	Disabled  bool   `json:"disabled"`
+
+	// Events are the event types fired as parking_* events in Home
+	// Assistant.
+	Events []string `json:"events"`
+
+	// Refresh is how often unchanged states are pushed again. Home
+	// Assistant forgets states set over the API when it restarts.
+	Refresh Duration `json:"refresh"`
+}
+
+func (hs *HomeAssistantSettings) init() error {
+	if hs.TokenFile != "" {
+		var err error
+		if hs.Token, err = readSecret(hs.TokenFile); err != nil {
+			return err
+		}
+	}
+
+	if hs.Token == "" {
+		hs.Token = os.Getenv("SUPERVISOR_TOKEN")
+		if hs.URL == "" {
+			hs.URL = supervisorCoreURL
+		}
+	}
+
+	if hs.Token != "" && hs.URL == "" {
+		return errors.New("url is required with a token")
+	}
+
+	hs.URL = strings.TrimSuffix(hs.URL, "/")
+
+	if hs.Events == nil {
+		hs.Events = []string{"spot.*", "lot.*", "camera.*"}
+	}
+
+	if hs.Refresh <= 0 {
+		hs.Refresh = Duration(5 * time.Minute)
+	}
+
+	return nil
+}
+
+func (hs *HomeAssistantSettings) enabled() bool {
+	return !hs.Disabled && hs.Token != ""
+}
+
+// haState is the body of POST /api/states/<entity_id>.
+type haState struct {
+	State      string         `json:"state"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// haEntity is the state of an entity and when it was last pushed.
+type haEntity struct {
+	state  string
+	since  time.Time
+	pushed time.Time
+}
+
+// HomeAssistant keeps spot and free count entities in Home Assistant up to
+// date and fires events there. Updates are sent from a single goroutine.
+type HomeAssistant struct {
+	settings *HomeAssistantSettings
+	client   *http.Client
+	queue    chan Event
+
+	// entities and counts are only used by the sending goroutine
+	entities map[string]*haEntity
+	counts   map[string]ZoneCount
+}
+
+func NewHomeAssistant(settings *HomeAssistantSettings) *HomeAssistant {
+	ha := &HomeAssistant{
+		settings: settings,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan Event, 64),
+		entities: map[string]*haEntity{},
+		counts:   map[string]ZoneCount{},
+	}
+
+	go ha.run()
+
+	return ha
+}
+
+// publish is a bus subscriber. Events are dropped when Home Assistant can
+// not keep up; the next frame brings the states up to date again.
+func (ha *HomeAssistant) publish(ev Event) {
+	if ev.Camera == "" {
+		return
+	}
+
+	if ev.Type != EventFrameAnalyzed && !matchEvent(ha.settings.Events, ev.Type) {
+		return
+	}
+
+	select {
+	case ha.queue <- ev:
+	default:
+		fmt.Printf("home assistant: queue is full, dropping %s event\n", ev.Type)
+	}
+}
+
+func (ha *HomeAssistant) run() {
+	for ev := range ha.queue {
+		if a, ok := ev.Data.(*Analysis); ok && ev.Type == EventFrameAnalyzed {
+			ha.pushAnalysis(ev.Camera, a)
+
+			continue
+		}
+
+		if err := ha.fire(ev); err != nil {
+			fmt.Printf("home assistant: could not fire %s event: %s\n", ev.Type, err)
+		}
+	}
+}
+
+// pushAnalysis updates the entities of a camera from its latest analysis.
+func (ha *HomeAssistant) pushAnalysis(camera string, a *Analysis) {
+	for _, spot := range a.Spots {
+		state := "off"
+		switch spot.Status {
+		case StatusOccupied:
+			state = "on"
+		case StatusUnknown:
+			state = "unknown"
+		case StatusOutOfService:
+			state = "unavailable"
+		}
+
+		id := "binary_sensor.parking_spot_" + entitySlug(camera) + "_" + entitySlug(spot.ID)
+
+		ha.push(id, state, a.Time, func(since time.Time) map[string]any {
+			if !spot.OccupiedSince.IsZero() {
+				since = spot.OccupiedSince
+			}
+
+			attrs := map[string]any{
+				"friendly_name": "Parking " + camera + " " + spotLabel(spot),
+				"device_class":  "occupancy",
+				"camera":        camera,
+				"spot":          spot.ID,
+				"status":        spot.Status,
+				"ratio":         spot.Percentage / 100,
+				"confidence":    spot.Confidence,
+				"since":         since,
+			}
+
+			if len(spot.Zones) > 0 {
+				attrs["zones"] = spot.Zones
+			}
+
+			if spot.Violation != "" {
+				attrs["violation"] = spot.Violation
+			}
+
+			return attrs
+		})
+	}
+
+	ha.counts[camera] = a.Counts
+
+	ha.push("sensor.parking_"+entitySlug(camera)+"_free_count", fmt.Sprint(a.Counts.Free), a.Time, func(since time.Time) map[string]any {
+		attrs := countAttributes("Parking "+camera+" free spots", a.Counts)
+		for _, zc := range a.Zones {
+			attrs["zone_"+entitySlug(zc.ID)] = zc.Free
+		}
+
+		return attrs
+	})
+
+	var total ZoneCount
+	for _, zc := range ha.counts {
+		total.Free += zc.Free
+		total.Occupied += zc.Occupied
+		total.Unknown += zc.Unknown
+		total.Total += zc.Total
+	}
+
+	ha.push("sensor.parking_free_count", fmt.Sprint(total.Free), a.Time, func(since time.Time) map[string]any {
+		return countAttributes("Parking free spots", total)
+	})
+}
+
+func countAttributes(name string, zc ZoneCount) map[string]any {
+	return map[string]any{
+		"friendly_name":       name,
+		"unit_of_measurement": "spots",
+		"state_class":         "measurement",
+		"icon":                "mdi:parking",
+		"total":               zc.Total,
+		"occupied":            zc.Occupied,
+		"unknown":             zc.Unknown,
+	}
+}
+
+// push sets an entity when its state changed or it was not refreshed for
+// a while. attributes gets the time the current state began.
+func (ha *HomeAssistant) push(id, state string, t time.Time, attributes func(since time.Time) map[string]any) {
+	e, ok := ha.entities[id]
+	if !ok {
+		e = &haEntity{state: state, since: t}
+		ha.entities[id] = e
+	}
+
+	if e.state != state {
+		e.state, e.since, e.pushed = state, t, time.Time{}
+	}
+
+	if time.Since(e.pushed) < time.Duration(ha.settings.Refresh) {
+		return
+	}
+
+	// a failed push leaves pushed unset, so the next frame tries again
+	if err := ha.post("/api/states/"+id, haState{State: state, Attributes: attributes(e.since)}); err != nil {
+		fmt.Printf("home assistant: could not set %s: %s\n", id, err)
+
+		return
+	}
+
+	e.pushed = time.Now()
+}
+
+// fire sends ev as a Home Assistant event, e.g. spot.changed becomes
+// parking_spot_changed.
+func (ha *HomeAssistant) fire(ev Event) error {
+	return ha.post("/api/events/parking_"+strings.ReplaceAll(ev.Type, ".", "_"), ev)
+}
+
+func (ha *HomeAssistant) post(path string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ha.settings.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+ha.settings.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ha.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// entitySlug turns a camera or spot id into a valid part of an entity id.
+func entitySlug(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "_")
+}
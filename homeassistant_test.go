@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// haRequest is a call received by the Home Assistant stub.
+type haRequest struct {
+	path string
+	body map[string]any
+}
+
+// haStub stands in for the Home Assistant REST API: it checks the token,
+// fails for the paths in fail and passes the other calls on.
+type haStub struct {
+	requests chan haRequest
+	fail     atomic.Value
+}
+
+func newHAStub(t *testing.T) (*haStub, *HomeAssistant) {
+	t.Helper()
+
+	stub := &haStub{requests: make(chan haRequest, 64)}
+	stub.fail.Store("")
+
+	ts := httptest.NewServer(stub)
+	t.Cleanup(ts.Close)
+
+	settings := &HomeAssistantSettings{URL: ts.URL + "/", Token: "tok"}
+	if err := settings.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return stub, NewHomeAssistant(settings)
+}
+
+func (stub *haStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer tok" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	if r.URL.Path == stub.fail.Load().(string) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+
+		return
+	}
+
+	data, _ := io.ReadAll(r.Body)
+
+	var body map[string]any
+	json.Unmarshal(data, &body)
+
+	stub.requests <- haRequest{path: r.URL.Path, body: body}
+}
+
+// expect returns the next n calls and checks that no more follow.
+func (stub *haStub) expect(t *testing.T, n int) map[string]map[string]any {
+	t.Helper()
+
+	got := map[string]map[string]any{}
+	for range n {
+		select {
+		case r := <-stub.requests:
+			got[r.path] = r.body
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d calls of %d: %v", len(got), n, got)
+		}
+	}
+
+	select {
+	case r := <-stub.requests:
+		t.Errorf("unexpected call to %s", r.path)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	return got
+}
+
+func yardFrame(aStatus, bStatus string, free int) Event {
+	since := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+
+	return Event{
+		Type:   EventFrameAnalyzed,
+		Camera: "Yard 1",
+		Data: &Analysis{
+			Time: since.Add(time.Hour),
+			Spots: []*SpotResult{
+				{ID: "a", Name: "Gate", Status: aStatus, Percentage: 50, Confidence: 0.9, Zones: []string{"ev"}, OccupiedSince: since},
+				{ID: "b-2", Status: bStatus, Percentage: 99, Confidence: 0.8},
+				{ID: "c", Status: StatusOutOfService},
+			},
+			Counts: ZoneCount{Free: free, Occupied: 2 - free, Total: 2},
+			Zones:  []*ZoneCount{{ID: "ev", Free: 0, Total: 1}},
+		},
+	}
+}
+
+func TestHomeAssistantStates(t *testing.T) {
+	stub, ha := newHAStub(t)
+
+	ha.publish(yardFrame(StatusOccupied, StatusFree, 1))
+	got := stub.expect(t, 5)
+
+	spot := got["/api/states/binary_sensor.parking_spot_yard_1_a"]
+	if spot["state"] != "on" {
+		t.Fatalf("spot a: %v", spot)
+	}
+
+	attrs := spot["attributes"].(map[string]any)
+	for key, want := range map[string]any{
+		"device_class": "occupancy",
+		"camera":       "Yard 1",
+		"spot":         "a",
+		"status":       StatusOccupied,
+		"ratio":        0.5,
+		"confidence":   0.9,
+		"since":        "2024-05-06T07:00:00Z",
+	} {
+		if attrs[key] != want {
+			t.Errorf("spot a %s = %v, want %v", key, attrs[key], want)
+		}
+	}
+
+	if zones, _ := attrs["zones"].([]any); len(zones) != 1 || zones[0] != "ev" {
+		t.Errorf("spot a zones = %v", attrs["zones"])
+	}
+
+	if state := got["/api/states/binary_sensor.parking_spot_yard_1_b_2"]["state"]; state != "off" {
+		t.Errorf("spot b-2: state %v, want off", state)
+	}
+
+	if state := got["/api/states/binary_sensor.parking_spot_yard_1_c"]["state"]; state != "unavailable" {
+		t.Errorf("spot c: state %v, want unavailable", state)
+	}
+
+	count := got["/api/states/sensor.parking_yard_1_free_count"]
+	countAttrs := count["attributes"].(map[string]any)
+	if count["state"] != "1" || countAttrs["total"] != 2.0 || countAttrs["occupied"] != 1.0 || countAttrs["zone_ev"] != 0.0 ||
+		countAttrs["unit_of_measurement"] != "spots" {
+		t.Errorf("camera free count: %v", count)
+	}
+
+	if state := got["/api/states/sensor.parking_free_count"]["state"]; state != "1" {
+		t.Errorf("total free count: %v, want 1", state)
+	}
+
+	// nothing changed: nothing is pushed until the refresh
+	ha.publish(yardFrame(StatusOccupied, StatusFree, 1))
+	stub.expect(t, 0)
+
+	// two spots swap, the counts stay the same
+	ha.publish(yardFrame(StatusFree, StatusOccupied, 1))
+	got = stub.expect(t, 2)
+
+	if spot := got["/api/states/binary_sensor.parking_spot_yard_1_a"]; spot["state"] != "off" {
+		t.Errorf("spot a after the swap: %v", spot)
+	}
+
+	if state := got["/api/states/binary_sensor.parking_spot_yard_1_b_2"]["state"]; state != "on" {
+		t.Errorf("spot b-2 after the swap: state %v, want on", state)
+	}
+
+	// the total sums up the cameras
+	gate := yardFrame(StatusFree, StatusFree, 2)
+	gate.Camera = "gate"
+	ha.publish(gate)
+
+	got = stub.expect(t, 5)
+	if state := got["/api/states/sensor.parking_free_count"]["state"]; state != "3" {
+		t.Errorf("total free count of two cameras: %v, want 3", state)
+	}
+}
+
+func TestHomeAssistantRetriesFailedStates(t *testing.T) {
+	stub, ha := newHAStub(t)
+
+	stub.fail.Store("/api/states/sensor.parking_free_count")
+	ha.publish(yardFrame(StatusOccupied, StatusFree, 1))
+	stub.expect(t, 4)
+
+	// a failed push is tried again with the next frame, even unchanged
+	stub.fail.Store("")
+	ha.publish(yardFrame(StatusOccupied, StatusFree, 1))
+
+	if got := stub.expect(t, 1); got["/api/states/sensor.parking_free_count"] == nil {
+		t.Errorf("retried %v, want the total free count", got)
+	}
+}
+
+func TestHomeAssistantEvents(t *testing.T) {
+	stub, ha := newHAStub(t)
+
+	ha.publish(Event{Type: "spot.changed", Camera: "yard", Message: "spot 1 is free", Data: map[string]string{"spot": "1"}})
+	ha.publish(Event{Type: "lot.full", Camera: "yard"})
+
+	// not in the default events, and events without a camera
+	ha.publish(Event{Type: "frame.quality", Camera: "yard"})
+	ha.publish(Event{Type: "spot.changed"})
+
+	got := stub.expect(t, 2)
+
+	ev := got["/api/events/parking_spot_changed"]
+	if ev["type"] != "spot.changed" || ev["camera"] != "yard" || ev["message"] != "spot 1 is free" {
+		t.Errorf("spot.changed event: %v", ev)
+	}
+
+	if data, _ := ev["data"].(map[string]any); data["spot"] != "1" {
+		t.Errorf("spot.changed data: %v", ev["data"])
+	}
+
+	if _, ok := got["/api/events/parking_lot_full"]; !ok {
+		t.Errorf("lot.full was not fired: %v", got)
+	}
+}
+
+func TestHomeAssistantToken(t *testing.T) {
+	stub, _ := newHAStub(t)
+
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	ha := &HomeAssistant{settings: &HomeAssistantSettings{URL: ts.URL, Token: "wrong"}, client: http.DefaultClient}
+	if err := ha.post("/api/states/sensor.x", haState{State: "1"}); err == nil {
+		t.Error("a wrong token was accepted")
+	}
+
+	t.Setenv("SUPERVISOR_TOKEN", "supervisor")
+
+	settings := &HomeAssistantSettings{}
+	if err := settings.init(); err != nil || settings.URL != supervisorCoreURL || settings.Token != "supervisor" || !settings.enabled() {
+		t.Errorf("add-on settings = %+v, %v", settings, err)
+	}
+
+	t.Setenv("SUPERVISOR_TOKEN", "")
+
+	if err := (&HomeAssistantSettings{Token: "tok"}).init(); err == nil {
+		t.Error("a token without url was accepted")
+	}
+
+	if (&HomeAssistantSettings{}).enabled() {
+		t.Error("enabled without a token")
+	}
+}
+
+func TestEntitySlug(t *testing.T) {
+	for in, want := range map[string]string{
+		"yard":         "yard",
+		"Yard 1":       "yard_1",
+		"b-2":          "b_2",
+		"--Gate  West": "gate_west",
+		"Двор":         "",
+		"ev.":          "ev",
+	} {
+		if got := entitySlug(in); got != want {
+			t.Errorf("entitySlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icalEvent is a VEVENT of an iCalendar file with its recurrence rule. Only
+// what permits usually need is supported: DAILY, WEEKLY, MONTHLY and YEARLY
+// rules with INTERVAL, BYDAY without positions, UNTIL and COUNT, and EXDATE.
+type icalEvent struct {
+	start    time.Time
+	duration time.Duration
+
+	freq     string
+	interval int
+	until    time.Time
+	untilDay bool
+	count    int
+	byDay    [7]bool
+	hasByDay bool
+	exdates  map[int64]bool
+}
+
+// loadCalendar reads the events of an iCalendar file.
+func loadCalendar(path string) ([]*icalEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// unfold continuation lines, which start with a space or a tab
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []*icalEvent
+	var props []string
+	inEvent := false
+
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent = true
+			props = nil
+		case line == "END:VEVENT":
+			inEvent = false
+
+			ev, err := parseEvent(props)
+			if err != nil {
+				return nil, err
+			}
+
+			if ev != nil {
+				events = append(events, ev)
+			}
+		case inEvent:
+			props = append(props, line)
+		}
+	}
+
+	return events, nil
+}
+
+func parseEvent(props []string) (*icalEvent, error) {
+	ev := &icalEvent{interval: 1, exdates: map[int64]bool{}}
+
+	var end time.Time
+	var allDay bool
+
+	for _, prop := range props {
+		name, params, value := splitProperty(prop)
+
+		var err error
+		switch name {
+		case "DTSTART":
+			ev.start, allDay, err = parseICalTime(value, params)
+		case "DTEND":
+			end, _, err = parseICalTime(value, params)
+		case "DURATION":
+			ev.duration, err = parseICalDuration(value)
+		case "RRULE":
+			err = ev.parseRule(value)
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var t time.Time
+				if t, _, err = parseICalTime(v, params); err != nil {
+					break
+				}
+
+				ev.exdates[t.Unix()] = true
+			}
+		case "STATUS":
+			if value == "CANCELLED" {
+				return nil, nil
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if ev.start.IsZero() {
+		return nil, errors.New("event without DTSTART")
+	}
+
+	// a DATE until includes the whole day in the zone of the event
+	if ev.untilDay {
+		y, m, d := ev.until.Date()
+		ev.until = time.Date(y, m, d+1, 0, 0, 0, 0, ev.start.Location()).Add(-time.Nanosecond)
+	}
+
+	switch {
+	case !end.IsZero():
+		ev.duration = end.Sub(ev.start)
+	case ev.duration == 0 && allDay:
+		ev.duration = 24 * time.Hour
+	}
+
+	if ev.duration <= 0 {
+		return nil, fmt.Errorf("event at %s has no duration", ev.start)
+	}
+
+	return ev, nil
+}
+
+// splitProperty splits `NAME;PARAM=x:value` into its parts.
+func splitProperty(line string) (name string, params map[string]string, value string) {
+	head, value, _ := strings.Cut(line, ":")
+
+	parts := strings.Split(head, ";")
+	params = map[string]string{}
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// parseICalTime parses a DATE or DATE-TIME value. Times without a zone
+// are local.
+func parseICalTime(value string, params map[string]string) (t time.Time, allDay bool, err error) {
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	switch {
+	case len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, loc)
+
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+
+	return t, false, err
+}
+
+var icalDurationRe = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func parseICalDuration(value string) (time.Duration, error) {
+	m := icalDurationRe.FindStringSubmatch(strings.TrimPrefix(value, "+"))
+	if m == nil {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] != "" {
+			n, _ := strconv.Atoi(m[i+1])
+			d += time.Duration(n) * unit
+		}
+	}
+
+	return d, nil
+}
+
+var icalDays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func (ev *icalEvent) parseRule(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+
+		var err error
+		switch k {
+		case "FREQ":
+			switch v {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				ev.freq = v
+			default:
+				return fmt.Errorf("unsupported frequency %s", v)
+			}
+		case "INTERVAL":
+			if ev.interval, err = strconv.Atoi(v); err != nil || ev.interval < 1 {
+				return fmt.Errorf("bad interval %q", v)
+			}
+		case "COUNT":
+			if ev.count, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("bad count %q", v)
+			}
+		case "UNTIL":
+			if ev.until, ev.untilDay, err = parseICalTime(v, nil); err != nil {
+				return err
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				// positional days like 1MO would be taken as every monday
+				if day := strings.TrimLeft(d, "+-0123456789"); day != d {
+					return fmt.Errorf("positional day %q is not supported", d)
+				}
+
+				wd, ok := icalDays[d]
+				if !ok {
+					return fmt.Errorf("bad day %q", d)
+				}
+
+				ev.byDay[wd] = true
+				ev.hasByDay = true
+			}
+		case "WKST", "":
+		default:
+			return fmt.Errorf("unsupported rule part %s", k)
+		}
+	}
+
+	return nil
+}
+
+// covers reports whether t is within one of the occurrences of the event.
+func (ev *icalEvent) covers(t time.Time) bool {
+	if ev.freq == "" {
+		return !t.Before(ev.start) && t.Before(ev.start.Add(ev.duration))
+	}
+
+	// look at the occurrences starting on the days that may still last
+	// until t
+	t = t.In(ev.start.Location())
+	first := t.Add(-ev.duration).AddDate(0, 0, -1)
+
+	for day := t; !day.Before(first); day = day.AddDate(0, 0, -1) {
+		start := time.Date(day.Year(), day.Month(), day.Day(),
+			ev.start.Hour(), ev.start.Minute(), ev.start.Second(), 0, ev.start.Location())
+
+		if !t.Before(start) && t.Before(start.Add(ev.duration)) && ev.occurs(start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// occurs reports whether the rule has an occurrence starting at start,
+// which is at the time of day of the first one.
+func (ev *icalEvent) occurs(start time.Time) bool {
+	if start.Before(ev.start) || ev.exdates[start.Unix()] {
+		return false
+	}
+
+	if !ev.until.IsZero() && start.After(ev.until) {
+		return false
+	}
+
+	if !ev.matches(start) {
+		return false
+	}
+
+	if ev.count == 0 {
+		return true
+	}
+
+	n := 0
+	for day := ev.start; day.Before(start); day = day.AddDate(0, 0, 1) {
+		if ev.matches(day) {
+			if n++; n >= ev.count {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matches checks the frequency, interval and weekdays of the rule.
+func (ev *icalEvent) matches(t time.Time) bool {
+	days := civilDays(ev.start, t)
+
+	switch ev.freq {
+	case "DAILY":
+		return days%ev.interval == 0 && (!ev.hasByDay || ev.byDay[t.Weekday()])
+	case "WEEKLY":
+		if ev.hasByDay && !ev.byDay[t.Weekday()] || !ev.hasByDay && t.Weekday() != ev.start.Weekday() {
+			return false
+		}
+
+		// weeks start on monday
+		offset := (int(ev.start.Weekday()) + 6) % 7
+
+		return ((days+offset)/7)%ev.interval == 0
+	case "MONTHLY":
+		months := (t.Year()-ev.start.Year())*12 + int(t.Month()-ev.start.Month())
+
+		if ev.hasByDay {
+			return months%ev.interval == 0 && ev.byDay[t.Weekday()]
+		}
+
+		return months%ev.interval == 0 && t.Day() == ev.start.Day()
+	case "YEARLY":
+		return (t.Year()-ev.start.Year())%ev.interval == 0 && t.Month() == ev.start.Month() && t.Day() == ev.start.Day()
+	}
+
+	return false
+}
+
+// civilDays is the number of calendar days from a to b.
+func civilDays(a, b time.Time) int {
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+
+	return int(db.Sub(da).Hours() / 24)
+}
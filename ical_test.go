@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// calendar loads an iCalendar file holding events made of the given
+// properties.
+func calendar(t *testing.T, events ...string) ([]*icalEvent, error) {
+	t.Helper()
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	for _, ev := range events {
+		b.WriteString("BEGIN:VEVENT\r\n" + strings.ReplaceAll(strings.TrimSpace(ev), "\n", "\r\n") + "\r\nEND:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+
+	path := filepath.Join(t.TempDir(), "permits.ics")
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return loadCalendar(path)
+}
+
+func utc(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestCalendarRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		covered []time.Time
+		free    []time.Time
+	}{
+		{
+			"single",
+			"DTSTART:20240101T080000Z\nDTEND:20240101T090000Z",
+			[]time.Time{utc(1, 1, 8, 0), utc(1, 1, 8, 59)},
+			[]time.Time{utc(1, 1, 7, 59), utc(1, 1, 9, 0), utc(1, 2, 8, 30)},
+		},
+		{
+			"daily every other day three times",
+			"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;INTERVAL=2;COUNT=3",
+			[]time.Time{utc(1, 1, 8, 30), utc(1, 3, 8, 30), utc(1, 5, 8, 30)},
+			[]time.Time{utc(1, 2, 8, 30), utc(1, 7, 8, 30), utc(1, 3, 9, 0), utc(12, 31, 8, 30)},
+		},
+		{
+			"weekly on two days every other week",
+			"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+			[]time.Time{utc(1, 1, 8, 0), utc(1, 3, 8, 0), utc(1, 15, 8, 0), utc(1, 17, 8, 0)},
+			[]time.Time{utc(1, 2, 8, 0), utc(1, 8, 8, 0), utc(1, 10, 8, 0)},
+		},
+		{
+			"weekly on the day of the start",
+			"DTSTART:20240103T080000Z\nDURATION:PT1H\nRRULE:FREQ=WEEKLY",
+			[]time.Time{utc(1, 3, 8, 0), utc(1, 10, 8, 0)},
+			[]time.Time{utc(1, 4, 8, 0), utc(1, 8, 8, 0)},
+		},
+		{
+			"monthly on the day",
+			"DTSTART:20240115T080000Z\nDURATION:PT1H\nRRULE:FREQ=MONTHLY",
+			[]time.Time{utc(2, 15, 8, 0), utc(3, 15, 8, 0)},
+			[]time.Time{utc(2, 16, 8, 0), utc(1, 14, 8, 0)},
+		},
+		{
+			"every fridays of every third month",
+			"DTSTART:20240105T080000Z\nDURATION:PT1H\nRRULE:FREQ=MONTHLY;INTERVAL=3;BYDAY=FR",
+			[]time.Time{utc(1, 12, 8, 0), utc(4, 5, 8, 0), utc(4, 26, 8, 0)},
+			[]time.Time{utc(2, 2, 8, 0), utc(4, 4, 8, 0)},
+		},
+		{
+			"yearly",
+			"DTSTART:20230301T080000Z\nDURATION:PT1H\nRRULE:FREQ=YEARLY",
+			[]time.Time{utc(3, 1, 8, 0)},
+			[]time.Time{utc(3, 2, 8, 0)},
+		},
+		{
+			// the whole UNTIL day is included
+			"until a date",
+			"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;UNTIL=20240105",
+			[]time.Time{utc(1, 5, 8, 30)},
+			[]time.Time{utc(1, 6, 8, 30)},
+		},
+		{
+			"until a time",
+			"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;UNTIL=20240105T080000Z",
+			[]time.Time{utc(1, 5, 8, 30)},
+			[]time.Time{utc(1, 6, 8, 30)},
+		},
+		{
+			"excluded dates",
+			"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY\nEXDATE:20240102T080000Z,20240104T080000Z",
+			[]time.Time{utc(1, 3, 8, 30), utc(1, 5, 8, 30)},
+			[]time.Time{utc(1, 2, 8, 30), utc(1, 4, 8, 30)},
+		},
+		{
+			"overnight",
+			"DTSTART:20240101T220000Z\nDURATION:PT10H\nRRULE:FREQ=DAILY;COUNT=2",
+			[]time.Time{utc(1, 1, 23, 0), utc(1, 2, 7, 59), utc(1, 3, 7, 59)},
+			[]time.Time{utc(1, 1, 21, 59), utc(1, 2, 8, 0), utc(1, 3, 22, 0)},
+		},
+		{
+			"all day",
+			"DTSTART;VALUE=DATE:20240101\nRRULE:FREQ=WEEKLY",
+			[]time.Time{time.Date(2024, 1, 8, 0, 0, 0, 0, time.Local), time.Date(2024, 1, 8, 23, 59, 0, 0, time.Local)},
+			[]time.Time{time.Date(2024, 1, 9, 0, 0, 0, 0, time.Local)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := calendar(t, tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, at := range tt.covered {
+				if !events[0].covers(at) {
+					t.Errorf("%s is not covered", at)
+				}
+			}
+
+			for _, at := range tt.free {
+				if events[0].covers(at) {
+					t.Errorf("%s is covered", at)
+				}
+			}
+		})
+	}
+}
+
+func TestCalendarErrors(t *testing.T) {
+	for _, event := range []string{
+		"DTEND:20240101T090000Z",
+		"DTSTART:20240101T080000Z",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=HOURLY",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=MONTHLY;BYDAY=1MO",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=MONTHLY;BYDAY=FR,-1FR",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=WEEKLY;BYDAY=XX",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;INTERVAL=0",
+		"DTSTART:20240101T080000Z\nDURATION:1 hour",
+	} {
+		if _, err := calendar(t, event); err == nil {
+			t.Errorf("%q was accepted", event)
+		}
+	}
+
+	// cancelled events are left out, long lines are unfolded
+	events, err := calendar(t,
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nSTATUS:CANCELLED",
+		"DTSTART:20240101T080000Z\nDURATION:PT1H\nRRULE:FREQ=DAILY;\n BYDAY=MO",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || !events[0].covers(utc(1, 8, 8, 0)) || events[0].covers(utc(1, 9, 8, 0)) {
+		t.Errorf("events %+v", events)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+
+	"github.com/ad/go-parking/poly"
+)
+
+// rowIntegral holds per-row prefix sums of edge pixels, so the number of
+// edge pixels in any horizontal span is found with a single subtraction.
+type rowIntegral struct {
+	rect image.Rectangle
+	sums []int32
+}
+
+// newRowIntegral builds prefix sums of an inverted edge image, where edge
+// pixels are anything but white. The edge image may be a crop of the frame:
+// origin is the position of its top left pixel in mask coordinates.
+func newRowIntegral(edges *image.Gray, origin image.Point) *rowIntegral {
+	size := edges.Bounds().Size()
+	width := size.X + 1
+
+	ri := &rowIntegral{
+		rect: image.Rectangle{Min: origin, Max: origin.Add(size)},
+		sums: make([]int32, width*size.Y),
+	}
+
+	for y := 0; y < size.Y; y++ {
+		row := ri.sums[y*width : (y+1)*width]
+		pix := edges.Pix[y*edges.Stride:]
+
+		for x := 0; x < size.X; x++ {
+			row[x+1] = row[x]
+			if pix[x] != 0xff {
+				row[x+1]++
+			}
+		}
+	}
+
+	return ri
+}
+
+// count returns the number of edge pixels and the total number of pixels of
+// mask that lie inside the integral's rectangle.
+func (ri *rowIntegral) count(mask poly.Mask) (edges, total int) {
+	width := ri.rect.Dx() + 1
+
+	for _, s := range mask {
+		if s.Y < ri.rect.Min.Y || s.Y >= ri.rect.Max.Y {
+			continue
+		}
+
+		x0 := max(s.X0, ri.rect.Min.X) - ri.rect.Min.X
+		x1 := min(s.X1, ri.rect.Max.X) - ri.rect.Min.X
+		if x1 <= x0 {
+			continue
+		}
+
+		row := ri.sums[(s.Y-ri.rect.Min.Y)*width:]
+		edges += int(row[x1] - row[x0])
+		total += x1 - x0
+	}
+
+	return edges, total
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ad/go-parking/poly"
+)
+
+// randomEdges returns an edge image of the given size where about a third
+// of the pixels are edges, i.e. not white.
+func randomEdges(w, h int) *image.Gray {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+		if rng.IntN(3) == 0 {
+			img.Pix[i] = uint8(rng.IntN(0xff))
+		}
+	}
+
+	return img
+}
+
+// countPerPixel is the scan the integral replaced: every pixel of the
+// polygons' bounding box is tested against every polygon.
+func countPerPixel(edges *image.Gray, polys []*poly.Poly, scale float64) (nonZero, zero []int) {
+	nonZero, zero = make([]int, len(polys)), make([]int, len(polys))
+
+	lo, hi := poly.MinMaxMany(polys)
+	size := edges.Bounds().Size()
+
+	for y := max(0, int(lo.Y*scale)); y < size.Y && y <= int(hi.Y*scale); y++ {
+		for x := max(0, int(lo.X*scale)); x < size.X && x <= int(hi.X*scale); x++ {
+			point := poly.XY{X: float64(x) / scale, Y: float64(y) / scale}
+			for i, pg := range polys {
+				if point.In(*pg) {
+					if edges.GrayAt(x, y).Y != 0xff {
+						nonZero[i]++
+					} else {
+						zero[i]++
+					}
+				}
+			}
+		}
+	}
+
+	return nonZero, zero
+}
+
+func TestRowIntegralCount(t *testing.T) {
+	edges := randomEdges(960, 640)
+	nonZero, zero := countPerPixel(edges, polygons, resizeScale)
+
+	full := newRowIntegral(edges, image.Point{})
+
+	// a crop of the frame, as detectEdges returns it, with its origin
+	crop := image.Rect(300, 180, 700, 420)
+	cropped := newRowIntegral(edges.SubImage(crop).(*image.Gray), crop.Min)
+
+	for i, mask := range defaultLayout.Masks(resizeScale) {
+		edgeCount, total := full.count(mask)
+		if edgeCount != nonZero[i] || total-edgeCount != zero[i] {
+			t.Errorf("spot %d: %d edges of %d, want %d of %d", i, edgeCount, total, nonZero[i], nonZero[i]+zero[i])
+		}
+
+		// the crop sees the part of the spot inside it
+		var wantEdges, wantTotal int
+		for _, s := range mask {
+			for x := s.X0; x < s.X1; x++ {
+				if (image.Point{X: x, Y: s.Y}).In(crop) {
+					wantTotal++
+					if edges.GrayAt(x, s.Y).Y != 0xff {
+						wantEdges++
+					}
+				}
+			}
+		}
+
+		if e, n := cropped.count(mask); e != wantEdges || n != wantTotal {
+			t.Errorf("spot %d in crop: %d edges of %d, want %d of %d", i, e, n, wantEdges, wantTotal)
+		}
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	edges := randomEdges(960, 640)
+	masks := defaultLayout.Masks(resizeScale)
+
+	for b.Loop() {
+		integral := newRowIntegral(edges, image.Point{})
+		for _, mask := range masks {
+			integral.count(mask)
+		}
+	}
+}
+
+func BenchmarkCountPerPixel(b *testing.B) {
+	edges := randomEdges(960, 640)
+
+	for b.Loop() {
+		countPerPixel(edges, polygons, resizeScale)
+	}
+}
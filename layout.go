@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ad/go-parking/poly"
+)
+
+const defaultLayoutName = "default"
+
+// Spot is a single parking spot of a layout.
+type Spot struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name,omitempty"`
+	Zones []string  `json:"zones,omitempty"`
+	Rule  *SpotRule `json:"rule,omitempty"`
+
+	OutOfService bool `json:"out_of_service,omitempty"`
+
+	Poly *poly.Poly `json:"-"`
+}
+
+// spotJSON is how a spot is written in settings: the polygon is a list of
+// [x, y] points in full resolution frame coordinates.
+type spotJSON struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name,omitempty"`
+	Zones  []string     `json:"zones,omitempty"`
+	Rule   *SpotRule    `json:"rule,omitempty"`
+	OOS    bool         `json:"out_of_service,omitempty"`
+	Points [][2]float64 `json:"points"`
+}
+
+func (s *Spot) UnmarshalJSON(b []byte) error {
+	var v spotJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	s.ID, s.Name, s.Zones, s.Rule, s.OutOfService = v.ID, v.Name, v.Zones, v.Rule, v.OOS
+	s.Poly = &poly.Poly{}
+	for _, p := range v.Points {
+		s.Poly.XY = append(s.Poly.XY, poly.XY{X: p[0], Y: p[1]})
+	}
+
+	return nil
+}
+
+func (s *Spot) MarshalJSON() ([]byte, error) {
+	v := spotJSON{ID: s.ID, Name: s.Name, Zones: s.Zones, Rule: s.Rule, OOS: s.OutOfService}
+	for _, p := range s.Poly.XY {
+		v.Points = append(v.Points, [2]float64{p.X, p.Y})
+	}
+
+	return json.Marshal(v)
+}
+
+// Layout is the set of parking spots visible from one camera. The spot
+// polygons never change between frames, so their pixel masks are rasterized
+// once per working scale and reused.
+type Layout struct {
+	Spots []*Spot `json:"spots"`
+
+	// Zones names the zone tags used by the spots, e.g. "ev": "EV charging".
+	Zones map[string]string `json:"zones,omitempty"`
+
+	// MaxStays limits how long spots of a zone may be occupied.
+	MaxStays map[string]Duration `json:"max_stay,omitempty"`
+
+	Privacy *Privacy `json:"privacy,omitempty"`
+
+	mu    sync.Mutex
+	masks map[float64][]poly.Mask
+}
+
+// defaultLayout is the built-in layout made of the polygons in polygons.go,
+// numbered from 1.
+var defaultLayout = func() *Layout {
+	l := &Layout{}
+	for i, p := range polygons {
+		l.Spots = append(l.Spots, &Spot{ID: strconv.Itoa(i + 1), Poly: p})
+	}
+
+	return l
+}()
+
+func (l *Layout) validate() error {
+	if len(l.Spots) == 0 {
+		return errors.New("layout has no spots")
+	}
+
+	ids := map[string]bool{}
+	for i, s := range l.Spots {
+		if s.ID == "" {
+			s.ID = strconv.Itoa(i + 1)
+		}
+
+		if ids[s.ID] {
+			return fmt.Errorf("duplicate spot id %s", s.ID)
+		}
+		ids[s.ID] = true
+
+		if len(s.Poly.XY) < 3 {
+			return fmt.Errorf("spot %s needs at least 3 points", s.ID)
+		}
+
+		if s.Rule != nil {
+			if err := s.Rule.init(); err != nil {
+				return fmt.Errorf("spot %s: %w", s.ID, err)
+			}
+		}
+	}
+
+	if l.Privacy != nil {
+		if err := l.Privacy.init(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ZoneName returns the display name of a zone.
+func (l *Layout) ZoneName(zone string) string {
+	if name, ok := l.Zones[zone]; ok {
+		return name
+	}
+
+	return zone
+}
+
+// ZoneIDs returns the zones used by the spots in order of first use.
+func (l *Layout) ZoneIDs() []string {
+	var zones []string
+
+	seen := map[string]bool{}
+	for _, s := range l.Spots {
+		for _, z := range s.Zones {
+			if !seen[z] {
+				seen[z] = true
+				zones = append(zones, z)
+			}
+		}
+	}
+
+	return zones
+}
+
+// Polygons returns the polygons of all spots.
+func (l *Layout) Polygons() []*poly.Poly {
+	polys := make([]*poly.Poly, len(l.Spots))
+	for i, s := range l.Spots {
+		polys[i] = s.Poly
+	}
+
+	return polys
+}
+
+// Masks returns the pixel masks of all spots at the given scale.
+func (l *Layout) Masks(scale float64) []poly.Mask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if masks, ok := l.masks[scale]; ok {
+		return masks
+	}
+
+	masks := make([]poly.Mask, len(l.Spots))
+	for i, spot := range l.Spots {
+		masks[i] = spot.Poly.Rasterize(scale)
+	}
+
+	if l.masks == nil {
+		l.masks = map[float64][]poly.Mask{}
+	}
+	l.masks[scale] = masks
+
+	return masks
+}
+
+// layoutFor returns the layout of a camera, or the default layout.
+func (s *server) layoutFor(camera string) *Layout {
+	if c, ok := s.settings.Cameras[camera]; ok && c.Layout != "" {
+		return s.settings.Layouts[c.Layout]
+	}
+
+	if l, ok := s.settings.Layouts[defaultLayoutName]; ok {
+		return l
+	}
+
+	return defaultLayout
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// liveMessage is a pinned Telegram message showing the latest frame of a
+// camera. It is edited in place instead of sending a photo per frame.
+type liveMessage struct {
+	ChatID    int64  `json:"chat_id"`
+	ThreadID  int64  `json:"thread_id,omitempty"`
+	Camera    string `json:"camera,omitempty"`
+	MessageID int64  `json:"message_id"`
+
+	// update serializes edits of the message
+	update sync.Mutex
+
+	// shown is the state in the message, empty until it was edited
+	shown string
+}
+
+// LiveMessages keeps the live message of every chat, thread and camera.
+// Message ids are saved to a file, so the messages survive restarts.
+type LiveMessages struct {
+	path string
+
+	mu       sync.Mutex
+	messages map[string]*liveMessage
+}
+
+func NewLiveMessages(path string) (*LiveMessages, error) {
+	lm := &LiveMessages{path: path, messages: map[string]*liveMessage{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		var messages []*liveMessage
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, m := range messages {
+			lm.messages[liveKey(m.ChatID, m.ThreadID, m.Camera)] = m
+		}
+	}
+
+	return lm, nil
+}
+
+func liveKey(chatID, threadID int64, camera string) string {
+	return fmt.Sprintf("%d/%d/%s", chatID, threadID, camera)
+}
+
+// liveState describes what a live message shows, so it is only edited when
+// a spot or the caption changes.
+func liveState(a *Analysis, caption string) string {
+	var b strings.Builder
+	for _, spot := range a.Spots {
+		b.WriteString(spot.ID + "=" + spot.Status + strconv.FormatBool(spot.LowConfidence) + ";")
+	}
+
+	return b.String() + caption
+}
+
+// Update shows n in the live message of the camera in the target's chat.
+// The message is sent and pinned the first time and again when it was
+// deleted.
+func (lm *LiveMessages) Update(tn *telegramNotifier, camera string, n *Notification, state string) error {
+	key := liveKey(tn.chatID, tn.threadID, camera)
+
+	lm.mu.Lock()
+	m, ok := lm.messages[key]
+	if !ok {
+		m = &liveMessage{ChatID: tn.chatID, ThreadID: tn.threadID, Camera: camera}
+		lm.messages[key] = m
+	}
+	lm.mu.Unlock()
+
+	m.update.Lock()
+	defer m.update.Unlock()
+
+	// the id is only set under update, so an update that waited here sees
+	// the message sent by the one before it
+	id := m.MessageID
+
+	if state == m.shown {
+		return nil
+	}
+
+	if id != 0 {
+		err := tn.update(n, id)
+
+		var te *telegramError
+		switch {
+		case err == nil, errors.As(err, &te) && strings.Contains(te.Description, "message is not modified"):
+			m.shown = state
+
+			return nil
+		case !errors.As(err, &te) || !messageGone(te):
+			return err
+		}
+
+		fmt.Printf("live message %d in chat %d is gone, sending a new one\n", id, tn.chatID)
+	}
+
+	id, err := tn.sendPhoto(n.Image, n.Text)
+	if err != nil {
+		return err
+	}
+
+	// pinning needs admin rights in groups, the message works without
+	if err := tn.pin(id); err != nil {
+		fmt.Printf("could not pin live message %d in chat %d: %s\n", id, tn.chatID, err)
+	}
+
+	lm.mu.Lock()
+	m.MessageID = id
+	lm.save()
+	lm.mu.Unlock()
+
+	m.shown = state
+
+	return nil
+}
+
+// messageGone reports whether an edit failed because the message was
+// deleted.
+func messageGone(te *telegramError) bool {
+	return te.Code == 400 && (strings.Contains(te.Description, "message to edit not found") ||
+		strings.Contains(te.Description, "MESSAGE_ID_INVALID"))
+}
+
+// save writes the message ids to the file. The caller must hold mu.
+func (lm *LiveMessages) save() {
+	messages := make([]*liveMessage, 0, len(lm.messages))
+	for _, m := range lm.messages {
+		if m.MessageID != 0 {
+			messages = append(messages, m)
+		}
+	}
+
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err == nil {
+		// write and rename, so a crash never leaves a truncated file
+		tmp := filepath.Join(filepath.Dir(lm.path), "."+filepath.Base(lm.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, lm.path)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("could not save live messages: %s\n", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// liveAPI is a Bot API for live messages: it numbers the photos sent from
+// 42 on and fails edits with editError when set.
+type liveAPI struct {
+	mu        sync.Mutex
+	editError string
+	calls     []string
+	edited    []string
+
+	nextID atomic.Int64
+}
+
+func newLiveAPI(t *testing.T) (*liveAPI, *telegramNotifier) {
+	t.Helper()
+
+	api := &liveAPI{}
+	api.nextID.Store(41)
+
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	target := newTestTarget(t, &Target{Token: "123:abc", ChatID: -100, URL: ts.URL, Live: true})
+
+	return api, target.notifier.(*telegramNotifier)
+}
+
+func (api *liveAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	api.mu.Lock()
+	api.calls = append(api.calls, method)
+	editError := api.editError
+	api.mu.Unlock()
+
+	switch method {
+	case "sendPhoto":
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{"message_id": api.nextID.Add(1)}})
+	case "editMessageMedia":
+		if editError != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": 400, "description": editError})
+
+			return
+		}
+
+		api.mu.Lock()
+		api.edited = append(api.edited, r.URL.Query().Get("message_id"))
+		api.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	case "pinChatMessage":
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (api *liveAPI) failEdits(description string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.editError = description
+}
+
+// takeCalls returns the API methods called since the last time.
+func (api *liveAPI) takeCalls() string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	calls := strings.Join(api.calls, ",")
+	api.calls = nil
+
+	return calls
+}
+
+func liveNotification(text string) *Notification {
+	return &Notification{Event: Event{Type: EventFrameAnalyzed, Camera: "yard"}, Text: text, Image: notifyImage()}
+}
+
+// savedLiveMessages reads the live messages file.
+func savedLiveMessages(t *testing.T, path string) []*liveMessage {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []*liveMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		t.Fatal(err)
+	}
+
+	return messages
+}
+
+func TestLiveMessagesUpdate(t *testing.T) {
+	api, tn := newLiveAPI(t)
+	path := filepath.Join(t.TempDir(), "live_messages.json")
+
+	lm, err := NewLiveMessages(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first frame is sent and pinned
+	if err := lm.Update(tn, "yard", liveNotification("1 free"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "sendPhoto,pinChatMessage" {
+		t.Errorf("first frame: %s", calls)
+	}
+
+	if saved := savedLiveMessages(t, path); len(saved) != 1 || saved[0].MessageID != 42 || saved[0].ChatID != -100 || saved[0].Camera != "yard" {
+		t.Errorf("saved %+v", saved)
+	}
+
+	// the same state is not edited, a new one is edited in place
+	if err := lm.Update(tn, "yard", liveNotification("1 free"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lm.Update(tn, "yard", liveNotification("2 free"), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "editMessageMedia" || len(api.edited) != 1 || api.edited[0] != "42" {
+		t.Errorf("edit in place: %s, edited %v", calls, api.edited)
+	}
+
+	// an edit without changes counts as shown
+	api.failEdits("Bad Request: message is not modified")
+	if err := lm.Update(tn, "yard", liveNotification("2 free"), "c"); err != nil {
+		t.Errorf("not modified: %v", err)
+	}
+
+	if err := lm.Update(tn, "yard", liveNotification("2 free"), "c"); err != nil || api.takeCalls() != "editMessageMedia" {
+		t.Errorf("not modified was edited again: %v", err)
+	}
+
+	// other errors are returned and nothing is sent instead
+	api.failEdits("Bad Request: not enough rights")
+	if err := lm.Update(tn, "yard", liveNotification("3 free"), "d"); err == nil || !strings.Contains(err.Error(), "not enough rights") {
+		t.Errorf("failed edit: %v", err)
+	}
+
+	if calls := api.takeCalls(); calls != "editMessageMedia" {
+		t.Errorf("failed edit: %s", calls)
+	}
+
+	// another camera in the same chat has its own message
+	api.failEdits("")
+	if err := lm.Update(tn, "gate", liveNotification("gate"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "sendPhoto,pinChatMessage" || len(savedLiveMessages(t, path)) != 2 {
+		t.Errorf("second camera: %s", calls)
+	}
+}
+
+func TestLiveMessagesGone(t *testing.T) {
+	api, tn := newLiveAPI(t)
+	path := filepath.Join(t.TempDir(), "live_messages.json")
+
+	lm, err := NewLiveMessages(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lm.Update(tn, "yard", liveNotification("1 free"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	api.takeCalls()
+
+	// the message was deleted: a new one is sent, pinned and saved
+	api.failEdits("Bad Request: message to edit not found")
+	if err := lm.Update(tn, "yard", liveNotification("2 free"), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "editMessageMedia,sendPhoto,pinChatMessage" {
+		t.Errorf("gone: %s", calls)
+	}
+
+	if saved := savedLiveMessages(t, path); len(saved) != 1 || saved[0].MessageID != 43 {
+		t.Errorf("saved %+v, want the new message", saved)
+	}
+}
+
+func TestLiveMessagesPersist(t *testing.T) {
+	api, tn := newLiveAPI(t)
+	path := filepath.Join(t.TempDir(), "live_messages.json")
+
+	if err := os.WriteFile(path, []byte(`[{"chat_id": -100, "camera": "yard", "message_id": 7}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	lm, err := NewLiveMessages(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart the saved message is edited
+	if err := lm.Update(tn, "yard", liveNotification("1 free"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "editMessageMedia" || api.edited[0] != "7" {
+		t.Errorf("after restart: %s, edited %v", calls, api.edited)
+	}
+
+	// a message of another thread is not the same
+	if err := lm.Update(&telegramNotifier{api: tn.api, chatID: -100, threadID: 5}, "yard", liveNotification("1 free"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := api.takeCalls(); calls != "sendPhoto,pinChatMessage" {
+		t.Errorf("other thread: %s", calls)
+	}
+
+	reloaded, err := NewLiveMessages(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(reloaded.messages); n != 2 || reloaded.messages[liveKey(-100, 5, "yard")].MessageID != 42 {
+		t.Errorf("reloaded %d messages: %v", n, reloaded.messages)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewLiveMessages(path); err == nil {
+		t.Error("a broken file was accepted")
+	}
+}
+
+func TestLiveMessagesConcurrentFirstUpdate(t *testing.T) {
+	api, tn := newLiveAPI(t)
+
+	lm, err := NewLiveMessages(filepath.Join(t.TempDir(), "live_messages.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// frames arriving together for a new camera send a single message; the
+	// others edit it
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			if err := lm.Update(tn, "yard", liveNotification("frame"), string(rune('a'+i))); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if sent := strings.Count(api.takeCalls(), "sendPhoto"); sent != 1 {
+		t.Errorf("%d messages sent, want 1", sent)
+	}
+}
@@ -2,354 +2,370 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
 	"image/jpeg"
+	"image/png"
 	"io"
-	"mime/multipart"
+	"mime"
 	"net/http"
-	"regexp"
+	"os"
 	"strconv"
-	"time"
-
-	"github.com/ad/go-parking/poly"
-	"github.com/ernyoke/imger/edgedetection"
-	"github.com/ernyoke/imger/effects"
-	"github.com/ernyoke/imger/grayscale"
-	"github.com/ernyoke/imger/resize"
-	"github.com/ernyoke/imger/utils"
-
-	"github.com/fogleman/gg"
-
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font/gofont/goregular"
+	"strings"
 )
 
-var formTemplate = `
-<html>
-<body>
-<form action="/process" method="post" enctype="multipart/form-data">
-<input type="text" name="target" value="${target}" placeholder="target">
-<input type="text" name="token" value="${token}" placeholder="token">
-<input type="checkbox" name="day" value="${day}" checked> is day
-<input type="file" name="file" />
-<input type="submit" value="Upload" />
-</form>
-</body>
-</html>
-`
-
 var version string
 
-func main() {
-	mux := http.NewServeMux()
+type server struct {
+	settings *Settings
+	queue    *Queue
+	bus      *Bus
+	cameras  cameraStates
+	history  *History
+	archive  *Archive
+	webhooks *Webhooks
+	events   *EventLog
+	live     *LiveMessages
+
+	// sources are the frame sources of the cameras, set up at start
+	sources map[string]FrameSource
+}
 
-	// return form for uploading image
-	mux.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("get form...")
-		polygon := polygons[0]
-		min, max := polygon.MinMax()
-		fmt.Println("minMax:", min, max)
+func main() {
+	settingsFile := os.Getenv("SETTINGS_FILE")
+	if settingsFile == "" {
+		settingsFile = defaultSettingsFile
+	}
 
-		w.Write([]byte(formatForm(r)))
-	})
+	settings, err := loadSettings(settingsFile)
+	if err != nil {
+		fmt.Printf("could not load settings: %s\n", err)
+		os.Exit(1)
+	}
 
-	mux.HandleFunc("/process", processImage)
+	if len(os.Args) > 1 && os.Args[1] == "timelapse" {
+		if err := timelapseCommand(settings, os.Args[2:]); err != nil {
+			fmt.Printf("timelapse: %s\n", err)
+			os.Exit(1)
+		}
 
-	fmt.Printf("Server v%s is running on localhost:9991", version)
+		return
+	}
 
-	http.ListenAndServe("0.0.0.0:9991", mux)
-}
+	s := &server{settings: settings, bus: &Bus{}, events: &EventLog{}, sources: map[string]FrameSource{}}
+	s.bus.Subscribe(s.events.add)
+	s.bus.Subscribe(s.notifyTargets)
+	s.queue = NewQueue(settings.Queue, s.processJob)
 
-func processImage(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(formatForm(r)))
+	if s.live, err = NewLiveMessages(settings.LiveMessages); err != nil {
+		fmt.Printf("could not load live messages: %s\n", err)
+		os.Exit(1)
+	}
 
-	resizeScale := 0.5
+	if len(settings.Webhooks.Hooks) > 0 {
+		if s.webhooks, err = NewWebhooks(&settings.Webhooks); err != nil {
+			fmt.Printf("could not start webhooks: %s\n", err)
+			os.Exit(1)
+		}
 
-	start := time.Now()
-	fmt.Println("Processing image...")
+		s.bus.Subscribe(s.webhooks.publish)
+	}
 
-	isDay := true
+	if settings.HomeAssistant.enabled() {
+		s.bus.Subscribe(NewHomeAssistant(&settings.HomeAssistant).publish)
+	}
 
-	if r.FormValue("day") == "1" {
-		isDay = true
+	if settings.MQTT.enabled() {
+		s.bus.Subscribe(NewMQTT(&settings.MQTT).publish)
 	}
 
-	tresholdEmpty := 94.0
-	tresholdEdges := 128.0
-	if isDay {
-		tresholdEmpty = 96.0
-		tresholdEdges = 192.0
+	if settings.History.Dir != "" {
+		s.history = NewHistory(settings.History)
+		go s.history.runCleanup()
 	}
 
-	// get file from form
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		fmt.Printf("r.FormFile error: %s", err.Error())
+	if settings.Archive.Dir != "" {
+		s.archive = NewArchive(settings.Archive)
+		go s.archive.runCleanup()
+	}
 
-		return
+	if len(settings.Heatmaps) > 0 {
+		go s.runHeatmaps()
 	}
-	defer file.Close()
 
-	// convert file to image
-	img, _, err := image.Decode(file)
-	if err != nil {
-		fmt.Printf("image.Decode error: %s", err.Error())
+	for id, camera := range settings.Cameras {
+		if camera.Source == nil {
+			continue
+		}
 
-		return
+		if err := s.runCamera(context.Background(), id, camera); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
-	b := img.Bounds()
-	imgRGBA := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(imgRGBA, imgRGBA.Bounds(), img, b.Min, draw.Src)
+	for _, ws := range settings.Watch {
+		go s.runWatch(context.Background(), ws)
+	}
 
-	imgGG := gg.NewContextForRGBA(imgRGBA)
-	imgGG.SetLineWidth(2)
+	s.runBots(context.Background())
 
-	grayscaleImg := grayscale.Grayscale(imgRGBA)
-	grayscaleImg, err = effects.SharpenGray(grayscaleImg)
-	if err != nil {
-		fmt.Printf("could not sharpen image: %s", err)
+	mux := http.NewServeMux()
 
-		return
-	}
+	// return form for uploading image
+	mux.HandleFunc("/form", func(w http.ResponseWriter, r *http.Request) {
+		if s.login(w, r) {
+			return
+		}
 
-	if resizeScale != 1.0 {
-		// Resize image to half size for faster processing
-		grayscaleImg, err = resize.ResizeGray(grayscaleImg, 0.5, 0.5, resize.InterNearest)
-		if err != nil {
-			fmt.Printf("could not resize image: %s", err)
+		s.requireScope(ScopeAnalyze, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Println("get form...")
+			s.renderForm(w, r, "")
+		})(w, r)
+	})
 
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		if s.login(w, r) {
 			return
 		}
-	}
 
-	// Edge detection
-	imgEdges, errCanny := edgedetection.CannyGray(grayscaleImg, 1, tresholdEdges, 1)
+		s.requireScope(ScopeRead, s.dashboard)(w, r)
+	})
 
-	if errCanny != nil {
-		fmt.Printf("could not detect edges: %s", errCanny)
-		return
-	}
+	mux.HandleFunc("/process", s.requireScope(ScopeAnalyze, s.processImage))
+	mux.HandleFunc("GET /jobs/{id}", s.requireScope(ScopeRead, s.getJob))
+	mux.HandleFunc("GET /jobs/{id}/image", s.requireScope(ScopeRead, s.getJobImage))
+	mux.HandleFunc("GET /cameras/{id}/image", s.requireScope(ScopeRead, s.getCameraImage))
+	mux.HandleFunc("GET /cameras/{id}/dwell", s.requireScope(ScopeRead, s.getDwell))
+	mux.HandleFunc("GET /cameras/{id}/forecast", s.requireScope(ScopeRead, s.getForecast))
+	mux.HandleFunc("GET /cameras/{id}/forecast/backtest", s.requireScope(ScopeRead, s.getBacktest))
+	mux.HandleFunc("GET /cameras/{id}/heatmap", s.requireScope(ScopeRead, s.getHeatmap))
+	mux.HandleFunc("GET /cameras/{id}/timelapse", s.requireScope(ScopeRead, s.getTimelapse))
+	mux.HandleFunc("POST /cameras/{id}/reset", s.requireScope(ScopeAdmin, s.resetCamera))
+	mux.HandleFunc("POST /cameras/{id}/capture", s.requireScope(ScopeAnalyze, s.captureCamera))
+	mux.HandleFunc("GET /stream", s.requireScope(ScopeRead, s.stream))
+	mux.HandleFunc("GET /webhooks/dead-letters", s.requireScope(ScopeAdmin, s.getDeadLetters))
+	mux.HandleFunc("POST /webhooks/dead-letters/{id}/retry", s.requireScope(ScopeAdmin, s.retryDeadLetter))
+	mux.HandleFunc("DELETE /webhooks/dead-letters/{id}", s.requireScope(ScopeAdmin, s.deleteDeadLetter))
+
+	fmt.Printf("Server v%s is running on %s\n", version, settings.Listen)
+
+	http.ListenAndServe(settings.Listen, mux)
+}
 
-	// Invert image
-	imgEdges = effects.InvertGray(imgEdges)
+// maxUploadSize limits the size of an uploaded frame.
+const maxUploadSize = 32 << 20
 
-	emptyPixel := color.Gray{Y: 0xff}
+// processImage validates the upload and queues it. The response is sent as
+// soon as the job is queued; use /jobs/{id} to follow it.
+func (s *server) processImage(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	job := &Job{
+		Camera: r.FormValue("camera"),
+		Target: r.FormValue("target"),
+		Day:    r.FormValue("day") != "0",
+	}
 
-	min, max := poly.MinMaxMany(polygons)
+	if job.Camera != "" {
+		camera, ok := s.settings.Cameras[job.Camera]
+		if !ok {
+			http.Error(w, "unknown camera", http.StatusBadRequest)
 
-	utils.ParallelForEachPixel(grayscaleImg.Bounds().Size(), func(x int, y int) {
-		if x < int(min.X*resizeScale) || x > int(max.X*resizeScale) || y < int(min.Y*resizeScale) || y > int(max.Y*resizeScale) {
 			return
 		}
-		for _, polygon := range polygons {
-			point := poly.XY{X: float64(x) / resizeScale, Y: float64(y) / resizeScale}
-			if point.In(*polygon) {
-				pixelGray := imgEdges.At(x, y)
-				if pixelGray != emptyPixel {
-					polygon.IncNonZero()
-				} else {
-					polygon.IncZero()
-				}
-			}
+
+		if job.Target == "" {
+			job.Target = camera.Target
 		}
-	})
 
-	font, _ := truetype.Parse(goregular.TTF)
-	face := truetype.NewFace(font, &truetype.Options{Size: 18})
-	imgGG.SetFontFace(face)
+		if r.FormValue("day") == "" {
+			job.Day = !camera.Night
+		}
+	}
 
-	for _, polygon := range polygons {
-		if polygon.Zero != 0 {
-			center := polygon.Center()
-			percentage := 100 - float64(polygon.NonZero)/float64(polygon.Zero)*100
+	target, ok := s.settings.Targets[job.Target]
+	if !ok {
+		http.Error(w, "unknown target", http.StatusBadRequest)
 
-			// fmt.Println(i, "Percentage:", percentage, "%")
-			if percentage > tresholdEmpty {
-				col := color.RGBA{0, 255, 0, 255}
-				DrawPolygon(imgGG, polygon, col, 5)
-			}
+		return
+	}
 
-			if percentage != 100 {
-				DrawStrokeText(imgGG, fmt.Sprintf("%.1f", percentage), center.X, center.Y, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}, 3)
-			}
+	if r.FormValue("update") == "1" {
+		if _, ok := target.notifier.(*telegramNotifier); !ok {
+			http.Error(w, "only telegram messages can be updated", http.StatusBadRequest)
+
+			return
 		}
-	}
 
-	fmt.Printf("took %s\n", time.Since(start))
+		messageID, err := strconv.ParseInt(r.FormValue("message_id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
 
-	chatID, err := strconv.ParseInt(r.FormValue("target"), 10, 64)
+		job.MessageID = messageID
+	}
+
+	data, err := readUpload(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
-	isUpdate := false
+	job.data = data
 
-	if r.FormValue("update") == "1" {
-		isUpdate = true
-	}
+	// reject non-images early, decoding itself happens on a worker
+	if _, _, err := image.DecodeConfig(bytes.NewReader(job.data)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
-	token := r.FormValue("token")
+		return
+	}
 
-	imgRGBA = imgGG.Image().(*image.RGBA)
+	if err := s.queue.Submit(job); err != nil {
+		if errors.Is(err, errQueueFull) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 
-	if isUpdate {
-		messageID := r.FormValue("message_id")
-		threadID := r.FormValue("thread_id")
+			return
+		}
 
-		sendImageUpdateToTelegram(imgRGBA, chatID, messageID, threadID, token)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 
 		return
 	}
 
-	sendImageTotelegram(imgRGBA, chatID, token)
-
-}
+	fmt.Printf("job %s queued\n", job.ID)
 
-func sendImageTotelegram(img image.Image, chatID int64, botToken string) {
-	// file, err := os.Create("output.jpg")
-	// if err != nil {
-	// 	fmt.Println(err)
-	// 	return
-	// }
-	// defer file.Close()
+	if _, fromCookie := requestKey(r); fromCookie || s.fromIngress(r) {
+		s.renderForm(w, r, fmt.Sprintf("job %s queued", job.ID))
 
-	// err = jpeg.Encode(file, img, nil)
-	// if err != nil {
-	// 	fmt.Println(err)
-	// 	return
-	// }
+		return
+	}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto?chat_id=%d", botToken, chatID)
+	w.Header().Set("Location", s.basePath(r)+"/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": job.ID, "status": string(JobQueued)})
+}
 
-	resp, err := upload(url, img)
-	if err != nil {
-		fmt.Println(err)
-		return
+// readUpload returns the uploaded frame. It is either the "file" field of a
+// multipart form or, for image/* and application/octet-stream requests, the
+// raw request body with parameters passed in the query string.
+func readUpload(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "image/") || mediaType == "application/octet-stream" {
+		return io.ReadAll(r.Body)
 	}
-	defer resp.Body.Close()
 
-	_, err = io.ReadAll(resp.Body)
+	// get file from form
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
 	}
+	defer file.Close()
 
-	// fmt.Println(string(body))
+	return io.ReadAll(file)
 }
 
-func sendImageUpdateToTelegram(img image.Image, chatID int64, messageID, threadID, botToken string) {
-	// file, err := os.Create("output.jpg")
-	// if err != nil {
-	// 	fmt.Println(err)
-	// 	return
-	// }
-	// defer file.Close()
-
-	// err = jpeg.Encode(file, img, nil)
-	// if err != nil {
-	// 	fmt.Println(err)
-	// 	return
-	// }
-
-	replyKeyboard := "reply_markup=%7B%22inline_keyboard%22%3A%20%5B%5B%7B%22text%22%3A%20%22Update%20🤓%22%2C%22callback_data%22%3A%20%22%2Fcamera_update%22%7D%5D%5D%7D&media=%7B%22type%22%3A%20%22photo%22%2C%20%22media%22%3A%22attach%3A%2F%2Fphoto%22%7D"
-
-	url := fmt.Sprintf(
-		"https://api.telegram.org/bot%s/editMessageMedia?chat_id=%d&message_id=%smessage_thread_id=%s&disable_notification=true&%s",
-		botToken,
-		chatID,
-		messageID,
-		threadID,
-		replyKeyboard,
-	)
-
-	resp, err := upload(url, img)
-	if err != nil {
-		fmt.Println(err)
+// getJob reports status, progress and result of a job. Spots below
+// ?min_confidence= are left out, as are low confidence spots in "hide" mode.
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.queue.Get(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+
 		return
 	}
-	defer resp.Body.Close()
 
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println(err)
-		return
+	var minConfidence float64
+	if v := r.FormValue("min_confidence"); v != "" {
+		var err error
+		if minConfidence, err = strconv.ParseFloat(v, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	if job.Result != nil {
+		result := *job.Result
+		result.Spots = visibleSpots(result.Spots, &s.settings.Confidence, minConfidence)
+		job.Result = &result
 	}
 
-	// fmt.Println(string(body))
+	writeJSON(w, http.StatusOK, job)
 }
 
-func upload(url string, img image.Image) (*http.Response, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return nil, err
+// getJobImage returns the annotated image of a finished job. With
+// ?overlay=1 only the annotations are drawn, as a transparent PNG to put
+// over the live camera image.
+func (s *server) getJobImage(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.queue.Get(r.PathValue("id"))
+	if !ok || job.Result == nil {
+		http.NotFound(w, r)
+
+		return
 	}
 
-	req.Header.Set("Content-Type", "multipart/form-data")
+	if r.FormValue("overlay") == "1" {
+		img := renderAnalysis(job.Result, s.layoutFor(job.Camera), &s.settings.Theme, &s.settings.Confidence, true)
 
-	var b bytes.Buffer
-	multipartWriter := multipart.NewWriter(&b)
-	fileWriter, err := multipartWriter.CreateFormFile("photo", "photo.jpg")
-	if err != nil {
-		return nil, err
-	}
-	err = jpeg.Encode(fileWriter, img, nil)
-	if err != nil {
-		return nil, err
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+
+		return
 	}
-	multipartWriter.Close()
 
-	contentType := fmt.Sprintf("multipart/form-data; boundary=%s", multipartWriter.Boundary())
-	req.Header.Set("Content-Type", contentType)
-	// req.Header.Set("Content-Length", fmt.Sprintf("%d", body.Len()))
+	w.Header().Set("Content-Type", "image/jpeg")
+	jpeg.Encode(w, job.Result.Image, nil)
+}
 
-	req.Body = io.NopCloser(&b)
+// resetCamera forgets the reference image of a camera after it was moved or
+// its view changed on purpose.
+func (s *server) resetCamera(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+		return
 	}
 
-	fmt.Println(resp.Status)
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status: %s, %s", resp.Status, resp.Body)
-	}
+	s.cameras.get(id).reset()
 
-	return resp, nil
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func formatForm(r *http.Request) string {
-	// Таблица значений переменных
-	varTable := map[string]string{
-		"target": r.FormValue("target"),
-		"token":  r.FormValue("token"),
-		"day":    r.FormValue("day"),
+// captureCamera asks the camera's source for a frame now, for sources that
+// take snapshots rather than streaming.
+func (s *server) captureCamera(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
 	}
 
-	// Функция замены, подставляет значение переменной из таблицы varTable
-	substitutor := func(match string) string {
-		// match - значение вида `${var_name}`
-		// сначала извлечём var_name
-		varName := match[2 : len(match)-1]
-		// Теперь получим значение из таблицы
-		value, ok := varTable[varName]
-		if !ok {
-			// один из вариантов обработки отсутствующего значения - вернуть пустую строку
-			value = ""
-		}
-		return value
+	c, ok := s.sources[id].(capturer)
+	if !ok {
+		http.Error(w, "camera source can not capture on demand", http.StatusConflict)
+
+		return
 	}
 
-	// Регулярное выражение для поиска строк вида
-	// 'начинается с ${, затем любые символы кроме { и }
-	// в любом количестве, заканчивается }'
-	re := regexp.MustCompile(`\${[^{}]*}`)
+	c.Capture()
 
-	return re.ReplaceAllStringFunc(formTemplate, substitutor)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("could not write response: %s\n", err)
+	}
 }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MQTTSettings configure publishing spot states, free counts and events to
+// an MQTT broker, e.g. the Mosquitto add-on.
+type MQTTSettings struct {
+	Broker       string `json:"broker"`
+	Port         int    `json:"port"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+
+	// TopicPrefix starts every topic, "parking" by default.
+	TopicPrefix string `json:"topic_prefix"`
+
+	// Events are the event types published to <prefix>/events/<type>.
+	Events []string `json:"events"`
+}
+
+func (ms *MQTTSettings) init() error {
+	if ms.PasswordFile != "" {
+		var err error
+		if ms.Password, err = readSecret(ms.PasswordFile); err != nil {
+			return err
+		}
+	}
+
+	if ms.Port == 0 {
+		ms.Port = 1883
+	}
+
+	if ms.Port < 0 || ms.Port > 65535 {
+		return fmt.Errorf("bad port %d", ms.Port)
+	}
+
+	ms.TopicPrefix = strings.Trim(ms.TopicPrefix, "/")
+	if ms.TopicPrefix == "" {
+		ms.TopicPrefix = "parking"
+	}
+
+	if strings.ContainsAny(ms.TopicPrefix, "+#") {
+		return fmt.Errorf("topic prefix %q has a wildcard", ms.TopicPrefix)
+	}
+
+	if ms.Events == nil {
+		ms.Events = []string{"spot.*", "lot.*", "camera.*"}
+	}
+
+	return nil
+}
+
+func (ms *MQTTSettings) enabled() bool {
+	return ms.Broker != ""
+}
+
+const (
+	// mqttKeepAlive is the keep alive sent in CONNECT; a ping goes out
+	// every half of it.
+	mqttKeepAlive = 60 * time.Second
+
+	// mqttRetryDelay is how long no connection is tried after one failed.
+	mqttRetryDelay = 10 * time.Second
+)
+
+// errMQTTOffline drops events while the broker is not retried.
+var errMQTTOffline = errors.New("broker is offline")
+
+// MQTT publishes to a broker over MQTT 3.1.1. Spot states and free counts
+// are retained and only sent when they change, events are not retained.
+// Everything is sent from a single goroutine, and only QoS 0 is used.
+type MQTT struct {
+	settings *MQTTSettings
+	queue    chan Event
+
+	// conn, lost, failed, states, counts and last are only used by the
+	// sending goroutine; lost is closed when the broker drops conn
+	conn   net.Conn
+	lost   chan struct{}
+	failed time.Time
+	states map[string]string
+	counts map[string]ZoneCount
+
+	// last are the last analyses of the cameras, published again after
+	// reconnecting
+	last map[string]*Analysis
+}
+
+func NewMQTT(settings *MQTTSettings) *MQTT {
+	m := &MQTT{
+		settings: settings,
+		queue:    make(chan Event, 64),
+		states:   map[string]string{},
+		counts:   map[string]ZoneCount{},
+		last:     map[string]*Analysis{},
+	}
+
+	go m.run()
+
+	return m
+}
+
+// publish is a bus subscriber. Events are dropped when the broker can not
+// keep up; the next frame brings the states up to date again.
+func (m *MQTT) publish(ev Event) {
+	if ev.Camera == "" {
+		return
+	}
+
+	if ev.Type != EventFrameAnalyzed && !matchEvent(m.settings.Events, ev.Type) {
+		return
+	}
+
+	select {
+	case m.queue <- ev:
+	default:
+		fmt.Printf("mqtt: queue is full, dropping %s event\n", ev.Type)
+	}
+}
+
+func (m *MQTT) run() {
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	// retry fires while a lost connection is down
+	var retry <-chan time.Time
+
+	for {
+		connected := m.conn != nil
+
+		select {
+		case ev := <-m.queue:
+			if err := m.send(ev); err != nil && !errors.Is(err, errMQTTOffline) {
+				fmt.Printf("mqtt: could not publish %s event: %s\n", ev.Type, err)
+			}
+		case <-ping.C:
+			if m.conn != nil {
+				m.write(0xc0, nil)
+			}
+		case <-m.lost:
+			m.close()
+		case <-retry:
+			retry = nil
+			if err := m.reconnect(); err != nil {
+				if !errors.Is(err, errMQTTOffline) {
+					fmt.Printf("mqtt: could not reconnect: %s\n", err)
+				}
+
+				retry = time.After(mqttRetryDelay)
+			}
+		}
+
+		// a connection dropped by the broker or a failed write is restored
+		// without waiting for the next frame
+		if connected && m.conn == nil {
+			fmt.Printf("mqtt: lost the connection to %s:%d\n", m.settings.Broker, m.settings.Port)
+			retry = time.After(0)
+		}
+	}
+}
+
+// reconnect connects again and publishes the states of the last analyses,
+// which the broker may have lost.
+func (m *MQTT) reconnect() error {
+	if err := m.connect(); err != nil {
+		if !errors.Is(err, errMQTTOffline) {
+			m.failed = time.Now()
+		}
+
+		return err
+	}
+
+	for camera, a := range m.last {
+		if err := m.pushAnalysis(camera, a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MQTT) send(ev Event) error {
+	a, ok := ev.Data.(*Analysis)
+	frame := ok && ev.Type == EventFrameAnalyzed
+	if frame {
+		m.last[ev.Camera] = a
+	}
+
+	if err := m.connect(); err != nil {
+		if !errors.Is(err, errMQTTOffline) {
+			m.failed = time.Now()
+		}
+
+		return err
+	}
+
+	if frame {
+		return m.pushAnalysis(ev.Camera, a)
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	return m.publishTopic(m.topic("events", ev.Type), payload, false)
+}
+
+// pushAnalysis publishes the spot states and free counts of a camera that
+// changed since they were last sent.
+func (m *MQTT) pushAnalysis(camera string, a *Analysis) error {
+	for _, spot := range a.Spots {
+		if err := m.retain(m.topic(camera, "spots", spot.ID), spot.Status); err != nil {
+			return err
+		}
+	}
+
+	m.counts[camera] = a.Counts
+	if err := m.retain(m.topic(camera, "free"), strconv.Itoa(a.Counts.Free)); err != nil {
+		return err
+	}
+
+	for _, zc := range a.Zones {
+		if err := m.retain(m.topic(camera, "zones", zc.ID, "free"), strconv.Itoa(zc.Free)); err != nil {
+			return err
+		}
+	}
+
+	free := 0
+	for _, zc := range m.counts {
+		free += zc.Free
+	}
+
+	return m.retain(m.topic("free"), strconv.Itoa(free))
+}
+
+// retain publishes a retained state unless the broker already has it.
+func (m *MQTT) retain(topic, state string) error {
+	if s, ok := m.states[topic]; ok && s == state {
+		return nil
+	}
+
+	if err := m.publishTopic(topic, []byte(state), true); err != nil {
+		return err
+	}
+
+	m.states[topic] = state
+
+	return nil
+}
+
+// topic joins the prefix and the levels, each with slashes and wildcards
+// replaced.
+func (m *MQTT) topic(levels ...string) string {
+	replacer := strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+	topic := m.settings.TopicPrefix
+	for _, level := range levels {
+		topic += "/" + replacer.Replace(level)
+	}
+
+	return topic
+}
+
+// connect dials the broker unless connected. <prefix>/status is "online"
+// while connected and the broker sets it to "offline" when the connection
+// is lost.
+func (m *MQTT) connect() error {
+	if m.conn != nil {
+		select {
+		case <-m.lost:
+			m.close()
+		default:
+			return nil
+		}
+	}
+
+	if time.Since(m.failed) < mqttRetryDelay {
+		return errMQTTOffline
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(m.settings.Broker, strconv.Itoa(m.settings.Port)), 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	status := m.topic("status")
+
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, retained will
+	payload := mqttString(nil, "go-parking-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	payload = mqttString(payload, status)
+	payload = mqttString(payload, "offline")
+
+	if m.settings.Username != "" {
+		flags |= 0x80
+		payload = mqttString(payload, m.settings.Username)
+
+		if m.settings.Password != "" {
+			flags |= 0x40
+			payload = mqttString(payload, m.settings.Password)
+		}
+	}
+
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+
+	m.conn = conn
+	if err := m.write(0x10, body); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	r := bufio.NewReader(conn)
+	kind, ack, err := readMQTTPacket(r)
+	if err == nil && (kind != 0x20 || len(ack) != 2) {
+		err = fmt.Errorf("unexpected packet %#x", kind)
+	} else if err == nil && ack[1] != 0 {
+		err = fmt.Errorf("connection refused, code %d", ack[1])
+	}
+
+	if err != nil {
+		m.close()
+
+		return err
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	// PINGRESP and anything else the broker sends are read and dropped
+	lost := make(chan struct{})
+	m.lost = lost
+
+	go func() {
+		defer close(lost)
+
+		for {
+			if _, _, err := readMQTTPacket(r); err != nil {
+				return
+			}
+		}
+	}()
+
+	fmt.Printf("mqtt: connected to %s:%d\n", m.settings.Broker, m.settings.Port)
+
+	// the broker may have lost the retained states
+	clear(m.states)
+
+	return m.publishTopic(status, []byte("online"), true)
+}
+
+func (m *MQTT) publishTopic(topic string, payload []byte, retain bool) error {
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+
+	return m.write(header, append(mqttString(nil, topic), payload...))
+}
+
+// write sends a packet and drops the connection when that fails.
+func (m *MQTT) write(header byte, body []byte) error {
+	packet := []byte{header}
+
+	// the remaining length is a varint of up to 4 bytes
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := m.conn.Write(append(packet, body...)); err != nil {
+		m.close()
+
+		return err
+	}
+
+	return nil
+}
+
+func (m *MQTT) close() {
+	m.conn.Close()
+	m.conn = nil
+	m.lost = nil
+}
+
+// mqttString appends s with its 2 byte length.
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+
+	return append(b, s...)
+}
+
+// readMQTTPacket reads a packet and returns its type and flags byte and its
+// body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("bad remaining length")
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// mqttMessage is a PUBLISH received by the fake broker.
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// fakeBroker accepts MQTT connections, answers CONNECT with code and passes
+// on the CONNECT bodies and the messages published.
+type fakeBroker struct {
+	ln       net.Listener
+	code     byte
+	connects chan []byte
+	messages chan mqttMessage
+	conns    chan net.Conn
+}
+
+func newFakeBroker(t *testing.T, code byte) *fakeBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	b := &fakeBroker{ln: ln, code: code, connects: make(chan []byte, 4), messages: make(chan mqttMessage, 64), conns: make(chan net.Conn, 4)}
+	go b.accept()
+
+	return b
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b.conns <- conn
+		go b.serve(conn)
+	}
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1:
+			b.connects <- body
+			conn.Write([]byte{0x20, 2, 0, b.code})
+		case 3:
+			n := binary.BigEndian.Uint16(body)
+			b.messages <- mqttMessage{topic: string(body[2 : 2+n]), payload: string(body[2+n:]), retain: header&1 == 1}
+		case 12:
+			conn.Write([]byte{0xd0, 0})
+		}
+	}
+}
+
+// expect returns the next n messages by topic and checks that no more
+// follow.
+func (b *fakeBroker) expect(t *testing.T, n int) map[string]mqttMessage {
+	t.Helper()
+
+	got := map[string]mqttMessage{}
+	for range n {
+		select {
+		case m := <-b.messages:
+			got[m.topic] = m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d messages of %d: %v", len(got), n, got)
+		}
+	}
+
+	select {
+	case m := <-b.messages:
+		t.Errorf("unexpected message to %s: %q", m.topic, m.payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	return got
+}
+
+func newTestMQTT(t *testing.T, b *fakeBroker, settings *MQTTSettings) *MQTT {
+	t.Helper()
+
+	settings.Broker = "127.0.0.1"
+	settings.Port = b.ln.Addr().(*net.TCPAddr).Port
+	if err := settings.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMQTT(settings)
+}
+
+func TestMQTTStates(t *testing.T) {
+	b := newFakeBroker(t, 0)
+	m := newTestMQTT(t, b, &MQTTSettings{Username: "parking", Password: "s3cret", TopicPrefix: "/home/lot/"})
+
+	m.publish(yardFrame(StatusOccupied, StatusFree, 1))
+
+	connect := <-b.connects
+	for _, want := range []string{"MQTT", "home/lot/status", "offline", "parking", "s3cret"} {
+		if !containsMQTTString(connect, want) {
+			t.Errorf("CONNECT %q has no %q", connect, want)
+		}
+	}
+
+	// protocol level 4 with clean session, a retained will, a username and
+	// a password
+	if connect[6] != 4 || connect[7] != 0xe6 {
+		t.Errorf("CONNECT level %d, flags %#x", connect[6], connect[7])
+	}
+
+	got := b.expect(t, 7)
+	for topic, want := range map[string]string{
+		"home/lot/status":               "online",
+		"home/lot/Yard 1/spots/a":       StatusOccupied,
+		"home/lot/Yard 1/spots/b-2":     StatusFree,
+		"home/lot/Yard 1/spots/c":       StatusOutOfService,
+		"home/lot/Yard 1/free":          "1",
+		"home/lot/Yard 1/zones/ev/free": "0",
+		"home/lot/free":                 "1",
+	} {
+		if msg := got[topic]; msg.payload != want || !msg.retain {
+			t.Errorf("%s: %+v, want retained %q", topic, msg, want)
+		}
+	}
+
+	// nothing changed: nothing is published
+	m.publish(yardFrame(StatusOccupied, StatusFree, 1))
+	b.expect(t, 0)
+
+	m.publish(yardFrame(StatusFree, StatusFree, 2))
+	got = b.expect(t, 3)
+
+	for _, topic := range []string{"home/lot/Yard 1/spots/a", "home/lot/Yard 1/free", "home/lot/free"} {
+		if _, ok := got[topic]; !ok {
+			t.Errorf("%s was not published: %v", topic, got)
+		}
+	}
+
+	// levels can not add levels or wildcards
+	gate := yardFrame(StatusFree, StatusFree, 2)
+	gate.Camera = "gate/#"
+	m.publish(gate)
+
+	if got := b.expect(t, 6); got["home/lot/gate__/free"].payload != "2" || got["home/lot/free"].payload != "4" {
+		t.Errorf("second camera: %v", got)
+	}
+}
+
+// containsMQTTString reports whether b holds s with its length.
+func containsMQTTString(b []byte, s string) bool {
+	want := string(mqttString(nil, s))
+	for i := range len(b) - len(want) + 1 {
+		if string(b[i:i+len(want)]) == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestMQTTEvents(t *testing.T) {
+	b := newFakeBroker(t, 0)
+	m := newTestMQTT(t, b, &MQTTSettings{Events: []string{"lot.*"}})
+
+	m.publish(Event{Type: "spot.changed", Camera: "yard"})
+	m.publish(Event{Type: "lot.full", Camera: "yard", Message: "no free spots"})
+	m.publish(Event{Type: "lot.available"})
+
+	got := b.expect(t, 2)
+
+	msg, ok := got["parking/events/lot.full"]
+	if !ok || msg.retain {
+		t.Fatalf("lot.full: %+v, want a message that is not retained", got)
+	}
+
+	var ev Event
+	if err := json.Unmarshal([]byte(msg.payload), &ev); err != nil || ev.Type != "lot.full" || ev.Camera != "yard" || ev.Message != "no free spots" {
+		t.Errorf("lot.full payload %q, %v", msg.payload, err)
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	b := newFakeBroker(t, 0)
+	m := newTestMQTT(t, b, &MQTTSettings{})
+
+	m.publish(yardFrame(StatusOccupied, StatusFree, 1))
+	<-b.connects
+	b.expect(t, 7)
+
+	// the broker restarts and forgets the retained states, they are sent
+	// again without waiting for the next frame
+	(<-b.conns).Close()
+
+	select {
+	case <-b.connects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect")
+	}
+
+	if got := b.expect(t, 7); got["parking/status"].payload != "online" || got["parking/Yard 1/spots/a"].payload != StatusOccupied {
+		t.Errorf("after reconnecting: %v", got)
+	}
+
+	// and the next frame only sends what changed
+	m.publish(yardFrame(StatusFree, StatusFree, 2))
+	if got := b.expect(t, 3); got["parking/Yard 1/spots/a"].payload != StatusFree {
+		t.Errorf("after the next frame: %v", got)
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	b := newFakeBroker(t, 5)
+
+	settings := &MQTTSettings{Broker: "127.0.0.1", Port: b.ln.Addr().(*net.TCPAddr).Port}
+	if err := settings.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// no sending goroutine, send is called directly
+	m := &MQTT{settings: settings, states: map[string]string{}, counts: map[string]ZoneCount{}}
+
+	if err := m.send(Event{Type: "lot.full", Camera: "yard"}); err == nil || m.conn != nil {
+		t.Errorf("refused connection: %v", err)
+	}
+
+	<-b.connects
+
+	// the broker is not asked again right away
+	if err := m.send(Event{Type: "lot.full", Camera: "yard"}); !errors.Is(err, errMQTTOffline) {
+		t.Errorf("retried at once: %v", err)
+	}
+
+	b.expect(t, 0)
+}
+
+func TestMQTTSettings(t *testing.T) {
+	ms := &MQTTSettings{Broker: "mqtt"}
+	if err := ms.init(); err != nil || ms.Port != 1883 || ms.TopicPrefix != "parking" || !ms.enabled() {
+		t.Errorf("defaults %+v, %v", ms, err)
+	}
+
+	for _, bad := range []*MQTTSettings{
+		{Broker: "mqtt", Port: 70000},
+		{Broker: "mqtt", TopicPrefix: "parking/#"},
+		{Broker: "mqtt", PasswordFile: "/nonexistent"},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+
+	if (&MQTTSettings{}).enabled() {
+		t.Error("enabled without a broker")
+	}
+
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	// the remaining length takes one more byte at 128, 16384 and 2097152
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		a, b := net.Pipe()
+		m := &MQTT{conn: a}
+
+		go m.write(0x30, make([]byte, n))
+
+		header, body, err := readMQTTPacket(bufio.NewReader(b))
+		if err != nil || header != 0x30 || len(body) != n {
+			t.Errorf("%d bytes: %#x, %d, %v", n, header, len(body), err)
+		}
+
+		a.Close()
+		b.Close()
+	}
+}
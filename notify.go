@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EventHeatmap is the type of scheduled heatmap notifications. It is not
+// published on the bus.
+const EventHeatmap = "heatmap"
+
+// notifyClient sends notifications over HTTP.
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notification is a message for a target: the event it is about, its text
+// and an optional image.
+type Notification struct {
+	Event Event
+	Text  string
+	Image image.Image
+}
+
+// Notifier sends notifications to one destination. Notifiers that can not
+// send images send the text only.
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// Target is a named destination of notifications. Clients refer to it by
+// name only.
+type Target struct {
+	// Type is telegram (the default), slack, discord, matrix, ntfy, gotify or
+	// email.
+	Type string `json:"type"`
+
+	// URL is the webhook of slack and discord, the server of matrix, ntfy
+	// and gotify, and replaces the Telegram Bot API.
+	URL string `json:"url"`
+
+	// Token is the bot token of telegram and the access token of matrix,
+	// ntfy and gotify.
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+
+	// telegram
+	ChatID   int64 `json:"chat_id"`
+	ThreadID int64 `json:"thread_id"`
+
+	// Live edits one pinned message per camera with the latest frame
+	// instead of sending a photo per frame. Only for telegram.
+	Live bool `json:"live"`
+
+	// Commands answers bot commands such as /forecast sent to the target's
+	// chat. Only for telegram.
+	Commands bool `json:"commands"`
+
+	// matrix
+	Room string `json:"room"`
+
+	// ntfy
+	Topic string `json:"topic"`
+
+	// email
+	SMTP         string   `json:"smtp"`
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	PasswordFile string   `json:"password_file"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+
+	// Zones are reported in the photo caption, e.g. "EV charging: 2 free of 4".
+	Zones []string `json:"zones"`
+
+	// Templates replace the text of notifications by event type, e.g.
+	// "spot.*" or "frame.analyzed" for photo captions. They are Go
+	// templates over the event with .Text holding the default text.
+	Templates map[string]string `json:"templates"`
+
+	notifier  Notifier
+	templates map[string]*template.Template
+}
+
+func (t *Target) init() error {
+	var err error
+	if t.TokenFile != "" {
+		if t.Token, err = readSecret(t.TokenFile); err != nil {
+			return err
+		}
+	}
+
+	if t.PasswordFile != "" {
+		if t.Password, err = readSecret(t.PasswordFile); err != nil {
+			return err
+		}
+	}
+
+	t.templates = map[string]*template.Template{}
+	for pattern, text := range t.Templates {
+		if t.templates[pattern], err = template.New(pattern).Parse(text); err != nil {
+			return fmt.Errorf("template %s: %w", pattern, err)
+		}
+	}
+
+	t.URL = strings.TrimSuffix(t.URL, "/")
+
+	switch t.Type {
+	case "", "telegram":
+		t.notifier, err = newTelegramNotifier(t)
+	case "slack", "discord":
+		t.notifier, err = newChatWebhookNotifier(t)
+	case "matrix":
+		t.notifier, err = newMatrixNotifier(t)
+	case "ntfy":
+		t.notifier, err = newNtfyNotifier(t)
+	case "gotify":
+		t.notifier, err = newGotifyNotifier(t)
+	case "email":
+		t.notifier, err = newEmailNotifier(t)
+	default:
+		err = fmt.Errorf("unknown type %q", t.Type)
+	}
+
+	if _, ok := t.notifier.(*telegramNotifier); err == nil && t.Live && !ok {
+		err = errors.New("live messages are only supported by telegram")
+	}
+
+	if _, ok := t.notifier.(*telegramNotifier); err == nil && t.Commands && !ok {
+		err = errors.New("bot commands are only supported by telegram")
+	}
+
+	return err
+}
+
+// templateData is what templates are executed with.
+type templateData struct {
+	Event
+	Text string
+}
+
+// text returns the text of n for this target, from the template matching
+// the event type best: the type itself, then the longest prefix pattern.
+func (t *Target) text(n *Notification) (string, error) {
+	tmpl := t.templates[n.Event.Type]
+	if tmpl == nil {
+		best := -1
+		for pattern, pt := range t.templates {
+			if matchEvent([]string{pattern}, n.Event.Type) && len(pattern) > best {
+				tmpl, best = pt, len(pattern)
+			}
+		}
+	}
+
+	if tmpl == nil {
+		return n.Text, nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, templateData{Event: n.Event, Text: n.Text}); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// notify sends n to the target with its text from the templates.
+func (t *Target) notify(n *Notification) error {
+	text, err := t.text(n)
+	if err != nil {
+		return err
+	}
+
+	sent := *n
+	sent.Text = text
+
+	return t.notifier.Notify(&sent)
+}
+
+// notificationTitle names the sender in notifiers with a title.
+func notificationTitle(n *Notification) string {
+	if n.Event.Camera != "" {
+		return "go-parking: " + n.Event.Camera
+	}
+
+	return "go-parking"
+}
+
+// plainText is the text of notifiers that drop the image: the event message
+// stands in for an empty caption.
+func plainText(n *Notification) string {
+	if n.Text == "" {
+		return n.Event.Message
+	}
+
+	return n.Text
+}
+
+// encodeJPEG returns img as a JPEG file.
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// doRequest sends req and fails on statuses other than 2xx.
+func doRequest(req *http.Request) ([]byte, error) {
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bad status: %s, %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
+
+// requireURL checks that the target has a URL, which most notifiers need.
+func requireURL(t *Target) error {
+	if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+		return errors.New("url is required")
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// smtpTimeout limits the whole conversation with the SMTP server, like
+// notifyClient does for HTTP.
+var smtpTimeout = 30 * time.Second
+
+// emailNotifier sends mail over SMTP. STARTTLS is used when the server
+// offers it; the first line of the text is the subject and images are
+// attached.
+type emailNotifier struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func newEmailNotifier(t *Target) (*emailNotifier, error) {
+	host, _, err := net.SplitHostPort(t.SMTP)
+	if err != nil {
+		return nil, fmt.Errorf("smtp must be host:port: %w", err)
+	}
+
+	if t.From == "" || len(t.To) == 0 {
+		return nil, errors.New("from and to are required")
+	}
+
+	en := &emailNotifier{addr: t.SMTP, host: host, from: t.From, to: t.To}
+
+	if t.Username != "" {
+		en.auth = smtp.PlainAuth("", t.Username, t.Password, host)
+	}
+
+	return en, nil
+}
+
+func (en *emailNotifier) Notify(n *Notification) error {
+	msg, err := en.message(n)
+	if err != nil {
+		return err
+	}
+
+	return en.send(msg)
+}
+
+// send delivers msg like smtp.SendMail, but gives up after smtpTimeout.
+func (en *emailNotifier) send(msg []byte) error {
+	conn, err := net.DialTimeout("tcp", en.addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	c, err := smtp.NewClient(conn, en.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: en.host}); err != nil {
+			return err
+		}
+	}
+
+	if en.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+
+		if err := c.Auth(en.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(en.from); err != nil {
+		return err
+	}
+
+	for _, to := range en.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// message builds a multipart/mixed mail with the text and the image.
+func (en *emailNotifier) message(n *Notification) ([]byte, error) {
+	subject, _, _ := strings.Cut(n.Text, "\n")
+	if subject == "" {
+		subject = notificationTitle(n)
+	}
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	fmt.Fprintf(&b, "From: %s\r\n", en.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(en.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qw := quotedprintable.NewWriter(pw)
+	qw.Write([]byte(n.Text))
+	qw.Close()
+
+	if n.Image != nil {
+		photo, err := encodeJPEG(n.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {`attachment; filename="photo.jpg"`},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// base64 lines must not be longer than 76 characters
+		encoded := base64.StdEncoding.EncodeToString(photo)
+		for len(encoded) > 76 {
+			fmt.Fprintf(pw, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(pw, "%s\r\n", encoded)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMail is a mail received by the fake SMTP server.
+type smtpMail struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP speaks just enough SMTP for net/smtp: it offers AUTH PLAIN, no
+// STARTTLS, and rejects recipients with reject when set.
+type fakeSMTP struct {
+	ln     net.Listener
+	reject string
+	mails  chan smtpMail
+}
+
+func newFakeSMTP(t *testing.T, reject string) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	fs := &fakeSMTP{ln: ln, reject: reject, mails: make(chan smtpMail, 4)}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go fs.serve(conn)
+		}
+	}()
+
+	return fs
+}
+
+func (fs *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var m smtpMail
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			m.auth = string(decoded)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			m.from = arg
+			reply("250 OK")
+		case "RCPT":
+			if fs.reject != "" {
+				reply(fs.reject)
+
+				continue
+			}
+
+			m.to = append(m.to, arg)
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if line == ".\r\n" {
+					break
+				}
+
+				data.WriteString(line)
+			}
+
+			m.data = data.String()
+			fs.mails <- m
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	fs := newFakeSMTP(t, "")
+	target := newTestTarget(t, &Target{
+		Type:      "email",
+		SMTP:      fs.ln.Addr().String(),
+		Username:  "parking",
+		Password:  "s3cret",
+		From:      "parking@example.com",
+		To:        []string{"a@example.com", "b@example.com"},
+		Templates: map[string]string{"lot.*": "Парковка {{.Camera}}\n{{.Text}}"},
+	})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventLotFull, Camera: "yard"}, Text: "no free spots", Image: notifyImage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-fs.mails
+	if m.auth != "\x00parking\x00s3cret" || m.from != "FROM:<parking@example.com>" || len(m.to) != 2 || m.to[1] != "TO:<b@example.com>" {
+		t.Errorf("envelope %+v", m)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(m.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first line of the text is the subject
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Парковка yard" || msg.Header.Get("To") != "a@example.com, b@example.com" {
+		t.Errorf("subject %q, to %q, %v", subject, msg.Header.Get("To"), err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %s, %v", mediaType, err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	text, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the part reader decodes quoted-printable, line breaks are CRLF
+	if body, _ := io.ReadAll(text); string(body) != "Парковка yard\r\nno free spots" {
+		t.Errorf("text part %q", body)
+	}
+
+	photo, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if photo.FileName() != "photo.jpg" || photo.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("attachment header %v", photo.Header)
+	}
+
+	encoded, _ := io.ReadAll(photo)
+	for line := range strings.SplitSeq(strings.TrimSpace(string(encoded)), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line of %d characters", len(line))
+		}
+	}
+
+	if cfg, err := jpeg.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(strings.ReplaceAll(string(encoded), "\r\n", "")))); err != nil || cfg.Width != 8 {
+		t.Errorf("attachment: %+v, %v", cfg, err)
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("more parts: %v", err)
+	}
+}
+
+func TestEmailNotifierErrors(t *testing.T) {
+	fs := newFakeSMTP(t, "550 5.1.1 No such user")
+	target := newTestTarget(t, &Target{Type: "email", SMTP: fs.ln.Addr().String(), From: "parking@example.com", To: []string{"nobody@example.com"}})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventLotFull}, Text: "no free spots"}); err == nil || !strings.Contains(err.Error(), "No such user") {
+		t.Errorf("rejected recipient: %v", err)
+	}
+
+	// without text the title is the subject
+	en := target.notifier.(*emailNotifier)
+
+	data, err := en.message(&Notification{Event: Event{Camera: "yard"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject := msg.Header.Get("Subject"); subject != "go-parking: yard" {
+		t.Errorf("subject %q", subject)
+	}
+
+	// nothing listens
+	fs.ln.Close()
+	if err := target.notify(&Notification{Text: "text"}); err == nil {
+		t.Error("sent without a server")
+	}
+}
+
+func TestEmailNotifierTimeout(t *testing.T) {
+	// a server that accepts the connection and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	timeout := smtpTimeout
+	smtpTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { smtpTimeout = timeout })
+
+	target := newTestTarget(t, &Target{Type: "email", SMTP: ln.Addr().String(), From: "parking@example.com", To: []string{"a@example.com"}})
+
+	start := time.Now()
+	if err := target.notify(&Notification{Text: "text"}); err == nil {
+		t.Error("sent to a server that does not answer")
+	}
+
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("gave up after %s", took)
+	}
+}
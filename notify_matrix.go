@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// matrixNotifier posts to a Matrix room with the client-server API. Images
+// are uploaded to the media repository and sent with the text as caption.
+type matrixNotifier struct {
+	url   string
+	token string
+	room  string
+
+	txn atomic.Int64
+}
+
+func newMatrixNotifier(t *Target) (*matrixNotifier, error) {
+	if err := requireURL(t); err != nil {
+		return nil, err
+	}
+
+	if t.Token == "" || t.Room == "" {
+		return nil, errors.New("token and room are required")
+	}
+
+	mn := &matrixNotifier{url: t.URL, token: t.Token, room: t.Room}
+
+	// transaction ids must not repeat across restarts
+	mn.txn.Store(time.Now().UnixNano())
+
+	return mn, nil
+}
+
+func (mn *matrixNotifier) Notify(n *Notification) error {
+	content := map[string]any{"msgtype": "m.text", "body": n.Text}
+
+	if n.Image != nil {
+		photo, err := encodeJPEG(n.Image)
+		if err != nil {
+			return err
+		}
+
+		uri, err := mn.upload(photo)
+		if err != nil {
+			return err
+		}
+
+		body := n.Text
+		if body == "" {
+			body = "photo.jpg"
+		}
+
+		b := n.Image.Bounds()
+		content = map[string]any{
+			"msgtype":  "m.image",
+			"body":     body,
+			"filename": "photo.jpg",
+			"url":      uri,
+			"info":     map[string]any{"mimetype": "image/jpeg", "size": len(photo), "w": b.Dx(), "h": b.Dy()},
+		}
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%d", url.PathEscape(mn.room), mn.txn.Add(1))
+
+	_, err = mn.request(http.MethodPut, path, "application/json", data)
+
+	return err
+}
+
+// upload stores a JPEG in the media repository and returns its mxc:// URI.
+func (mn *matrixNotifier) upload(photo []byte) (string, error) {
+	body, err := mn.request(http.MethodPost, "/_matrix/media/v3/upload?filename=photo.jpg", "image/jpeg", photo)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ContentURI string `json:"content_uri"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.ContentURI == "" {
+		return "", errors.New("upload returned no content_uri")
+	}
+
+	return resp.ContentURI, nil
+}
+
+func (mn *matrixNotifier) request(method, path, contentType string, data []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, mn.url+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+mn.token)
+	req.Header.Set("Content-Type", contentType)
+
+	return doRequest(req)
+}
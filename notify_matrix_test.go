@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// matrixServer serves the media upload and passes on the messages sent.
+type matrixServer struct {
+	contentURI string
+	status     int
+	uploads    chan notifyRequest
+	messages   chan notifyRequest
+}
+
+func newMatrixServer(t *testing.T, contentURI string, status int) (*matrixServer, string) {
+	t.Helper()
+
+	ms := &matrixServer{contentURI: contentURI, status: status, uploads: make(chan notifyRequest, 4), messages: make(chan notifyRequest, 4)}
+
+	ts := httptest.NewServer(ms)
+	t.Cleanup(ts.Close)
+
+	return ms, ts.URL
+}
+
+func (ms *matrixServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body bytes.Buffer
+	body.ReadFrom(r.Body)
+
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errcode": "M_UNKNOWN_TOKEN"}`))
+
+		return
+	}
+
+	if ms.status != http.StatusOK {
+		w.WriteHeader(ms.status)
+		w.Write([]byte(`{"errcode": "M_FORBIDDEN"}`))
+
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/_matrix/media/v3/upload":
+		ms.uploads <- notifyRequest{Request: r, body: body.Bytes()}
+		json.NewEncoder(w).Encode(map[string]string{"content_uri": ms.contentURI})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"):
+		ms.messages <- notifyRequest{Request: r, body: body.Bytes()}
+		w.Write([]byte(`{"event_id": "$1"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	ms, msURL := newMatrixServer(t, "mxc://matrix/abc", http.StatusOK)
+	target := newTestTarget(t, &Target{Type: "matrix", URL: msURL, Token: "tok", Room: "!room:matrix", Templates: map[string]string{"lot.full": "FULL: {{.Text}}"}})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventLotFull}, Text: "no free spots"}); err != nil {
+		t.Fatal(err)
+	}
+
+	text := <-ms.messages
+
+	var content map[string]any
+	if err := json.Unmarshal(text.body, &content); err != nil || content["msgtype"] != "m.text" || content["body"] != "FULL: no free spots" {
+		t.Errorf("text message %s, %v", text.body, err)
+	}
+
+	if !strings.HasPrefix(text.URL.EscapedPath(), "/_matrix/client/v3/rooms/%21room:matrix/send/m.room.message/") {
+		t.Errorf("path %s, want the escaped room", text.URL.EscapedPath())
+	}
+
+	// the image is uploaded first and sent with the text as caption
+	if err := target.notify(&Notification{Event: Event{Type: EventFrameAnalyzed}, Image: notifyImage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := <-ms.uploads
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(upload.body)); err != nil || cfg.Width != 8 || upload.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("upload %s: %+v, %v", upload.Header.Get("Content-Type"), cfg, err)
+	}
+
+	photo := <-ms.messages
+	if err := json.Unmarshal(photo.body, &content); err != nil {
+		t.Fatal(err)
+	}
+
+	info, _ := content["info"].(map[string]any)
+	if content["msgtype"] != "m.image" || content["url"] != "mxc://matrix/abc" || content["body"] != "photo.jpg" ||
+		info["w"] != 8.0 || info["h"] != 6.0 || info["size"] != float64(len(upload.body)) {
+		t.Errorf("image message %s", photo.body)
+	}
+
+	// every message has its own transaction id
+	if text.URL.Path == photo.URL.Path {
+		t.Errorf("transaction id repeated: %s", photo.URL.Path)
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	_, msURL := newMatrixServer(t, "mxc://matrix/abc", http.StatusOK)
+
+	target := newTestTarget(t, &Target{Type: "matrix", URL: msURL, Token: "wrong", Room: "!room:matrix"})
+	if err := target.notify(&Notification{Text: "text"}); err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Errorf("wrong token: %v", err)
+	}
+
+	_, msURL = newMatrixServer(t, "mxc://matrix/abc", http.StatusForbidden)
+
+	target = newTestTarget(t, &Target{Type: "matrix", URL: msURL, Token: "tok", Room: "!room:matrix"})
+	if err := target.notify(&Notification{Text: "photo", Image: notifyImage()}); err == nil || !strings.Contains(err.Error(), "M_FORBIDDEN") {
+		t.Errorf("failed upload: %v", err)
+	}
+
+	ms, msURL := newMatrixServer(t, "", http.StatusOK)
+
+	target = newTestTarget(t, &Target{Type: "matrix", URL: msURL, Token: "tok", Room: "!room:matrix"})
+	if err := target.notify(&Notification{Text: "photo", Image: notifyImage()}); err == nil || !strings.Contains(err.Error(), "content_uri") {
+		t.Errorf("upload without content_uri: %v", err)
+	}
+
+	if len(ms.messages) != 0 {
+		t.Error("a message was sent without its image")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ntfyNotifier publishes to an ntfy topic. Images are sent as attachments
+// with the text as message.
+type ntfyNotifier struct {
+	url   string
+	token string
+}
+
+func newNtfyNotifier(t *Target) (*ntfyNotifier, error) {
+	if err := requireURL(t); err != nil {
+		return nil, err
+	}
+
+	if t.Topic == "" {
+		return nil, errors.New("topic is required")
+	}
+
+	return &ntfyNotifier{url: t.URL + "/" + url.PathEscape(t.Topic), token: t.Token}, nil
+}
+
+func (nn *ntfyNotifier) Notify(n *Notification) error {
+	var (
+		body  []byte
+		query = url.Values{"title": {notificationTitle(n)}}
+	)
+
+	if n.Image != nil {
+		var err error
+		if body, err = encodeJPEG(n.Image); err != nil {
+			return err
+		}
+
+		// headers can not carry UTF-8, the query string can
+		query.Set("message", n.Text)
+		query.Set("filename", "photo.jpg")
+	} else {
+		body = []byte(n.Text)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, nn.url+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if nn.token != "" {
+		req.Header.Set("Authorization", "Bearer "+nn.token)
+	}
+
+	_, err = doRequest(req)
+
+	return err
+}
+
+// gotifyNotifier sends messages to a Gotify server. Gotify has no
+// attachments, so images are not sent.
+type gotifyNotifier struct {
+	url   string
+	token string
+}
+
+func newGotifyNotifier(t *Target) (*gotifyNotifier, error) {
+	if err := requireURL(t); err != nil {
+		return nil, err
+	}
+
+	if t.Token == "" {
+		return nil, errors.New("token is required")
+	}
+
+	return &gotifyNotifier{url: t.URL + "/message", token: t.Token}, nil
+}
+
+func (gn *gotifyNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(map[string]any{
+		"title":    notificationTitle(n),
+		"message":  plainText(n),
+		"priority": gotifyPriority(n.Event.Type),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, gn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", gn.token)
+
+	_, err = doRequest(req)
+
+	return err
+}
+
+// gotifyPriority raises problems and violations above the default 5, which
+// makes them pop up on Android.
+func gotifyPriority(t string) int {
+	if t == EventCameraProblem || t == EventSpotViolation || t == EventSpotOverstay {
+		return 8
+	}
+
+	return 5
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNtfyNotifier(t *testing.T) {
+	ntfy, ntfyURL := newNotifyReceiver(t, http.StatusOK, `{"id": "1"}`)
+	target := newTestTarget(t, &Target{Type: "ntfy", URL: ntfyURL, Topic: "parking lot", Token: "tok", Templates: map[string]string{"spot.*": "Место: {{.Text}}"}})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventSpotOverstay, Camera: "yard"}, Text: "3 is taken for 5h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ntfy.next(t)
+	if r.Method != http.MethodPut || r.URL.EscapedPath() != "/parking%20lot" || r.Header.Get("Authorization") != "Bearer tok" {
+		t.Errorf("%s %s, authorization %q", r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"))
+	}
+
+	if string(r.body) != "Место: 3 is taken for 5h" || r.URL.Query().Get("title") != "go-parking: yard" {
+		t.Errorf("body %q, title %q", r.body, r.URL.Query().Get("title"))
+	}
+
+	// the image is the body, the text goes in the query
+	if err := target.notify(&Notification{Event: Event{Type: EventFrameAnalyzed}, Text: "2 свободно", Image: notifyImage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	r = ntfy.next(t)
+	if q := r.URL.Query(); q.Get("message") != "2 свободно" || q.Get("filename") != "photo.jpg" || q.Get("title") != "go-parking" {
+		t.Errorf("query %v", q)
+	}
+
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(r.body)); err != nil || cfg.Width != 8 {
+		t.Errorf("attachment: %+v, %v", cfg, err)
+	}
+
+	// no token, no header
+	anonymous := newTestTarget(t, &Target{Type: "ntfy", URL: ntfyURL, Topic: "parking"})
+	if err := anonymous.notify(&Notification{Text: "text"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := ntfy.next(t); r.Header.Get("Authorization") != "" {
+		t.Errorf("authorization %q without a token", r.Header.Get("Authorization"))
+	}
+
+	_, brokenURL := newNotifyReceiver(t, http.StatusForbidden, `{"error": "forbidden"}`)
+	target = newTestTarget(t, &Target{Type: "ntfy", URL: brokenURL, Topic: "parking"})
+
+	if err := target.notify(&Notification{Text: "text"}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("403: %v", err)
+	}
+}
+
+func TestGotifyNotifier(t *testing.T) {
+	gotify, gotifyURL := newNotifyReceiver(t, http.StatusOK, `{"id": 1}`)
+	target := newTestTarget(t, &Target{Type: "gotify", URL: gotifyURL + "/", Token: "tok", Templates: map[string]string{"camera.problem": "⚠️ {{.Text}}"}})
+
+	tests := []struct {
+		n        *Notification
+		message  string
+		title    string
+		priority float64
+	}{
+		{&Notification{Event: Event{Type: EventCameraProblem, Camera: "yard"}, Text: "no frames"}, "⚠️ no frames", "go-parking: yard", 8},
+		{&Notification{Event: Event{Type: EventLotAvailable, Message: "3 free"}, Image: notifyImage()}, "3 free", "go-parking", 5},
+		{&Notification{Event: Event{Type: EventSpotViolation, Camera: "gate"}, Text: "blocked"}, "blocked", "go-parking: gate", 8},
+	}
+
+	for _, tt := range tests {
+		if err := target.notify(tt.n); err != nil {
+			t.Fatal(err)
+		}
+
+		r := gotify.next(t)
+
+		var payload map[string]any
+		if err := json.Unmarshal(r.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+
+		if r.URL.Path != "/message" || r.Header.Get("X-Gotify-Key") != "tok" {
+			t.Errorf("%s, key %q", r.URL.Path, r.Header.Get("X-Gotify-Key"))
+		}
+
+		if payload["message"] != tt.message || payload["title"] != tt.title || payload["priority"] != tt.priority {
+			t.Errorf("%s: payload %v", tt.n.Event.Type, payload)
+		}
+	}
+
+	_, brokenURL := newNotifyReceiver(t, http.StatusUnauthorized, `{"error": "Unauthorized"}`)
+	target = newTestTarget(t, &Target{Type: "gotify", URL: brokenURL, Token: "wrong"})
+
+	if err := target.notify(&Notification{Text: "text"}); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("401: %v", err)
+	}
+}
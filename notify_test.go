@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// notifyRequest is a request received by a notifyReceiver, its body read.
+type notifyRequest struct {
+	*http.Request
+	body []byte
+}
+
+// notifyReceiver answers every request with status and response and passes
+// the requests on.
+type notifyReceiver struct {
+	status   int
+	response string
+	requests chan notifyRequest
+}
+
+func newNotifyReceiver(t *testing.T, status int, response string) (*notifyReceiver, string) {
+	t.Helper()
+
+	nr := &notifyReceiver{status: status, response: response, requests: make(chan notifyRequest, 16)}
+
+	ts := httptest.NewServer(nr)
+	t.Cleanup(ts.Close)
+
+	return nr, ts.URL
+}
+
+func (nr *notifyReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	nr.requests <- notifyRequest{Request: r, body: body}
+
+	w.WriteHeader(nr.status)
+	io.WriteString(w, nr.response)
+}
+
+// next returns the next request.
+func (nr *notifyReceiver) next(t *testing.T) notifyRequest {
+	t.Helper()
+
+	select {
+	case r := <-nr.requests:
+		return r
+	default:
+		t.Fatal("no request")
+
+		return notifyRequest{}
+	}
+}
+
+// newTestTarget initialises target or fails the test.
+func newTestTarget(t *testing.T, target *Target) *Target {
+	t.Helper()
+
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return target
+}
+
+func notifyImage() image.Image {
+	return image.NewRGBA(image.Rect(0, 0, 8, 6))
+}
+
+func TestTargetText(t *testing.T) {
+	target := newTestTarget(t, &Target{Type: "gotify", URL: "http://gotify", Token: "tok", Templates: map[string]string{
+		"*":              "any: {{.Text}}",
+		"spot.*":         "{{.Camera}}: {{.Text}}",
+		"spot.violation": "🚫 {{.Message}}",
+		"lot.full":       "{{.Nope}}",
+	}})
+
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Type: EventSpotViolation, Camera: "yard", Message: "spot 3 is blocked"}, "🚫 spot 3 is blocked"},
+		{Event{Type: EventSpotOverstay, Camera: "yard"}, "yard: default"},
+		{Event{Type: EventCameraProblem, Camera: "yard"}, "any: default"},
+	}
+
+	for _, tt := range tests {
+		got, err := target.text(&Notification{Event: tt.event, Text: "default"})
+		if err != nil || got != tt.want {
+			t.Errorf("%s: %q, %v, want %q", tt.event.Type, got, err, tt.want)
+		}
+	}
+
+	// without templates the text is kept
+	plain := newTestTarget(t, &Target{Type: "gotify", URL: "http://gotify", Token: "tok"})
+	if got, err := plain.text(&Notification{Event: Event{Type: EventLotFull}, Text: "default"}); err != nil || got != "default" {
+		t.Errorf("no templates: %q, %v", got, err)
+	}
+
+	// a failing template is an error, nothing is sent
+	if err := target.notify(&Notification{Event: Event{Type: EventLotFull}}); err == nil || !strings.Contains(err.Error(), "Nope") {
+		t.Errorf("failing template: %v", err)
+	}
+
+	if err := (&Target{Type: "gotify", URL: "http://gotify", Token: "tok", Templates: map[string]string{"*": "{{"}}).init(); err == nil {
+		t.Error("a broken template was accepted")
+	}
+}
+
+func TestTargetInit(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		target *Target
+	}{
+		{"telegram without chat", &Target{Token: "123:abc"}},
+		{"slack without url", &Target{Type: "slack"}},
+		{"discord with a bad url", &Target{Type: "discord", URL: "discord.com/api/webhooks/1"}},
+		{"matrix without room", &Target{Type: "matrix", URL: "http://matrix", Token: "tok"}},
+		{"ntfy without topic", &Target{Type: "ntfy", URL: "http://ntfy"}},
+		{"gotify without token", &Target{Type: "gotify", URL: "http://gotify"}},
+		{"email without port", &Target{Type: "email", SMTP: "mail", From: "a@b", To: []string{"c@d"}}},
+		{"email without to", &Target{Type: "email", SMTP: "mail:25", From: "a@b"}},
+		{"live for slack", &Target{Type: "slack", URL: "http://slack", Live: true}},
+		{"unknown type", &Target{Type: "pager"}},
+	} {
+		if err := tt.target.init(); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+)
+
+// chatWebhookNotifier posts to a Slack or Discord incoming webhook. Slack
+// webhooks take no files, so images are only sent to Discord.
+type chatWebhookNotifier struct {
+	url     string
+	discord bool
+}
+
+func newChatWebhookNotifier(t *Target) (*chatWebhookNotifier, error) {
+	if err := requireURL(t); err != nil {
+		return nil, err
+	}
+
+	return &chatWebhookNotifier{url: t.URL, discord: t.Type == "discord"}, nil
+}
+
+func (cn *chatWebhookNotifier) Notify(n *Notification) error {
+	if cn.discord && n.Image != nil {
+		return cn.sendDiscordImage(n)
+	}
+
+	field := "text"
+	if cn.discord {
+		field = "content"
+	}
+
+	body, err := json.Marshal(map[string]string{field: plainText(n)})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = doRequest(req)
+
+	return err
+}
+
+// sendDiscordImage attaches the image to the message.
+func (cn *chatWebhookNotifier) sendDiscordImage(n *Notification) error {
+	payload, err := json.Marshal(map[string]string{"content": n.Text})
+	if err != nil {
+		return err
+	}
+
+	photo, err := encodeJPEG(n.Image)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	if err := mw.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+
+	fw, err := mw.CreateFormFile("files[0]", "photo.jpg")
+	if err != nil {
+		return err
+	}
+
+	fw.Write(photo)
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, cn.url, &b)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	_, err = doRequest(req)
+
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	slack, slackURL := newNotifyReceiver(t, http.StatusOK, "ok")
+	target := newTestTarget(t, &Target{Type: "slack", URL: slackURL, Templates: map[string]string{"camera.*": "📷 {{.Camera}}: {{.Text}}"}})
+
+	for _, tt := range []struct {
+		n    *Notification
+		want string
+	}{
+		{&Notification{Event: Event{Type: EventCameraProblem, Camera: "yard"}, Text: "no frames"}, "📷 yard: no frames"},
+		// slack takes no files, the text is sent alone
+		{&Notification{Event: Event{Type: EventFrameAnalyzed}, Text: "2 free", Image: notifyImage()}, "2 free"},
+		// the event message stands in for an empty text
+		{&Notification{Event: Event{Type: EventLotFull, Message: "no free spots"}}, "no free spots"},
+	} {
+		if err := target.notify(tt.n); err != nil {
+			t.Fatal(err)
+		}
+
+		r := slack.next(t)
+
+		var payload map[string]string
+		if err := json.Unmarshal(r.body, &payload); err != nil || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("%s %s: %s, %v", r.Method, r.Header.Get("Content-Type"), r.body, err)
+		}
+
+		if len(payload) != 1 || payload["text"] != tt.want {
+			t.Errorf("payload %v, want text %q", payload, tt.want)
+		}
+	}
+
+	_, brokenURL := newNotifyReceiver(t, http.StatusNotFound, "no_service")
+	target = newTestTarget(t, &Target{Type: "slack", URL: brokenURL})
+
+	if err := target.notify(&Notification{Text: "text"}); err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "no_service") {
+		t.Errorf("404: %v, want the status and the body", err)
+	}
+}
+
+func TestDiscordNotifier(t *testing.T) {
+	discord, discordURL := newNotifyReceiver(t, http.StatusNoContent, "")
+	target := newTestTarget(t, &Target{Type: "discord", URL: discordURL, Templates: map[string]string{"frame.analyzed": "{{.Text}} at the gate"}})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventLotAvailable, Message: "3 free"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal(discord.next(t).body, &payload); err != nil || len(payload) != 1 || payload["content"] != "3 free" {
+		t.Errorf("payload %v, %v, want content", payload, err)
+	}
+
+	// images are attached with the payload in payload_json
+	if err := target.notify(&Notification{Event: Event{Type: EventFrameAnalyzed}, Text: "2 free", Image: notifyImage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := discord.next(t)
+
+	data, _ := io.ReadAll(readPhoto(t, r, "payload_json"))
+	if err := json.Unmarshal(data, &payload); err != nil || payload["content"] != "2 free at the gate" {
+		t.Errorf("payload_json %s, %v", data, err)
+	}
+
+	if cfg, err := jpeg.DecodeConfig(readPhoto(t, r, "files[0]")); err != nil || cfg.Width != 8 {
+		t.Errorf("attachment: %+v, %v", cfg, err)
+	}
+
+	_, brokenURL := newNotifyReceiver(t, http.StatusTooManyRequests, `{"message": "You are being rate limited."}`)
+	target = newTestTarget(t, &Target{Type: "discord", URL: brokenURL})
+
+	for _, n := range []*Notification{{Text: "text"}, {Text: "photo", Image: notifyImage()}} {
+		if err := target.notify(n); err == nil || !strings.Contains(err.Error(), "rate limited") {
+			t.Errorf("%s: %v, want the rate limit", n.Text, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// defaultOptionsFile is where the Supervisor stores the options of the Home
+// Assistant add-on, see the schema in config.json.
+const defaultOptionsFile = "/data/options.json"
+
+// AddonOptions are the options set in the add-on configuration tab. They are
+// added to the settings file, which stays available for everything else.
+type AddonOptions struct {
+	Cameras  []*AddonCamera `json:"cameras"`
+	Telegram []*AddonTarget `json:"telegram"`
+	MQTT     *AddonMQTT     `json:"mqtt"`
+
+	// APIKey grants admin access on the add-on port. The web UI is reached
+	// through ingress and does not need it.
+	APIKey string `json:"api_key"`
+}
+
+// AddonCamera is a camera with its Home Assistant camera entity, the path of
+// its layout file and its detection profile, "day" or "night". The add-on
+// image has no ffmpeg, so stream URLs are left to the settings file.
+type AddonCamera struct {
+	ID       string   `json:"id"`
+	Entity   string   `json:"entity"`
+	Layout   string   `json:"layout"`
+	Profile  string   `json:"profile"`
+	Interval Duration `json:"interval"`
+	Target   string   `json:"target"`
+}
+
+// AddonTarget is a named Telegram target.
+type AddonTarget struct {
+	Name     string `json:"name"`
+	Token    string `json:"token"`
+	ChatID   int64  `json:"chat_id"`
+	ThreadID int64  `json:"thread_id"`
+	Live     bool   `json:"live"`
+	Commands bool   `json:"commands"`
+}
+
+// AddonMQTT is the MQTT broker, e.g. core-mosquitto for the Mosquitto
+// add-on.
+type AddonMQTT struct {
+	Broker      string `json:"broker"`
+	Port        int    `json:"port"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	TopicPrefix string `json:"topic_prefix"`
+}
+
+// optionsFile returns the path of the add-on options, OPTIONS_FILE if set.
+func optionsFile() string {
+	if path := os.Getenv("OPTIONS_FILE"); path != "" {
+		return path
+	}
+
+	return defaultOptionsFile
+}
+
+// applyOptions adds the add-on options in path to the settings. Outside the
+// add-on there is no such file and nothing changes. Running as an add-on
+// also turns on ingress.
+func (s *Settings) applyOptions(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var o AddonOptions
+	if err := json.Unmarshal(data, &o); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	s.Ingress = true
+
+	// /data is the add-on's persistent storage
+	if s.LiveMessages == "" {
+		s.LiveMessages = "/data/live_messages.json"
+	}
+
+	if o.APIKey != "" {
+		s.APIKeys = append(s.APIKeys, &APIKey{Name: "addon", Key: o.APIKey, Scope: ScopeAdmin})
+	}
+
+	if o.MQTT != nil && o.MQTT.Broker != "" {
+		if s.MQTT.Broker != "" {
+			return errors.New("mqtt: broker is already set in the settings")
+		}
+
+		s.MQTT.Broker = o.MQTT.Broker
+		s.MQTT.Port = o.MQTT.Port
+		s.MQTT.Username = o.MQTT.Username
+		s.MQTT.Password = o.MQTT.Password
+		s.MQTT.TopicPrefix = o.MQTT.TopicPrefix
+	}
+
+	if s.Targets == nil {
+		s.Targets = map[string]*Target{}
+	}
+
+	for _, t := range o.Telegram {
+		if s.Targets[t.Name] != nil {
+			return fmt.Errorf("telegram %s: target already exists", t.Name)
+		}
+
+		s.Targets[t.Name] = &Target{Token: t.Token, ChatID: t.ChatID, ThreadID: t.ThreadID, Live: t.Live, Commands: t.Commands}
+	}
+
+	if s.Cameras == nil {
+		s.Cameras = map[string]*Camera{}
+	}
+
+	for _, c := range o.Cameras {
+		if c.ID == "" || s.Cameras[c.ID] != nil {
+			return fmt.Errorf("camera %q: id is empty or already used", c.ID)
+		}
+
+		if c.Profile != "" && c.Profile != "day" && c.Profile != "night" {
+			return fmt.Errorf("camera %s: unknown profile %q", c.ID, c.Profile)
+		}
+
+		camera := &Camera{
+			Target: c.Target,
+			Night:  c.Profile == "night",
+		}
+
+		if c.Entity != "" {
+			// snapshots are only fetched every interval
+			camera.Source = &SourceSettings{Type: "homeassistant", Entity: c.Entity, Interval: c.Interval}
+		} else {
+			camera.Interval = c.Interval
+		}
+
+		if c.Layout != "" {
+			l, err := readLayout(c.Layout)
+			if err != nil {
+				return fmt.Errorf("camera %s: %w", c.ID, err)
+			}
+
+			if s.Layouts == nil {
+				s.Layouts = map[string]*Layout{}
+			}
+
+			// the camera's layout is named after the camera
+			s.Layouts[c.ID] = l
+			camera.Layout = c.ID
+		}
+
+		s.Cameras[c.ID] = camera
+	}
+
+	return nil
+}
+
+// readLayout reads a layout file, e.g. one in the Home Assistant config
+// folder.
+func readLayout(path string) (*Layout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Layout{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeOptions(t *testing.T, options string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "options.json")
+	if err := os.WriteFile(path, []byte(options), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestApplyOptionsMQTT(t *testing.T) {
+	path := writeOptions(t, `{
+		"cameras": [],
+		"telegram": [],
+		"mqtt": {"broker": "core-mosquitto", "port": 1884, "username": "parking", "password": "s3cret", "topic_prefix": "garage"}
+	}`)
+
+	s := &Settings{}
+	if err := s.applyOptions(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := MQTTSettings{Broker: "core-mosquitto", Port: 1884, Username: "parking", Password: "s3cret", TopicPrefix: "garage"}
+	if s.MQTT.Broker != want.Broker || s.MQTT.Port != want.Port || s.MQTT.Username != want.Username ||
+		s.MQTT.Password != want.Password || s.MQTT.TopicPrefix != want.TopicPrefix {
+		t.Errorf("mqtt settings %+v, want %+v", s.MQTT, want)
+	}
+
+	if err := s.MQTT.init(); err != nil || !s.MQTT.enabled() {
+		t.Errorf("mqtt is not enabled: %v", err)
+	}
+
+	// the settings file already has a broker
+	s = &Settings{MQTT: MQTTSettings{Broker: "mqtt.local"}}
+	if err := s.applyOptions(path); err == nil {
+		t.Error("two brokers were accepted")
+	}
+
+	// the default options leave MQTT off
+	s = &Settings{}
+	if err := s.applyOptions(writeOptions(t, `{"DEBUG": false, "cameras": [], "telegram": [], "mqtt": {}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MQTT.init(); err != nil || s.MQTT.enabled() {
+		t.Errorf("mqtt without a broker: %+v, %v", s.MQTT, err)
+	}
+}
+
+func TestApplyOptionsCameras(t *testing.T) {
+	s := &Settings{}
+	if err := s.applyOptions(writeOptions(t, `{
+		"cameras": [
+			{"id": "yard", "entity": "camera.yard", "profile": "night", "interval": "1m", "target": "home"},
+			{"id": "gate", "interval": "30s"}
+		]
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	yard := s.Cameras["yard"]
+	if yard == nil || yard.Source == nil || yard.Source.Type != "homeassistant" || yard.Source.Entity != "camera.yard" ||
+		yard.Source.Interval != Duration(time.Minute) || yard.Interval != 0 || !yard.Night || yard.Target != "home" {
+		t.Errorf("yard %+v", yard)
+	}
+
+	// without an entity frames are sent to /process
+	if gate := s.Cameras["gate"]; gate == nil || gate.Source != nil || gate.Interval != Duration(30*time.Second) {
+		t.Errorf("gate %+v", gate)
+	}
+
+	for _, bad := range []string{
+		`{"cameras": [{"id": "yard"}, {"id": "yard"}]}`,
+		`{"cameras": [{"id": ""}]}`,
+		`{"cameras": [{"id": "yard", "profile": "dusk"}]}`,
+	} {
+		if err := (&Settings{}).applyOptions(writeOptions(t, bad)); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package poly
+
+import (
+	"math"
+	"sort"
+)
+
+// Span is a horizontal run of pixels [X0, X1) on row Y.
+type Span struct {
+	Y, X0, X1 int
+}
+
+// Mask is the set of pixels covered by a polygon, stored as row spans.
+type Mask []Span
+
+// Area returns the number of pixels in the mask.
+func (m Mask) Area() int {
+	area := 0
+	for _, s := range m {
+		area += s.X1 - s.X0
+	}
+
+	return area
+}
+
+// Rasterize returns the pixels of an image scaled by scale whose unscaled
+// coordinates are inside pg. Pixel (x, y) is included exactly when
+// XY{x / scale, y / scale}.In(pg) is true, but the polygon is only walked
+// once per row instead of once per pixel.
+func (pg *Poly) Rasterize(scale float64) Mask {
+	if len(pg.XY) < 3 {
+		return nil
+	}
+
+	min, max := pg.MinMax()
+
+	var (
+		mask  Mask
+		xs    []float64
+		y0    = int(math.Floor(min.Y * scale))
+		y1    = int(math.Ceil(max.Y * scale))
+		count = len(pg.XY)
+	)
+
+	for y := y0; y <= y1; y++ {
+		py := float64(y) / scale
+
+		// x coordinates where the ray from (-inf, py) crosses an edge,
+		// using the same half-open rule as rayIntersectsSegment
+		xs = xs[:0]
+		for i := 0; i < count; i++ {
+			a := pg.XY[i]
+			b := pg.XY[(i+1)%count]
+			if (a.Y > py) != (b.Y > py) {
+				xs = append(xs, (b.X-a.X)*(py-a.Y)/(b.Y-a.Y)+a.X)
+			}
+		}
+
+		sort.Float64s(xs)
+
+		// a point is inside when an odd number of crossings lie strictly
+		// to its right, i.e. xs[2k] <= px < xs[2k+1]
+		for i := 0; i+1 < len(xs); i += 2 {
+			x0 := int(math.Ceil(xs[i] * scale))
+			x1 := int(math.Ceil(xs[i+1] * scale))
+			if x1 > x0 {
+				mask = append(mask, Span{Y: y, X0: x0, X1: x1})
+			}
+		}
+	}
+
+	return mask
+}
@@ -0,0 +1,116 @@
+package poly
+
+import (
+	"math"
+	"testing"
+)
+
+var testPolys = map[string]Poly{
+	"convex": {XY: []XY{{791, 538}, {833, 455}, {873, 472}, {832, 554}}},
+	"concave": {XY: []XY{
+		{10, 10}, {60, 10}, {60, 20}, {25, 20}, {25, 50}, {60, 50}, {60, 60}, {10, 60},
+	}},
+	"star": {XY: []XY{
+		{50, 0}, {61, 35}, {98, 35}, {68, 57}, {79, 91}, {50, 70}, {21, 91}, {32, 57}, {2, 35}, {39, 35},
+	}},
+	// every vertex and every axis aligned edge lies on pixel centers
+	"on pixels": {XY: []XY{{4, 4}, {20, 4}, {20, 12}, {12, 12}, {12, 20}, {4, 20}}},
+	"touching":  {XY: []XY{{0, 0}, {16, 0}, {8, 8}, {16, 16}, {0, 16}, {8, 8}}},
+	"bowtie":    {XY: []XY{{0, 0}, {30, 30}, {30, 0}, {0, 30}}},
+	"sliver":    {XY: []XY{{0, 0}, {40, 1}, {0, 1.5}}},
+}
+
+// maskSet returns the pixels of m, failing on overlapping spans.
+func maskSet(t *testing.T, m Mask) map[[2]int]bool {
+	t.Helper()
+
+	set := map[[2]int]bool{}
+	for _, s := range m {
+		for x := s.X0; x < s.X1; x++ {
+			p := [2]int{x, s.Y}
+			if set[p] {
+				t.Fatalf("pixel %v is in two spans", p)
+			}
+			set[p] = true
+		}
+	}
+
+	return set
+}
+
+func TestRasterizeMatchesIn(t *testing.T) {
+	for name, pg := range testPolys {
+		for _, scale := range []float64{1, 0.5, 0.37, 2} {
+			mask := pg.Rasterize(scale)
+			set := maskSet(t, mask)
+
+			min, max := pg.MinMax()
+			x0, y0 := int(math.Floor(min.X*scale))-2, int(math.Floor(min.Y*scale))-2
+			x1, y1 := int(math.Ceil(max.X*scale))+2, int(math.Ceil(max.Y*scale))+2
+
+			in := 0
+			for y := y0; y <= y1; y++ {
+				for x := x0; x <= x1; x++ {
+					want := XY{X: float64(x) / scale, Y: float64(y) / scale}.In(pg)
+					if want {
+						in++
+					}
+
+					if set[[2]int{x, y}] != want {
+						t.Errorf("%s at scale %g: pixel (%d, %d) in mask = %v, XY.In = %v", name, scale, x, y, !want, want)
+					}
+				}
+			}
+
+			if mask.Area() != in || len(set) != in {
+				t.Errorf("%s at scale %g: area %d, want %d", name, scale, mask.Area(), in)
+			}
+
+			if in == 0 && name != "sliver" {
+				t.Errorf("%s at scale %g: empty mask", name, scale)
+			}
+		}
+	}
+}
+
+func TestRasterizeDegenerate(t *testing.T) {
+	if m := (&Poly{XY: []XY{{0, 0}, {10, 10}}}).Rasterize(1); m != nil {
+		t.Errorf("a line rasterized to %v", m)
+	}
+}
+
+// inScan is the per-pixel scan Rasterize replaced.
+func inScan(pg Poly, scale float64) int {
+	min, max := pg.MinMax()
+
+	n := 0
+	for y := int(min.Y * scale); y <= int(max.Y*scale); y++ {
+		for x := int(min.X * scale); x <= int(max.X*scale); x++ {
+			if (XY{X: float64(x) / scale, Y: float64(y) / scale}).In(pg) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+func BenchmarkRasterize(b *testing.B) {
+	for name, pg := range testPolys {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				pg.Rasterize(2)
+			}
+		})
+	}
+}
+
+func BenchmarkInScan(b *testing.B) {
+	for name, pg := range testPolys {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				inScan(pg, 2)
+			}
+		})
+	}
+}
@@ -16,16 +16,7 @@ type XY struct {
 // segment.  That is, the last point does not need to repeat the first to
 // close the polygon.
 type Poly struct {
-	XY            []XY
-	Zero, NonZero int
-}
-
-func (pt *Poly) IncZero() {
-	pt.Zero++
-}
-
-func (pt *Poly) IncNonZero() {
-	pt.NonZero++
+	XY []XY
 }
 
 // In returns true if pt is inside pg.
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/ad/go-parking/poly"
+	"github.com/ernyoke/imger/blur"
+	"github.com/ernyoke/imger/effects"
+	"github.com/ernyoke/imger/padding"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Privacy modes.
+const (
+	PrivacyBlur     = "blur"
+	PrivacyPixelate = "pixelate"
+)
+
+// Privacy hides parts of the frame, e.g. house windows or a sidewalk, in
+// every image that leaves the server. Detection always sees the original
+// frame.
+type Privacy struct {
+	Mode string `json:"mode"`
+
+	// Strength is the blur radius or the pixel size, in pixels.
+	Strength float64 `json:"strength"`
+
+	Regions []*PrivacyRegion `json:"regions"`
+
+	// Occupied also hides every spot not known to be free, and with them
+	// license plates.
+	Occupied bool `json:"occupied"`
+
+	masks []poly.Mask
+}
+
+// PrivacyRegion is a polygon to hide, written like the spot polygons.
+type PrivacyRegion struct {
+	Name string     `json:"name,omitempty"`
+	Poly *poly.Poly `json:"-"`
+}
+
+type privacyRegionJSON struct {
+	Name   string       `json:"name,omitempty"`
+	Points [][2]float64 `json:"points"`
+}
+
+func (r *PrivacyRegion) UnmarshalJSON(b []byte) error {
+	var v privacyRegionJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	r.Name = v.Name
+	r.Poly = &poly.Poly{}
+	for _, p := range v.Points {
+		r.Poly.XY = append(r.Poly.XY, poly.XY{X: p[0], Y: p[1]})
+	}
+
+	return nil
+}
+
+func (r *PrivacyRegion) MarshalJSON() ([]byte, error) {
+	v := privacyRegionJSON{Name: r.Name}
+	for _, p := range r.Poly.XY {
+		v.Points = append(v.Points, [2]float64{p.X, p.Y})
+	}
+
+	return json.Marshal(v)
+}
+
+func (p *Privacy) init() error {
+	switch p.Mode {
+	case "":
+		p.Mode = PrivacyBlur
+	case PrivacyBlur, PrivacyPixelate:
+	default:
+		return fmt.Errorf("unknown privacy mode %q", p.Mode)
+	}
+
+	if p.Strength < 0 {
+		return fmt.Errorf("privacy strength must not be negative")
+	}
+
+	if p.Strength == 0 {
+		p.Strength = 16
+	}
+
+	if p.Mode == PrivacyPixelate && p.Strength < 2 {
+		return fmt.Errorf("privacy strength must be at least 2 pixels to pixelate")
+	}
+
+	p.masks = nil
+	for i, r := range p.Regions {
+		if len(r.Poly.XY) < 3 {
+			return fmt.Errorf("privacy region %d needs at least 3 points", i+1)
+		}
+
+		p.masks = append(p.masks, r.Poly.Rasterize(1))
+	}
+
+	return nil
+}
+
+// hidePrivate obscures the privacy regions of layout in img, a full size
+// copy of the frame analyzed in a. Spots are only left visible when known to
+// be free: unknown and low confidence spots may hold a car too. Without an
+// analysis, e.g. for heatmaps, all spots are hidden.
+func (l *Layout) hidePrivate(img *image.RGBA, a *Analysis) {
+	p := l.Privacy
+	if p == nil {
+		return
+	}
+
+	for _, mask := range p.masks {
+		p.obscure(img, mask)
+	}
+
+	if !p.Occupied {
+		return
+	}
+
+	for i, mask := range l.Masks(1) {
+		if a == nil || a.Spots[i].Status != StatusFree || a.Spots[i].LowConfidence {
+			p.obscure(img, mask)
+		}
+	}
+}
+
+// obscure blurs or pixelates the pixels of mask in img. Should that fail,
+// the pixels are painted gray instead, so nothing is sent unmasked.
+func (p *Privacy) obscure(img *image.RGBA, mask poly.Mask) {
+	r := maskBounds(mask)
+	if p.Mode == PrivacyBlur {
+		// blur with the surroundings instead of the image border
+		r = r.Inset(-int(p.Strength))
+	}
+
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	size := r.Size()
+	if p.Mode == PrivacyPixelate {
+		// whole blocks plus one, as the pixelation truncates odd sizes
+		n := int(p.Strength)
+		size = image.Pt((size.X/n+2)*n, (size.Y/n+2)*n)
+	}
+
+	src := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(src, src.Bounds(), img, r.Min, draw.Src)
+
+	out, err := p.filter(src)
+	if err != nil || out.Bounds().Dx() < r.Dx() || out.Bounds().Dy() < r.Dy() {
+		out = image.NewRGBA(src.Bounds())
+		draw.Draw(out, out.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	}
+
+	for _, s := range mask {
+		if s.Y < r.Min.Y || s.Y >= r.Max.Y {
+			continue
+		}
+
+		x0, x1 := max(s.X0, r.Min.X), min(s.X1, r.Max.X)
+		if x0 >= x1 {
+			continue
+		}
+
+		copy(img.Pix[img.PixOffset(x0, s.Y):img.PixOffset(x1, s.Y)], out.Pix[out.PixOffset(x0-r.Min.X, s.Y-r.Min.Y):])
+	}
+}
+
+// filter returns the blurred or pixelated src. The blur runs on a copy
+// shrunk to a quarter of the strength, which keeps big regions cheap and
+// smooths like a much wider kernel once scaled back.
+func (p *Privacy) filter(src *image.RGBA) (*image.RGBA, error) {
+	if p.Mode == PrivacyPixelate {
+		return effects.PixelateRGBA(src, float64(int(p.Strength)))
+	}
+
+	scale := max(p.Strength/4, 1)
+	b := src.Bounds()
+
+	small := image.NewRGBA(image.Rect(0, 0, max(int(float64(b.Dx())/scale), 1), max(int(float64(b.Dy())/scale), 1)))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), src, b, xdraw.Src, nil)
+
+	var err error
+	for range 2 {
+		if small, err = blur.BoxRGBA(small, image.Pt(5, 5), image.Pt(2, 2), padding.BorderReplicate); err != nil {
+			return nil, err
+		}
+	}
+
+	out := image.NewRGBA(b)
+	xdraw.BiLinear.Scale(out, b, small, small.Bounds(), xdraw.Src, nil)
+
+	return out, nil
+}
+
+func maskBounds(mask poly.Mask) image.Rectangle {
+	var r image.Rectangle
+	for _, s := range mask {
+		r = r.Union(image.Rect(s.X0, s.Y, s.X1, s.Y+1))
+	}
+
+	return r
+}
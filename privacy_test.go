@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"testing"
+
+	"github.com/ad/go-parking/poly"
+)
+
+// privacyLayout has a row of five 40x40 spots, 60 pixels apart, and hides
+// occupied spots with the given mode.
+func privacyLayout(t *testing.T, mode string) *Layout {
+	t.Helper()
+
+	layout := &Layout{Privacy: &Privacy{Mode: mode, Occupied: true}}
+	for i := range 5 {
+		x := float64(20 + 60*i)
+		layout.Spots = append(layout.Spots, &Spot{ID: string(rune('a' + i)), Poly: rect(x, 20, x+40, 60)})
+	}
+
+	if err := layout.Privacy.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return layout
+}
+
+// spotInside is the inside of the i-th spot of privacyLayout, clear of the
+// outline.
+func spotInside(i int) image.Rectangle {
+	return image.Rect(30+60*i, 30, 50+60*i, 50)
+}
+
+func TestHidePrivateOccupied(t *testing.T) {
+	theme := &Theme{Label: []string{}}
+	if err := theme.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	confidence := &ConfidenceSettings{}
+	confidence.init()
+
+	for _, mode := range []string{PrivacyBlur, PrivacyPixelate} {
+		layout := privacyLayout(t, mode)
+
+		a := &Analysis{
+			Frame: blockFrame(320, 80),
+			Spots: []*SpotResult{
+				{ID: "a", Status: StatusFree},
+				{ID: "b", Status: StatusOccupied},
+				{ID: "c", Status: StatusUnknown},
+				{ID: "d", Status: StatusFree, LowConfidence: true},
+				{ID: "e", Status: StatusOutOfService},
+			},
+		}
+		frame := bytes.Clone(a.Frame.Pix)
+
+		a.Image = renderAnalysis(a, layout, theme, confidence, false)
+
+		// detection keeps seeing the original frame
+		if !bytes.Equal(a.Frame.Pix, frame) {
+			t.Errorf("%s: the analyzed frame was changed", mode)
+		}
+
+		// only the spot known to be free stays visible
+		for i, hidden := range []bool{false, true, true, true, true} {
+			inside := spotInside(i)
+
+			n := diffPixels(a.Image, a.Frame, inside)
+			if hidden && n < inside.Dx()*inside.Dy()/2 || !hidden && n != 0 {
+				t.Errorf("%s: %d of %d pixels of spot %s changed", mode, n, inside.Dx()*inside.Dy(), a.Spots[i].ID)
+			}
+		}
+
+		// between the spots nothing is hidden
+		if n := diffPixels(a.Image, a.Frame, image.Rect(0, 66, 320, 80)); n != 0 {
+			t.Errorf("%s: %d pixels below the spots changed", mode, n)
+		}
+	}
+}
+
+func TestHidePrivateRegions(t *testing.T) {
+	var layout Layout
+	if err := json.Unmarshal([]byte(`{
+		"spots": [{"id": "a", "points": [[20, 20], [60, 20], [60, 60], [20, 60]]}],
+		"privacy": {"regions": [{"name": "windows", "points": [[100, 0], [200, 0], [200, 40], [100, 40]]}]}
+	}`), &layout); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := layout.Privacy.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := blockFrame(320, 80)
+
+	img := image.NewRGBA(frame.Bounds())
+	copy(img.Pix, frame.Pix)
+	layout.hidePrivate(img, &Analysis{Spots: []*SpotResult{{ID: "a", Status: StatusOccupied}}})
+
+	// without occupied the spots stay visible
+	if n := diffPixels(img, frame, image.Rect(25, 25, 55, 55)); n != 0 {
+		t.Errorf("%d pixels of the spot changed", n)
+	}
+
+	if n := diffPixels(img, frame, image.Rect(105, 5, 195, 35)); n < 90*30/2 {
+		t.Errorf("%d pixels of the region changed", n)
+	}
+
+	if n := diffPixels(img, frame, image.Rect(0, 60, 320, 80)); n != 0 {
+		t.Errorf("%d pixels outside the region changed", n)
+	}
+}
+
+func TestHidePrivateHeatmap(t *testing.T) {
+	layout := privacyLayout(t, PrivacyPixelate)
+	frame := blockFrame(320, 80)
+
+	// without an analysis every spot is hidden
+	img := image.NewRGBA(frame.Bounds())
+	copy(img.Pix, frame.Pix)
+	layout.hidePrivate(img, nil)
+
+	for i := range layout.Spots {
+		if inside := spotInside(i); diffPixels(img, frame, inside) < inside.Dx()*inside.Dy()/2 {
+			t.Errorf("spot %d is visible", i)
+		}
+	}
+}
+
+func TestPrivacyInit(t *testing.T) {
+	p := &Privacy{}
+	if err := p.init(); err != nil || p.Mode != PrivacyBlur || p.Strength != 16 {
+		t.Errorf("defaults %+v, %v", p, err)
+	}
+
+	for _, bad := range []*Privacy{
+		{Mode: "black"},
+		{Strength: -1},
+		{Mode: PrivacyPixelate, Strength: 1},
+		{Regions: []*PrivacyRegion{{Poly: rect(0, 0, 10, 10)}, {Poly: &poly.Poly{}}}},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// processJob is the queue handler: it analyzes the uploaded frame, checks
+// its quality and sends the annotated image to the job's target.
+func (s *server) processJob(job *Job) error {
+	s.queue.setStage(job, "decode")
+
+	img, exifTime, err := decodeFrame(job.data)
+	if err != nil {
+		return err
+	}
+
+	// the time given by the source wins over EXIF, the upload time is
+	// the last resort
+	frameTime := job.FrameTime
+	if frameTime.IsZero() {
+		frameTime = exifTime
+	}
+
+	if frameTime.IsZero() {
+		frameTime = job.Created
+	}
+
+	layout := s.layoutFor(job.Camera)
+
+	result, err := analyzeFrame(img, layout, job.Day, func(stage string) {
+		s.queue.setStage(job, stage)
+	})
+	if err != nil {
+		return err
+	}
+
+	result.Time = frameTime
+
+	s.queue.setStage(job, "quality")
+
+	state := s.cameras.get(job.Camera)
+	state.setFrame(result.Frame)
+
+	changed, err := state.checkQuality(result, &s.settings.Quality)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		s.publishQuality(job.Camera, result)
+	}
+
+	state.scoreConfidence(result, &s.settings.Confidence)
+	countZones(result, layout, &s.settings.Confidence)
+
+	if violations := state.checkRules(result, layout); len(violations) > 0 {
+		s.publishViolations(job.Camera, result, violations)
+	}
+
+	changedSpots, overstayed := state.trackDwell(result, layout)
+	s.publishDwell(job.Camera, result, changedSpots, overstayed)
+
+	if state.checkFull(result) {
+		s.publishLot(job.Camera, result)
+	}
+
+	if s.history != nil && job.Camera != "" {
+		if err := s.history.Append(job.Camera, result); err != nil {
+			fmt.Printf("could not store history of %s: %s\n", job.Camera, err)
+		}
+	}
+
+	s.queue.setStage(job, "render")
+
+	result.Image = renderAnalysis(result, layout, &s.settings.Theme, &s.settings.Confidence, false)
+
+	state.setLast(result)
+
+	analyzed := Event{
+		Type:    EventFrameAnalyzed,
+		Camera:  job.Camera,
+		Time:    result.Time,
+		Message: fmt.Sprintf("%d of %d spots free", result.Counts.Free, result.Counts.Total),
+		Data:    result,
+	}
+
+	s.bus.Publish(analyzed)
+
+	if s.archive != nil && job.Camera != "" {
+		if err := s.archive.Store(job.Camera, result); err != nil {
+			fmt.Printf("could not archive frame of %s: %s\n", job.Camera, err)
+		}
+	}
+
+	s.queue.mu.Lock()
+	job.Result = result
+	s.queue.mu.Unlock()
+
+	// camera frames may be analyzed without being sent anywhere
+	if job.Target == "" {
+		return nil
+	}
+
+	target, ok := s.settings.Targets[job.Target]
+	if !ok {
+		return fmt.Errorf("unknown target %s", job.Target)
+	}
+
+	s.queue.setStage(job, "send")
+
+	n := &Notification{
+		Event: analyzed,
+		Text:  zoneCaption(result, target.Zones),
+		Image: result.Image,
+	}
+
+	if target.Live && job.MessageID == 0 {
+		if n.Text, err = target.text(n); err != nil {
+			return err
+		}
+
+		// a status message needs a caption even without zones
+		n.Text = plainText(n)
+
+		return s.live.Update(target.notifier.(*telegramNotifier), job.Camera, n, liveState(result, n.Text))
+	}
+
+	if job.MessageID != 0 {
+		tn, ok := target.notifier.(*telegramNotifier)
+		if !ok {
+			return fmt.Errorf("target %s can not update messages", job.Target)
+		}
+
+		if n.Text, err = target.text(n); err != nil {
+			return err
+		}
+
+		return tn.update(n, job.MessageID)
+	}
+
+	return target.notify(n)
+}
+
+// publishLot reports a lot becoming full or having free spots again.
+func (s *server) publishLot(camera string, a *Analysis) {
+	name := camera
+	if name == "" {
+		name = "uploads"
+	}
+
+	if a.Counts.Free == 0 {
+		s.bus.Publish(Event{
+			Type:    EventLotFull,
+			Camera:  camera,
+			Time:    a.Time,
+			Message: fmt.Sprintf("Camera %s: no free spots left", name),
+			Data:    a.Counts,
+		})
+
+		return
+	}
+
+	s.bus.Publish(Event{
+		Type:    EventLotAvailable,
+		Camera:  camera,
+		Time:    a.Time,
+		Message: fmt.Sprintf("Camera %s: %d of %d spots free again", name, a.Counts.Free, a.Counts.Total),
+		Data:    a.Counts,
+	})
+}
+
+// publishQuality reports a camera becoming unreliable or recovering.
+func (s *server) publishQuality(camera string, a *Analysis) {
+	name := camera
+	if name == "" {
+		name = "uploads"
+	}
+
+	if a.Quality.Reliable {
+		s.bus.Publish(Event{
+			Type:    EventCameraRecovered,
+			Camera:  camera,
+			Time:    a.Time,
+			Message: fmt.Sprintf("Camera %s: image quality is back to normal", name),
+			Data:    a.Quality,
+		})
+
+		return
+	}
+
+	s.bus.Publish(Event{
+		Type:    EventCameraProblem,
+		Camera:  camera,
+		Time:    a.Time,
+		Message: fmt.Sprintf("Camera %s: results are unreliable (%s)", name, strings.Join(a.Quality.Issues, ", ")),
+		Data:    a.Quality,
+	})
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/ernyoke/imger/edgedetection"
+	"github.com/ernyoke/imger/padding"
+)
+
+// thumbScale is the size of the thumbnail used for image quality checks
+// relative to the full frame.
+const thumbScale = 0.25
+
+// Image quality issues.
+const (
+	IssueBlurred      = "blurred"
+	IssueUnderexposed = "underexposed"
+	IssueOverexposed  = "overexposed"
+	IssueViewChanged  = "view changed"
+	IssueFrozen       = "frozen"
+)
+
+// Histogram levels below/above which a pixel counts as clipped.
+const (
+	darkLevel   = 16
+	brightLevel = 240
+)
+
+// referenceWeight is how fast the reference image follows reliable frames.
+const referenceWeight = 0.1
+
+// QualitySettings are the thresholds of the per-frame quality checks.
+type QualitySettings struct {
+	Disabled bool `json:"disabled"`
+
+	// MinSharpness is the minimal variance of the Laplacian; covered or
+	// fogged lenses give an almost flat image.
+	MinSharpness float64 `json:"min_sharpness"`
+
+	// MaxDark and MaxBright are the largest allowed shares of clipped
+	// pixels in the histogram.
+	MaxDark   float64 `json:"max_dark"`
+	MaxBright float64 `json:"max_bright"`
+
+	// MaxChange is the largest mean absolute difference, 0-255, to the
+	// camera's reference image before the view is considered moved or
+	// covered. The mean brightness of both images is ignored.
+	MaxChange float64 `json:"max_change"`
+
+	// FrozenFrames is the number of identical consecutive frames after
+	// which the stream is considered stuck.
+	FrozenFrames int `json:"frozen_frames"`
+}
+
+func (qs *QualitySettings) init() {
+	if qs.MinSharpness <= 0 {
+		qs.MinSharpness = 15
+	}
+
+	if qs.MaxDark <= 0 {
+		qs.MaxDark = 0.7
+	}
+
+	if qs.MaxBright <= 0 {
+		qs.MaxBright = 0.5
+	}
+
+	if qs.MaxChange <= 0 {
+		qs.MaxChange = 40
+	}
+
+	if qs.FrozenFrames <= 0 {
+		qs.FrozenFrames = 3
+	}
+}
+
+// Quality is the outcome of the image quality checks of one frame.
+type Quality struct {
+	Reliable  bool     `json:"reliable"`
+	Issues    []string `json:"issues,omitempty"`
+	Sharpness float64  `json:"sharpness"`
+	Dark      float64  `json:"dark"`
+	Bright    float64  `json:"bright"`
+	Change    float64  `json:"change"`
+	Identical int      `json:"identical"`
+}
+
+// cameraState is what the service remembers about a camera between frames.
+type cameraState struct {
+	mu sync.Mutex
+
+	reference *image.Gray
+	previous  *image.Gray
+	identical int
+	reliable  bool
+
+	// recent holds spot statuses of the last reliable frames
+	recent [][]string
+
+	// violations holds the spots currently breaking their rule
+	violations map[string]bool
+
+	// tracks follow the occupancy of every spot
+	tracks map[string]*spotTrack
+
+	// frame is the last analyzed frame, the background of heatmaps
+	frame *image.RGBA
+
+	// last is the last complete analysis, sent to new stream clients
+	last *Analysis
+
+	// full is set while no spot of the lot is free
+	full bool
+}
+
+// cameraStates holds the state of every camera that sent frames.
+type cameraStates struct {
+	mu     sync.Mutex
+	states map[string]*cameraState
+}
+
+// ids returns the cameras that sent frames, sorted.
+func (cs *cameraStates) ids() []string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	ids := slices.Collect(maps.Keys(cs.states))
+	slices.Sort(ids)
+
+	return ids
+}
+
+func (cs *cameraStates) get(camera string) *cameraState {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.states == nil {
+		cs.states = map[string]*cameraState{}
+	}
+
+	state, ok := cs.states[camera]
+	if !ok {
+		state = &cameraState{reliable: true}
+		cs.states[camera] = state
+	}
+
+	return state
+}
+
+// checkQuality runs the image quality checks of a against the camera state.
+// Spots of unreliable frames are marked unknown instead of reporting made-up
+// occupancy. It returns whether the reliability of the camera has changed.
+func (state *cameraState) checkQuality(a *Analysis, settings *QualitySettings) (changed bool, err error) {
+	q := &Quality{Reliable: true}
+	a.Quality = q
+
+	if settings.Disabled {
+		return false, nil
+	}
+
+	laplacian, err := edgedetection.LaplacianGray(a.thumb, padding.BorderReflect, edgedetection.K8)
+	if err != nil {
+		return false, fmt.Errorf("could not compute laplacian: %w", err)
+	}
+
+	_, q.Sharpness = meanVariance(laplacian.Pix)
+	q.Dark, q.Bright = clipped(a.thumb.Pix)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.reference != nil && state.reference.Bounds() == a.thumb.Bounds() {
+		q.Change = structuralChange(state.reference.Pix, a.thumb.Pix)
+	}
+
+	// compressed frames of a stuck stream are not byte identical, but
+	// differ by less than one gray level on average
+	if state.previous != nil && state.previous.Bounds() == a.thumb.Bounds() && meanAbsDiff(state.previous.Pix, a.thumb.Pix) < 0.5 {
+		state.identical++
+	} else {
+		state.identical = 0
+	}
+	state.previous = a.thumb
+	q.Identical = state.identical
+
+	if q.Sharpness < settings.MinSharpness {
+		q.Issues = append(q.Issues, IssueBlurred)
+	}
+
+	if q.Dark > settings.MaxDark {
+		q.Issues = append(q.Issues, IssueUnderexposed)
+	}
+
+	if q.Bright > settings.MaxBright {
+		q.Issues = append(q.Issues, IssueOverexposed)
+	}
+
+	if q.Change > settings.MaxChange {
+		q.Issues = append(q.Issues, IssueViewChanged)
+	}
+
+	if state.identical >= settings.FrozenFrames {
+		q.Issues = append(q.Issues, IssueFrozen)
+	}
+
+	q.Reliable = len(q.Issues) == 0
+
+	if q.Reliable {
+		state.updateReference(a.thumb)
+	} else {
+		for _, spot := range a.Spots {
+			if spot.Status != StatusOutOfService {
+				spot.Status = StatusUnknown
+			}
+		}
+	}
+
+	changed = state.reliable != q.Reliable
+	state.reliable = q.Reliable
+
+	return changed, nil
+}
+
+func (state *cameraState) setFrame(frame *image.RGBA) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.frame = frame
+}
+
+func (state *cameraState) setLast(a *Analysis) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.last = a
+}
+
+func (state *cameraState) lastAnalysis() *Analysis {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.last
+}
+
+func (state *cameraState) lastFrame() *image.RGBA {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return state.frame
+}
+
+// reset forgets the reference image, e.g. after the camera was moved on
+// purpose. The next reliable frame becomes the new reference.
+func (state *cameraState) reset() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.reference = nil
+	state.previous = nil
+	state.identical = 0
+	state.recent = nil
+	state.violations = nil
+}
+
+// updateReference blends a reliable frame into the reference image, so slow
+// changes like daylight and parked cars are followed while a sudden change
+// of the whole view is detected.
+func (state *cameraState) updateReference(thumb *image.Gray) {
+	if state.reference == nil || state.reference.Bounds() != thumb.Bounds() {
+		state.reference = image.NewGray(thumb.Bounds())
+		copy(state.reference.Pix, thumb.Pix)
+
+		return
+	}
+
+	for i, v := range thumb.Pix {
+		r := float64(state.reference.Pix[i])
+		state.reference.Pix[i] = uint8(r + (float64(v)-r)*referenceWeight + 0.5)
+	}
+}
+
+func meanVariance(pix []uint8) (mean, variance float64) {
+	if len(pix) == 0 {
+		return 0, 0
+	}
+
+	var sum, sumSq float64
+	for _, v := range pix {
+		sum += float64(v)
+		sumSq += float64(v) * float64(v)
+	}
+
+	n := float64(len(pix))
+	mean = sum / n
+
+	return mean, sumSq/n - mean*mean
+}
+
+// clipped returns the shares of under- and overexposed pixels.
+func clipped(pix []uint8) (dark, bright float64) {
+	if len(pix) == 0 {
+		return 0, 0
+	}
+
+	var histogram [256]int
+	for _, v := range pix {
+		histogram[v]++
+	}
+
+	for v := 0; v < darkLevel; v++ {
+		dark += float64(histogram[v])
+	}
+
+	for v := brightLevel + 1; v < 256; v++ {
+		bright += float64(histogram[v])
+	}
+
+	n := float64(len(pix))
+
+	return dark / n, bright / n
+}
+
+// structuralChange is the mean absolute difference of a and b after removing
+// their mean brightness, so exposure changes alone don't count as a change
+// of the view.
+func structuralChange(a, b []uint8) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	meanA, _ := meanVariance(a)
+	meanB, _ := meanVariance(b)
+
+	var sum float64
+	for i := range a {
+		sum += math.Abs((float64(a[i]) - meanA) - (float64(b[i]) - meanB))
+	}
+
+	return sum / float64(len(a))
+}
+
+func meanAbsDiff(a, b []uint8) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var sum int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+
+	return float64(sum) / float64(len(a))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"image"
+	"slices"
+	"testing"
+)
+
+// checker returns a thumbnail of 4 pixel squares alternating between lo
+// and hi, inverted when flip is set.
+func checker(lo, hi uint8, flip bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := range 48 {
+		for x := range 64 {
+			v := lo
+			if (x/4+y/4)%2 == 0 != flip {
+				v = hi
+			}
+			img.Pix[y*img.Stride+x] = v
+		}
+	}
+
+	return img
+}
+
+func flat(v uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+
+	return img
+}
+
+func TestCheckQuality(t *testing.T) {
+	sharp := func() *image.Gray { return checker(60, 190, false) }
+
+	tests := []struct {
+		name   string
+		frames []*image.Gray
+		issues []string
+	}{
+		{"sharp", []*image.Gray{sharp()}, nil},
+		{"blurred", []*image.Gray{flat(128)}, []string{IssueBlurred}},
+		{"underexposed", []*image.Gray{checker(0, 12, false)}, []string{IssueUnderexposed}},
+		{"overexposed", []*image.Gray{checker(245, 255, false)}, []string{IssueOverexposed}},
+		{"view changed", []*image.Gray{sharp(), checker(60, 190, true)}, []string{IssueViewChanged}},
+		// a brighter frame of the same view is no change
+		{"brighter", []*image.Gray{sharp(), checker(90, 220, false)}, nil},
+		{"almost frozen", []*image.Gray{sharp(), sharp(), sharp()}, nil},
+		{"frozen", []*image.Gray{sharp(), sharp(), sharp(), sharp()}, []string{IssueFrozen}},
+		{"moving again", []*image.Gray{sharp(), sharp(), sharp(), checker(70, 200, false)}, nil},
+	}
+
+	settings := &QualitySettings{}
+	settings.init()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &cameraState{reliable: true}
+
+			var a *Analysis
+			for _, thumb := range tt.frames {
+				a = &Analysis{thumb: thumb, Spots: []*SpotResult{{Status: StatusOccupied}, {Status: StatusOutOfService}}}
+				if _, err := state.checkQuality(a, settings); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			q := a.Quality
+			for _, issue := range tt.issues {
+				if !slices.Contains(q.Issues, issue) {
+					t.Errorf("issues %v, want %s (%+v)", q.Issues, issue, q)
+				}
+			}
+
+			if tt.issues == nil && len(q.Issues) != 0 {
+				t.Errorf("issues %v on a good frame (%+v)", q.Issues, q)
+			}
+
+			if q.Reliable != (len(tt.issues) == 0) {
+				t.Errorf("reliable %v with issues %v", q.Reliable, q.Issues)
+			}
+
+			// spots of an unreliable frame are unknown, not made up
+			want := StatusOccupied
+			if !q.Reliable {
+				want = StatusUnknown
+			}
+
+			if a.Spots[0].Status != want || a.Spots[1].Status != StatusOutOfService {
+				t.Errorf("spots %s, %s", a.Spots[0].Status, a.Spots[1].Status)
+			}
+		})
+	}
+}
+
+func TestCheckQualityReliabilityChange(t *testing.T) {
+	settings := &QualitySettings{}
+	settings.init()
+
+	state := &cameraState{reliable: true}
+
+	// only the transitions are reported
+	for i, tt := range []struct {
+		thumb   *image.Gray
+		changed bool
+	}{
+		{checker(60, 190, false), false},
+		{flat(128), true},
+		{flat(0), false},
+		{checker(60, 190, false), true},
+		{checker(61, 191, true), true},
+		{checker(60, 190, false), true},
+	} {
+		changed, err := state.checkQuality(&Analysis{thumb: tt.thumb}, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if changed != tt.changed {
+			t.Errorf("frame %d: changed %v, want %v", i, changed, tt.changed)
+		}
+	}
+
+	// a view change stays reported until the reference is reset
+	a := &Analysis{thumb: checker(60, 190, true)}
+	if _, err := state.checkQuality(a, settings); err != nil || a.Quality.Reliable {
+		t.Fatalf("before reset: %+v, %v", a.Quality, err)
+	}
+
+	state.reset()
+
+	a = &Analysis{thumb: checker(62, 192, true)}
+	if changed, err := state.checkQuality(a, settings); err != nil || !a.Quality.Reliable || !changed {
+		t.Errorf("after reset: %+v, changed %v, %v", a.Quality, changed, err)
+	}
+
+	disabled := &QualitySettings{Disabled: true}
+	a = &Analysis{thumb: flat(0), Spots: []*SpotResult{{Status: StatusFree}}}
+	if changed, err := state.checkQuality(a, disabled); err != nil || changed || !a.Quality.Reliable || a.Spots[0].Status != StatusFree {
+		t.Errorf("disabled: %+v, changed %v, %v", a.Quality, changed, err)
+	}
+}
+
+func TestQualityMeasures(t *testing.T) {
+	if dark, bright := clipped([]uint8{0, 15, 16, 240, 241, 255}); dark != 2.0/6 || bright != 2.0/6 {
+		t.Errorf("clipped %v, %v", dark, bright)
+	}
+
+	if mean, variance := meanVariance([]uint8{2, 4, 4, 4, 5, 5, 7, 9}); mean != 5 || variance != 4 {
+		t.Errorf("mean %v, variance %v", mean, variance)
+	}
+
+	// the same pattern brighter has no structural change
+	if change := structuralChange([]uint8{10, 20, 30}, []uint8{60, 70, 80}); change != 0 {
+		t.Errorf("brighter: %v", change)
+	}
+
+	if change := structuralChange([]uint8{10, 30}, []uint8{30, 10}); change != 20 {
+		t.Errorf("swapped: %v", change)
+	}
+
+	if d := meanAbsDiff([]uint8{0, 10}, []uint8{10, 0}); d != 10 {
+		t.Errorf("mean abs diff %v", d)
+	}
+
+	if structuralChange([]uint8{1}, nil) != 0 || meanAbsDiff(nil, nil) != 0 {
+		t.Error("different sizes compared")
+	}
+}
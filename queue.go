@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of a queued job.
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+	JobDropped JobStatus = "dropped"
+)
+
+// Queue overflow policies.
+const (
+	PolicyReject     = "reject"
+	PolicyDropOldest = "drop-oldest"
+)
+
+var errQueueFull = errors.New("queue is full")
+
+// QueueSettings limits the amount of concurrent and pending work.
+type QueueSettings struct {
+	Workers   int      `json:"workers"`
+	Size      int      `json:"size"`
+	Policy    string   `json:"policy"`
+	Retention Duration `json:"retention"`
+}
+
+// Job is a single frame submitted for processing.
+type Job struct {
+	ID       string    `json:"id"`
+	Status   JobStatus `json:"status"`
+	Stage    string    `json:"stage,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Result   *Analysis `json:"result,omitempty"`
+
+	Camera    string    `json:"camera,omitempty"`
+	FrameTime time.Time `json:"frame_time,omitzero"`
+	Target    string    `json:"target,omitempty"`
+	Day       bool      `json:"day"`
+	MessageID int64     `json:"message_id,omitempty"`
+
+	data []byte
+	done chan struct{}
+}
+
+// Queue runs submitted jobs on a fixed number of workers.
+type Queue struct {
+	settings QueueSettings
+	handler  func(*Job) error
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	jobs    map[string]*Job
+}
+
+func NewQueue(settings QueueSettings, handler func(*Job) error) *Queue {
+	// the image filters already spread a frame over goroutines, a few
+	// workers are enough to keep the CPUs busy
+	if settings.Workers <= 0 {
+		settings.Workers = min(2, runtime.NumCPU())
+	}
+
+	if settings.Size <= 0 {
+		settings.Size = 16
+	}
+
+	if settings.Policy == "" {
+		settings.Policy = PolicyReject
+	}
+
+	if settings.Retention <= 0 {
+		settings.Retention = Duration(15 * time.Minute)
+	}
+
+	q := &Queue{
+		settings: settings,
+		handler:  handler,
+		jobs:     map[string]*Job{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	for i := 0; i < settings.Workers; i++ {
+		go q.worker()
+	}
+
+	go q.cleanup()
+
+	return q
+}
+
+// Submit enqueues job. When the queue is full, the job is either rejected
+// with errQueueFull or the oldest pending job is dropped to make room.
+func (q *Queue) Submit(job *Job) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	job.ID = hex.EncodeToString(id)
+	job.Status = JobQueued
+	job.Created = time.Now()
+	job.done = make(chan struct{})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) >= q.settings.Size {
+		if q.settings.Policy != PolicyDropOldest {
+			return errQueueFull
+		}
+
+		dropped := q.pending[0]
+		q.pending = q.pending[1:]
+		dropped.Status = JobDropped
+		dropped.Finished = time.Now()
+		dropped.data = nil
+		close(dropped.done)
+
+		fmt.Printf("queue is full, dropped job %s\n", dropped.ID)
+	}
+
+	q.jobs[job.ID] = job
+	q.pending = append(q.pending, job)
+	q.cond.Signal()
+
+	return nil
+}
+
+// Get returns a snapshot of the job with the given id.
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+// Len returns the number of pending jobs.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+// setStage records progress of a running job.
+func (q *Queue) setStage(job *Job, stage string) {
+	q.mu.Lock()
+	job.Stage = stage
+	q.mu.Unlock()
+}
+
+func (q *Queue) worker() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		job.Status = JobRunning
+		job.Started = time.Now()
+		q.mu.Unlock()
+
+		err := q.handler(job)
+
+		q.mu.Lock()
+		job.Finished = time.Now()
+		job.Stage = ""
+		job.data = nil
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.Status = JobDone
+		}
+		q.mu.Unlock()
+
+		close(job.done)
+
+		if err != nil {
+			fmt.Printf("job %s failed: %s\n", job.ID, err)
+		} else {
+			fmt.Printf("job %s done in %s\n", job.ID, job.Finished.Sub(job.Started))
+		}
+	}
+}
+
+// cleanup forgets finished jobs once their retention period has passed.
+func (q *Queue) cleanup() {
+	for range time.Tick(time.Minute) {
+		deadline := time.Now().Add(-time.Duration(q.settings.Retention))
+
+		q.mu.Lock()
+		for id, job := range q.jobs {
+			if !job.Finished.IsZero() && job.Finished.Before(deadline) {
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
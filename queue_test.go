@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingQueue returns a queue with one worker whose jobs run until release
+// is closed.
+func blockingQueue(t *testing.T, policy string) (q *Queue, release chan struct{}) {
+	t.Helper()
+
+	release = make(chan struct{})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+
+	q = NewQueue(QueueSettings{Workers: 1, Size: 1, Policy: policy}, func(*Job) error {
+		<-release
+
+		return nil
+	})
+
+	return q, release
+}
+
+// waitStatus polls until the job has the wanted status.
+func waitStatus(t *testing.T, q *Queue, id string, want JobStatus) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := q.Get(id)
+		if ok && job.Status == want {
+			return job
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s: status %q, want %q", id, job.Status, want)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueReject(t *testing.T) {
+	q, release := blockingQueue(t, PolicyReject)
+
+	running, pending := &Job{}, &Job{}
+	if err := q.Submit(running); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, running.ID, JobRunning)
+
+	if err := q.Submit(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Submit(&Job{}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("submit to a full queue: %v, want %v", err, errQueueFull)
+	}
+
+	if q.Len() != 1 {
+		t.Errorf("pending = %d, want 1", q.Len())
+	}
+
+	close(release)
+
+	waitStatus(t, q, running.ID, JobDone)
+	waitStatus(t, q, pending.ID, JobDone)
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q, release := blockingQueue(t, PolicyDropOldest)
+
+	running, oldest, newest := &Job{}, &Job{}, &Job{}
+	if err := q.Submit(running); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, running.ID, JobRunning)
+
+	if err := q.Submit(oldest); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Submit(newest); err != nil {
+		t.Fatalf("submit to a full drop-oldest queue: %v", err)
+	}
+
+	select {
+	case <-oldest.done:
+	default:
+		t.Fatal("the dropped job is not done")
+	}
+
+	if job := waitStatus(t, q, oldest.ID, JobDropped); job.Finished.IsZero() {
+		t.Error("the dropped job has no finish time")
+	}
+
+	close(release)
+
+	waitStatus(t, q, newest.ID, JobDone)
+}
+
+func TestQueueFailedJob(t *testing.T) {
+	q := NewQueue(QueueSettings{Workers: 1}, func(*Job) error { return errors.New("broken frame") })
+
+	job := &Job{}
+	if err := q.Submit(job); err != nil {
+		t.Fatal(err)
+	}
+
+	<-job.done
+
+	if got := waitStatus(t, q, job.ID, JobFailed); got.Error != "broken frame" {
+		t.Errorf("error = %q, want %q", got.Error, "broken frame")
+	}
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func TestJobEndpoints(t *testing.T) {
+	q, release := blockingQueue(t, PolicyReject)
+
+	s := &server{
+		settings: &Settings{Targets: map[string]*Target{"home": {}}},
+		queue:    q,
+	}
+
+	upload := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/process?target=home", bytes.NewReader(testPNG(t)))
+		r.Header.Set("Content-Type", "image/png")
+
+		w := httptest.NewRecorder()
+		s.processImage(w, r)
+
+		return w
+	}
+
+	w := upload()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("upload: status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+
+	var queued struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	if err := json.NewDecoder(w.Body).Decode(&queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if queued.Status != string(JobQueued) || w.Header().Get("Location") != "/jobs/"+queued.ID {
+		t.Errorf("upload: status %q, location %q", queued.Status, w.Header().Get("Location"))
+	}
+
+	waitStatus(t, q, queued.ID, JobRunning)
+
+	// one job runs and one waits, the third does not fit
+	if w := upload(); w.Code != http.StatusAccepted {
+		t.Fatalf("second upload: status = %d", w.Code)
+	}
+
+	w = upload()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("full queue: status = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	getJob := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+		r.SetPathValue("id", id)
+
+		w := httptest.NewRecorder()
+		s.getJob(w, r)
+
+		return w
+	}
+
+	w = getJob(queued.ID)
+
+	var job Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusOK || job.ID != queued.ID || job.Status != JobRunning || job.Target != "home" {
+		t.Errorf("GET /jobs/%s = %d %+v", queued.ID, w.Code, job)
+	}
+
+	if w := getJob("missing"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	close(release)
+	waitStatus(t, q, queued.ID, JobDone)
+
+	if err := json.NewDecoder(getJob(queued.ID).Body).Decode(&job); err != nil || job.Status != JobDone || job.Finished.IsZero() {
+		t.Errorf("finished job = %+v, %v", job, err)
+	}
+}
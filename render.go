@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// renderAnalysis draws the spot results of a over a copy of the frame, or
+// on a transparent canvas of the frame's size for overlay. The privacy
+// regions of layout are hidden before drawing. Low confidence
+// spots are marked with a question mark or left out, depending on the
+// confidence settings.
+func renderAnalysis(a *Analysis, layout *Layout, theme *Theme, confidence *ConfidenceSettings, overlay bool) *image.RGBA {
+	imgRGBA := image.NewRGBA(a.Frame.Bounds())
+	if !overlay {
+		copy(imgRGBA.Pix, a.Frame.Pix)
+		layout.hidePrivate(imgRGBA, a)
+	}
+
+	imgGG := gg.NewContextForRGBA(imgRGBA)
+	imgGG.SetLineWidth(2)
+
+	face := truetype.NewFace(theme.font, &truetype.Options{Size: theme.FontSize})
+	imgGG.SetFontFace(face)
+
+	for i, layoutSpot := range layout.Spots {
+		polygon := layoutSpot.Poly
+		spot := a.Spots[i]
+
+		if spot.LowConfidence && confidence.Mode == LowConfidenceHide && spot.Status != StatusUnknown {
+			continue
+		}
+
+		col := theme.spotColor(spot, layoutSpot.Rule != nil)
+		if col.visible() {
+			if theme.Fill > 0 {
+				DrawFilledPolygon(imgGG, polygon, col.withAlpha(theme.Fill))
+			}
+
+			DrawPolygon(imgGG, polygon, color.RGBAModel.Convert(col.NRGBA).(color.RGBA), theme.LineWidth)
+		}
+
+		if label := theme.spotLabel(spot); label != "" {
+			center := polygon.Center()
+			DrawStrokeText(imgGG, label, center.X, center.Y, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}, 3)
+		}
+	}
+
+	if a.Quality != nil && !a.Quality.Reliable {
+		imgGG.SetFontFace(truetype.NewFace(theme.font, &truetype.Options{Size: theme.FontSize * 14 / 9}))
+
+		text := "UNRELIABLE: " + strings.Join(a.Quality.Issues, ", ")
+		w, h := imgGG.MeasureString(text)
+
+		imgGG.SetColor(color.RGBA{200, 0, 0, 200})
+		imgGG.DrawRectangle(0, 0, w+40, h+30)
+		imgGG.Fill()
+
+		imgGG.SetColor(color.White)
+		imgGG.DrawStringAnchored(text, 20, (h+30)/2, 0, 0.35)
+
+		imgGG.SetFontFace(face)
+	}
+
+	if theme.Timestamp || theme.Banner {
+		drawSummary(imgGG, a, theme)
+	}
+
+	if len(a.Zones) > 0 {
+		drawZones(imgGG, a.Zones)
+	}
+
+	return imgGG.Image().(*image.RGBA)
+}
+
+// spotColor picks the outline color of a spot.
+func (t *Theme) spotColor(spot *SpotResult, reserved bool) HexColor {
+	switch {
+	case spot.Violation != "":
+		return t.Colors.Violation
+	case spot.Status == StatusOutOfService:
+		return t.Colors.OutOfService
+	case spot.Status == StatusUnknown:
+		return t.Colors.Unknown
+	case spot.LowConfidence && spot.Status == StatusFree:
+		return t.Colors.LowConfidence
+	case spot.Status == StatusOccupied:
+		return t.Colors.Occupied
+	case reserved && t.Colors.Reserved.visible():
+		return t.Colors.Reserved
+	}
+
+	return t.Colors.Free
+}
+
+// spotLabel builds the label of a spot from the theme's label parts.
+// Measurements are left out for spots that were not measured.
+func (t *Theme) spotLabel(spot *SpotResult) string {
+	measured := spot.Status == StatusFree || spot.Status == StatusOccupied
+
+	var parts []string
+	for _, part := range t.Label {
+		switch part {
+		case LabelID:
+			parts = append(parts, spot.ID)
+		case LabelName:
+			if spot.Name != "" {
+				parts = append(parts, spot.Name)
+			}
+		case LabelPercentage:
+			if !measured || spot.Percentage == 100 && !spot.LowConfidence {
+				continue
+			}
+
+			text := fmt.Sprintf("%.1f", spot.Percentage)
+			if spot.LowConfidence {
+				text += "?"
+			}
+			parts = append(parts, text)
+		case LabelDwell:
+			if measured && spot.Dwell > 0 {
+				parts = append(parts, formatDwell(time.Duration(spot.Dwell)))
+			}
+		}
+	}
+
+	label := strings.Join(parts, " ")
+	if spot.Violation != "" {
+		if label == "" {
+			label = spot.ID
+		}
+
+		label = "! " + label
+	}
+
+	return label
+}
+
+// formatDwell gives a short dwell time like "45m" or "2h05m".
+func formatDwell(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// drawSummary writes the frame time and the free count in the top right
+// corner.
+func drawSummary(imgGG *gg.Context, a *Analysis, theme *Theme) {
+	var parts []string
+	if theme.Timestamp {
+		parts = append(parts, a.Time.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	if theme.Banner {
+		parts = append(parts, fmt.Sprintf("%d/%d free", a.Counts.Free, a.Counts.Total))
+	}
+
+	text := strings.Join(parts, "   ")
+	w, h := imgGG.MeasureString(text)
+	left := float64(imgGG.Width()) - w - 40
+
+	imgGG.SetColor(color.RGBA{0, 0, 0, 160})
+	imgGG.DrawRectangle(left, 0, w+40, h+30)
+	imgGG.Fill()
+
+	imgGG.SetColor(color.White)
+	imgGG.DrawStringAnchored(text, left+20, (h+30)/2, 0, 0.35)
+}
+
+// drawZones lists the zone counts in the bottom left corner.
+func drawZones(imgGG *gg.Context, zones []*ZoneCount) {
+	lines := make([]string, len(zones))
+	var w, h float64
+	for i, zc := range zones {
+		lines[i] = zc.String()
+
+		lw, lh := imgGG.MeasureString(lines[i])
+		w = max(w, lw)
+		h = max(h, lh)
+	}
+
+	lineHeight := h * 1.6
+	boxHeight := lineHeight*float64(len(lines)) + 20
+	top := float64(imgGG.Height()) - boxHeight
+
+	imgGG.SetColor(color.RGBA{0, 0, 0, 160})
+	imgGG.DrawRectangle(0, top, w+40, boxHeight)
+	imgGG.Fill()
+
+	imgGG.SetColor(color.White)
+	for i, line := range lines {
+		imgGG.DrawStringAnchored(line, 20, top+10+lineHeight*(float64(i)+0.5), 0, 0.35)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestSpotLabel(t *testing.T) {
+	tests := []struct {
+		label []string
+		spot  *SpotResult
+		want  string
+	}{
+		{[]string{LabelPercentage}, &SpotResult{Status: StatusFree, Percentage: 97.45}, "97.5"},
+		// a spot without any edges has no percentage, unless in doubt
+		{[]string{LabelPercentage}, &SpotResult{Status: StatusFree, Percentage: 100}, ""},
+		{[]string{LabelPercentage}, &SpotResult{Status: StatusFree, Percentage: 100, LowConfidence: true}, "100.0?"},
+		{[]string{LabelPercentage}, &SpotResult{Status: StatusUnknown, Percentage: 50}, ""},
+		{[]string{LabelID, LabelName, LabelPercentage, LabelDwell}, &SpotResult{ID: "3", Name: "Гость", Status: StatusOccupied, Percentage: 60, Dwell: Duration(2*time.Hour + 5*time.Minute)}, "3 Гость 60.0 2h05m"},
+		{[]string{LabelName, LabelDwell}, &SpotResult{ID: "3", Status: StatusOutOfService, Dwell: Duration(time.Hour)}, ""},
+		{[]string{LabelID}, &SpotResult{ID: "3", Status: StatusOccupied, Violation: "not allowed"}, "! 3"},
+		// a violation is labeled even without label parts
+		{[]string{}, &SpotResult{ID: "3", Status: StatusOccupied, Violation: "not allowed"}, "! 3"},
+	}
+
+	for _, tt := range tests {
+		theme := &Theme{Label: tt.label}
+		if got := theme.spotLabel(tt.spot); got != tt.want {
+			t.Errorf("%v of %+v: %q, want %q", tt.label, tt.spot, got, tt.want)
+		}
+	}
+
+	for d, want := range map[time.Duration]string{
+		29 * time.Second:                "0m",
+		45 * time.Minute:                "45m",
+		59*time.Minute + 31*time.Second: "1h00m",
+		26*time.Hour + 3*time.Minute:    "26h03m",
+	} {
+		if got := formatDwell(d); got != want {
+			t.Errorf("%s: %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestSpotColor(t *testing.T) {
+	theme := &Theme{}
+	theme.Colors.Reserved.NRGBA = color.NRGBA{0, 0, 255, 255}
+	theme.Colors.Reserved.set = true
+
+	if err := theme.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spot     *SpotResult
+		reserved bool
+		want     HexColor
+	}{
+		{&SpotResult{Status: StatusFree}, false, theme.Colors.Free},
+		{&SpotResult{Status: StatusFree}, true, theme.Colors.Reserved},
+		{&SpotResult{Status: StatusFree, LowConfidence: true}, true, theme.Colors.LowConfidence},
+		{&SpotResult{Status: StatusOccupied}, true, theme.Colors.Occupied},
+		{&SpotResult{Status: StatusOccupied, Violation: "not allowed"}, false, theme.Colors.Violation},
+		{&SpotResult{Status: StatusUnknown}, false, theme.Colors.Unknown},
+		{&SpotResult{Status: StatusOutOfService}, true, theme.Colors.OutOfService},
+	}
+
+	for _, tt := range tests {
+		if got := theme.spotColor(tt.spot, tt.reserved); got != tt.want {
+			t.Errorf("%+v reserved %v: %v, want %v", tt.spot, tt.reserved, got.NRGBA, tt.want.NRGBA)
+		}
+	}
+}
+
+// grayAnalysis is a uniformly gray 480x120 frame with one free spot.
+func grayAnalysis() (*Analysis, *Layout) {
+	frame := image.NewRGBA(image.Rect(0, 0, 480, 120))
+	for i := range frame.Pix {
+		frame.Pix[i] = 128
+		if i%4 == 3 {
+			frame.Pix[i] = 255
+		}
+	}
+
+	a := &Analysis{
+		Frame:  frame,
+		Time:   time.Date(2024, 5, 6, 8, 0, 0, 0, time.Local),
+		Spots:  []*SpotResult{{ID: "1", Status: StatusFree, Percentage: 97.5}},
+		Counts: ZoneCount{Free: 1, Total: 1},
+	}
+
+	return a, &Layout{Spots: []*Spot{{ID: "1", Poly: rect(60, 30, 140, 90)}}}
+}
+
+// diffPixels counts the pixels of r that differ between a and b.
+func diffPixels(a, b *image.RGBA, r image.Rectangle) int {
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+func TestRenderAnalysis(t *testing.T) {
+	confidence := &ConfidenceSettings{}
+	confidence.init()
+
+	render := func(theme *Theme, a *Analysis, layout *Layout, overlay bool) *image.RGBA {
+		if err := theme.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		return renderAnalysis(a, layout, theme, confidence, overlay)
+	}
+
+	a, layout := grayAnalysis()
+	plain := render(&Theme{Label: []string{}}, a, layout, false)
+
+	// the outline is drawn in the free color, the frame is not touched
+	if c := plain.RGBAAt(60, 60); c.G != 255 || c.R != 0 {
+		t.Errorf("outline %v", c)
+	}
+
+	if c := a.Frame.RGBAAt(60, 60); c.G != 128 {
+		t.Errorf("the frame was drawn on: %v", c)
+	}
+
+	if n := diffPixels(plain, a.Frame, image.Rect(80, 45, 120, 75)); n != 0 {
+		t.Errorf("%d pixels inside the spot changed without a label", n)
+	}
+
+	// the label is written at the center of the spot
+	labeled := render(&Theme{}, a, layout, false)
+	if n := diffPixels(labeled, plain, image.Rect(80, 45, 120, 75)); n < 20 {
+		t.Errorf("%d pixels of the label", n)
+	}
+
+	if n := diffPixels(labeled, plain, image.Rect(0, 0, 480, 25)); n != 0 {
+		t.Errorf("%d pixels changed at the top without a banner", n)
+	}
+
+	// the banner darkens the top right corner, and only that
+	banner := render(&Theme{Label: []string{}, Timestamp: true, Banner: true}, a, layout, false)
+	if c := banner.RGBAAt(475, 3); c.R >= 128 {
+		t.Errorf("banner background %v", c)
+	}
+
+	if n := diffPixels(banner, plain, image.Rect(240, 0, 480, 25)); n < 500 {
+		t.Errorf("%d pixels of the banner", n)
+	}
+
+	if n := diffPixels(banner, plain, image.Rect(0, 0, 20, 120)); n != 0 {
+		t.Errorf("%d pixels changed on the left", n)
+	}
+
+	// a longer banner reaches further left
+	timestamp := render(&Theme{Label: []string{}, Timestamp: true}, a, layout, false)
+	if n := diffPixels(banner, timestamp, image.Rect(0, 0, 480, 25)); n == 0 {
+		t.Error("the free count is not in the banner")
+	}
+
+	// an unreliable frame is marked in the top left corner
+	a.Quality = &Quality{Issues: []string{IssueBlurred}}
+	if c := render(&Theme{Label: []string{}}, a, layout, false).RGBAAt(3, 3); c.R < 180 || c.G > 60 {
+		t.Errorf("unreliable banner %v", c)
+	}
+	a.Quality = nil
+
+	// the overlay is transparent around the spots
+	overlay := render(&Theme{}, a, layout, true)
+	if overlay.RGBAAt(10, 10).A != 0 || overlay.RGBAAt(60, 60).A != 255 {
+		t.Errorf("overlay %v, %v", overlay.RGBAAt(10, 10), overlay.RGBAAt(60, 60))
+	}
+
+	// hidden low confidence spots are not drawn at all
+	a.Spots[0].LowConfidence = true
+	confidence.Mode = LowConfidenceHide
+
+	hidden := render(&Theme{}, a, layout, false)
+	if n := diffPixels(hidden, a.Frame, hidden.Bounds()); n != 0 {
+		t.Errorf("%d pixels drawn for a hidden spot", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SpotRule restricts when a spot may be occupied, e.g. a spot rented out
+// during office hours or a loading bay.
+type SpotRule struct {
+	Owner string `json:"owner,omitempty"`
+
+	// Allowed are the windows in which the spot may be occupied. Events of
+	// the iCalendar file count as allowed windows too.
+	Allowed  []*Window `json:"allowed,omitempty"`
+	Calendar string    `json:"calendar,omitempty"`
+
+	// MaxStay overrides the limit of the spot's zones.
+	MaxStay Duration `json:"max_stay,omitzero"`
+
+	events []*icalEvent
+}
+
+func (r *SpotRule) init() error {
+	for i, w := range r.Allowed {
+		if err := w.init(); err != nil {
+			return fmt.Errorf("window %d: %w", i, err)
+		}
+	}
+
+	if r.Calendar != "" {
+		var err error
+		if r.events, err = loadCalendar(r.Calendar); err != nil {
+			return fmt.Errorf("calendar %s: %w", r.Calendar, err)
+		}
+	}
+
+	return nil
+}
+
+// restricted reports whether the rule limits occupancy at all; an owner
+// alone only labels the spot.
+func (r *SpotRule) restricted() bool {
+	return len(r.Allowed) > 0 || r.Calendar != ""
+}
+
+// allows reports whether the spot may be occupied at t.
+func (r *SpotRule) allows(t time.Time) bool {
+	if !r.restricted() {
+		return true
+	}
+
+	for _, w := range r.Allowed {
+		if w.covers(t) {
+			return true
+		}
+	}
+
+	for _, ev := range r.events {
+		if ev.covers(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Window is a daily time range on some weekdays, in local time. A window
+// whose end is before its start runs over midnight.
+type Window struct {
+	Days []string `json:"days,omitempty"`
+	From string   `json:"from"`
+	To   string   `json:"to"`
+
+	days     [7]bool
+	from, to int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (w *Window) init() error {
+	if len(w.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	for _, d := range w.Days {
+		wd, ok := weekdays[strings.ToLower(d)]
+		if !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+
+		w.days[wd] = true
+	}
+
+	var err error
+	if w.from, err = parseClock(w.From); err != nil {
+		return err
+	}
+
+	if w.to, err = parseClock(w.To); err != nil {
+		return err
+	}
+
+	if w.from == w.to {
+		return errors.New("window is empty")
+	}
+
+	return nil
+}
+
+// parseClock returns the minutes since midnight of "15:04"; "24:00" is the
+// end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q, want HH:MM", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *Window) covers(t time.Time) bool {
+	t = t.Local()
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.from < w.to {
+		return w.days[day] && m >= w.from && m < w.to
+	}
+
+	// over midnight: the evening part belongs to the day the window
+	// starts on, the morning part to the previous one
+	return (w.days[day] && m >= w.from) || (w.days[(day+6)%7] && m < w.to)
+}
+
+// checkRules marks occupied spots that break their rule. It returns the
+// spots whose violation started with this frame, so every violation is
+// reported once. Unreliable frames and low confidence spots neither start
+// nor end a violation.
+func (state *cameraState) checkRules(a *Analysis, layout *Layout) []*SpotResult {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if a.Quality != nil && !a.Quality.Reliable {
+		return nil
+	}
+
+	if state.violations == nil {
+		state.violations = map[string]bool{}
+	}
+
+	var started []*SpotResult
+	for i, spot := range a.Spots {
+		rule := layout.Spots[i].Rule
+		if rule == nil || !rule.restricted() || spot.LowConfidence {
+			continue
+		}
+
+		if spot.Status != StatusOccupied || rule.allows(a.Time) {
+			delete(state.violations, spot.ID)
+
+			continue
+		}
+
+		spot.Violation = "occupied outside of permitted hours"
+		if rule.Owner != "" {
+			spot.Violation = fmt.Sprintf("reserved for %s, %s", rule.Owner, spot.Violation)
+		}
+
+		if !state.violations[spot.ID] {
+			state.violations[spot.ID] = true
+			started = append(started, spot)
+		}
+	}
+
+	return started
+}
+
+// publishViolations reports spots that started to break their rule.
+func (s *server) publishViolations(camera string, a *Analysis, spots []*SpotResult) {
+	name := camera
+	if name == "" {
+		name = "uploads"
+	}
+
+	for _, spot := range spots {
+		s.bus.Publish(Event{
+			Type:    EventSpotViolation,
+			Camera:  camera,
+			Time:    a.Time,
+			Message: fmt.Sprintf("Camera %s: spot %s is %s", name, spotLabel(spot), spot.Violation),
+			Data:    spot,
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowCovers(t *testing.T) {
+	// 2024-05-10 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		window  *Window
+		covered []time.Time
+		free    []time.Time
+	}{
+		{
+			&Window{Days: []string{"mon", "Fri"}, From: "08:00", To: "19:00"},
+			[]time.Time{at(10, 8, 0), at(10, 18, 59), at(6, 12, 0)},
+			[]time.Time{at(10, 7, 59), at(10, 19, 0), at(11, 12, 0)},
+		},
+		{
+			&Window{From: "20:00", To: "24:00"},
+			[]time.Time{at(10, 20, 0), at(11, 23, 59)},
+			[]time.Time{at(11, 0, 0), at(10, 19, 59)},
+		},
+		// the morning part belongs to the day the window starts on
+		{
+			&Window{Days: []string{"fri"}, From: "22:00", To: "06:00"},
+			[]time.Time{at(10, 22, 0), at(10, 23, 59), at(11, 0, 0), at(11, 5, 59)},
+			[]time.Time{at(10, 21, 59), at(11, 6, 0), at(11, 22, 30), at(10, 5, 0), at(9, 23, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.window.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, t0 := range tt.covered {
+			if !tt.window.covers(t0) {
+				t.Errorf("%+v does not cover %s", tt.window, t0)
+			}
+		}
+
+		for _, t0 := range tt.free {
+			if tt.window.covers(t0) {
+				t.Errorf("%+v covers %s", tt.window, t0)
+			}
+		}
+	}
+
+	for _, bad := range []*Window{
+		{From: "08:00", To: "08:00"},
+		{From: "8", To: "09:00"},
+		{Days: []string{"monday"}, From: "08:00", To: "09:00"},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSettingsFile = "settings.json"
+
+// Settings is the server side configuration. Secrets such as bot tokens and
+// API keys live here instead of being passed by clients on every request.
+type Settings struct {
+	Listen     string             `json:"listen"`
+	Targets    map[string]*Target `json:"targets"`
+	APIKeys    []*APIKey          `json:"api_keys"`
+	Queue      QueueSettings      `json:"queue"`
+	Cameras    map[string]*Camera `json:"cameras"`
+	Layouts    map[string]*Layout `json:"layouts"`
+	Watch      []*WatchSettings   `json:"watch"`
+	Quality    QualitySettings    `json:"quality"`
+	Confidence ConfidenceSettings `json:"confidence"`
+	Alerts     AlertSettings      `json:"alerts"`
+	History    HistorySettings    `json:"history"`
+	Forecast   ForecastSettings   `json:"forecast"`
+	Heatmaps   []*HeatmapSchedule `json:"heatmaps"`
+	Archive    ArchiveSettings    `json:"archive"`
+	Theme      Theme              `json:"theme"`
+	Webhooks   WebhookSettings    `json:"webhooks"`
+
+	HomeAssistant HomeAssistantSettings `json:"homeassistant"`
+	MQTT          MQTTSettings          `json:"mqtt"`
+
+	// LiveMessages is the file the ids of live Telegram messages are kept
+	// in.
+	LiveMessages string `json:"live_messages"`
+
+	// Ingress trusts requests from the Home Assistant ingress gateway and
+	// serves links under their X-Ingress-Path. It is on in the add-on.
+	Ingress bool `json:"ingress"`
+
+	// BaseURL is the address browsers open the service at, e.g.
+	// "https://parking.example.com" behind a proxy. WebSocket clients from
+	// pages of other origins are refused.
+	BaseURL string `json:"base_url"`
+}
+
+// AlertSettings route alerts about spots, e.g. rule violations, to their own
+// target instead of the camera's one.
+type AlertSettings struct {
+	Target string `json:"target"`
+}
+
+// APIKey grants access to the HTTP endpoints within its scope.
+type APIKey struct {
+	Name    string `json:"name"`
+	Key     string `json:"key"`
+	KeyFile string `json:"key_file"`
+	Scope   Scope  `json:"scope"`
+}
+
+// loadSettings reads settings from path. A missing file yields empty settings,
+// which means every request is rejected until API keys are configured.
+func loadSettings(path string) (*Settings, error) {
+	s := &Settings{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		fmt.Printf("settings file %s not found, no API keys configured\n", path)
+	} else if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	if err := s.applyOptions(optionsFile()); err != nil {
+		return nil, fmt.Errorf("add-on options: %w", err)
+	}
+
+	if s.Listen == "" {
+		s.Listen = "0.0.0.0:9991"
+	}
+
+	if s.LiveMessages == "" {
+		s.LiveMessages = "live_messages.json"
+	}
+
+	s.Quality.init()
+	s.Confidence.init()
+	s.History.init()
+	s.Archive.init()
+
+	if err := s.Forecast.init(); err != nil {
+		return nil, fmt.Errorf("forecast: %w", err)
+	}
+
+	if err := s.Theme.init(); err != nil {
+		return nil, fmt.Errorf("theme: %w", err)
+	}
+
+	if err := s.Webhooks.init(); err != nil {
+		return nil, err
+	}
+
+	if err := s.HomeAssistant.init(); err != nil {
+		return nil, fmt.Errorf("homeassistant: %w", err)
+	}
+
+	if err := s.MQTT.init(); err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+
+	if s.BaseURL != "" {
+		if u, err := url.Parse(s.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("base_url %q, want scheme://host", s.BaseURL)
+		}
+	}
+
+	if s.Confidence.Mode != LowConfidenceFlag && s.Confidence.Mode != LowConfidenceHide {
+		return nil, fmt.Errorf("confidence: unknown mode %q", s.Confidence.Mode)
+	}
+
+	if s.Targets == nil {
+		s.Targets = map[string]*Target{}
+	}
+
+	for name, t := range s.Targets {
+		if err := t.init(); err != nil {
+			return nil, fmt.Errorf("target %s: %w", name, err)
+		}
+	}
+
+	if s.Alerts.Target != "" && s.Targets[s.Alerts.Target] == nil {
+		return nil, fmt.Errorf("alerts: unknown target %s", s.Alerts.Target)
+	}
+
+	for name, l := range s.Layouts {
+		if err := l.validate(); err != nil {
+			return nil, fmt.Errorf("layout %s: %w", name, err)
+		}
+	}
+
+	for id, c := range s.Cameras {
+		if c.Target != "" && s.Targets[c.Target] == nil {
+			return nil, fmt.Errorf("camera %s: unknown target %s", id, c.Target)
+		}
+
+		if c.Layout != "" && s.Layouts[c.Layout] == nil {
+			return nil, fmt.Errorf("camera %s: unknown layout %s", id, c.Layout)
+		}
+	}
+
+	for i, ws := range s.Watch {
+		if err := ws.init(); err != nil {
+			return nil, fmt.Errorf("watch %d: %w", i, err)
+		}
+
+		if ws.Camera != "" && s.Cameras[ws.Camera] == nil {
+			return nil, fmt.Errorf("watch %d: unknown camera %s", i, ws.Camera)
+		}
+	}
+
+	for i, hs := range s.Heatmaps {
+		if err := hs.init(); err != nil {
+			return nil, fmt.Errorf("heatmap %d: %w", i, err)
+		}
+
+		if s.Cameras[hs.Camera] == nil {
+			return nil, fmt.Errorf("heatmap %d: unknown camera %s", i, hs.Camera)
+		}
+
+		if s.Targets[hs.Target] == nil {
+			return nil, fmt.Errorf("heatmap %d: unknown target %s", i, hs.Target)
+		}
+
+		if s.History.Dir == "" {
+			return nil, fmt.Errorf("heatmap %d: history is not configured", i)
+		}
+	}
+
+	for i, k := range s.APIKeys {
+		if k.KeyFile != "" {
+			if k.Key, err = readSecret(k.KeyFile); err != nil {
+				return nil, fmt.Errorf("api key %d: %w", i, err)
+			}
+		}
+
+		if k.Key == "" {
+			return nil, fmt.Errorf("api key %d: key is empty", i)
+		}
+
+		if !k.Scope.valid() {
+			return nil, fmt.Errorf("api key %d: unknown scope %q", i, k.Scope)
+		}
+	}
+
+	return s, nil
+}
+
+// readSecret returns the trimmed content of a secret file, e.g. a docker or
+// kubernetes secret mount.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Duration is a time.Duration written in settings as a string, e.g. "15m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
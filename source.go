@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Camera is a configured image source with its own Telegram target.
+type Camera struct {
+	Target   string          `json:"target"`
+	Night    bool            `json:"night"`
+	Layout   string          `json:"layout"`
+	Interval Duration        `json:"interval"`
+	Source   *SourceSettings `json:"source"`
+}
+
+// SourceSettings describes where a camera gets its frames from.
+type SourceSettings struct {
+	Type string `json:"type"`
+
+	// exec
+	Command      []string `json:"command"`
+	RestartDelay Duration `json:"restart_delay"`
+
+	// homeassistant
+	Entity   string   `json:"entity"`
+	Interval Duration `json:"interval"`
+}
+
+// FrameSource produces encoded frames for a camera.
+type FrameSource interface {
+	// Run calls emit for every frame until ctx is cancelled.
+	Run(ctx context.Context, emit func(data []byte)) error
+}
+
+// capturer is a FrameSource that can take a frame on request.
+type capturer interface {
+	Capture()
+}
+
+func newFrameSource(settings *SourceSettings, ha *HomeAssistantSettings) (FrameSource, error) {
+	switch settings.Type {
+	case "exec":
+		return newExecSource(settings)
+	case "homeassistant":
+		return newHACameraSource(settings, ha)
+	default:
+		return nil, fmt.Errorf("unknown source type %q", settings.Type)
+	}
+}
+
+// runCamera feeds frames from the camera's source into the queue. Only the
+// latest frame is kept: frames arriving while the previous one is still being
+// processed replace each other, so a slow pipeline never builds a backlog.
+func (s *server) runCamera(ctx context.Context, id string, camera *Camera) error {
+	source, err := newFrameSource(camera.Source, &s.settings.HomeAssistant)
+	if err != nil {
+		return fmt.Errorf("camera %s: %w", id, err)
+	}
+
+	s.sources[id] = source
+
+	latest := make(chan []byte, 1)
+
+	go func() {
+		err := source.Run(ctx, func(data []byte) {
+			// drop the unprocessed frame, if any, and keep the new one
+			select {
+			case <-latest:
+			default:
+			}
+
+			latest <- data
+		})
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("camera %s: source stopped: %s\n", id, err)
+		}
+	}()
+
+	go func() {
+		var last time.Time
+
+		for {
+			if wait := time.Until(last.Add(time.Duration(camera.Interval))); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			var data []byte
+			select {
+			case <-ctx.Done():
+				return
+			case data = <-latest:
+			}
+
+			last = time.Now()
+
+			job := &Job{
+				Camera: id,
+				Target: camera.Target,
+				Day:    !camera.Night,
+				data:   data,
+			}
+
+			if err := s.queue.Submit(job); err != nil {
+				fmt.Printf("camera %s: %s\n", id, err)
+
+				continue
+			}
+
+			<-job.done
+		}
+	}()
+
+	return nil
+}
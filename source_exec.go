@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxRestartDelay caps the backoff between restarts of a crashing command.
+const maxRestartDelay = time.Minute
+
+// execSource runs a command, e.g. an ffmpeg pipeline, that writes a stream of
+// JPEG or PNG images to stdout, such as ffmpeg's image2pipe output:
+//
+//	ffmpeg -rtsp_transport tcp -i rtsp://camera/stream -vf fps=1 -f image2pipe -c:v mjpeg -
+type execSource struct {
+	command      []string
+	restartDelay time.Duration
+}
+
+func newExecSource(settings *SourceSettings) (*execSource, error) {
+	if len(settings.Command) == 0 {
+		return nil, errors.New("exec source: command is empty")
+	}
+
+	delay := time.Duration(settings.RestartDelay)
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	return &execSource{command: settings.Command, restartDelay: delay}, nil
+}
+
+// Run starts the command and restarts it whenever it exits. The delay between
+// restarts doubles while the command keeps crashing right after start.
+func (es *execSource) Run(ctx context.Context, emit func(data []byte)) error {
+	delay := es.restartDelay
+
+	for {
+		started := time.Now()
+
+		err := es.runOnce(ctx, emit)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = es.restartDelay
+		}
+
+		fmt.Printf("exec source %s exited: %v, restarting in %s\n", es.command[0], err, delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+func (es *execSource) runOnce(ctx context.Context, emit func(data []byte)) error {
+	// the command is killed when the run ends, so a broken stream restarts
+	// it instead of leaving it writing into a pipe nobody reads
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, es.command[0], es.command[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := readFrames(stdout, emit); err != nil {
+		cancel()
+		cmd.Wait()
+
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// maxFrameSize limits a frame read from a stream, like maxUploadSize does
+// for uploads.
+const maxFrameSize = maxUploadSize
+
+var errFrameTooLarge = fmt.Errorf("frame is larger than %d bytes", maxFrameSize)
+
+// readFrames splits a stream of concatenated JPEG and PNG images into frames.
+func readFrames(r io.Reader, emit func(data []byte)) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+
+	for {
+		head, err := br.Peek(2)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		var frame []byte
+		switch {
+		case head[0] == 0xff && head[1] == 0xd8:
+			frame, err = readJPEG(br)
+		case head[0] == 0x89 && head[1] == 'P':
+			frame, err = readPNG(br)
+		default:
+			return fmt.Errorf("unexpected bytes %x in frame stream", head)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		emit(frame)
+	}
+}
+
+// readJPEG reads one JPEG image. Segments are skipped by their length up to
+// the start of scan, so embedded thumbnails don't end the frame early; in
+// the entropy coded data 0xff is always escaped, so the first 0xffd9 there
+// is the end of the image.
+func readJPEG(br *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(br, marker[:2]); err != nil {
+		return nil, err
+	}
+	buf.Write(marker[:2])
+
+	// headers up to start of scan
+	for {
+		if _, err := io.ReadFull(br, marker[:2]); err != nil {
+			return nil, err
+		}
+		buf.Write(marker[:2])
+
+		if marker[0] != 0xff {
+			return nil, fmt.Errorf("bad jpeg marker %x", marker[:2])
+		}
+
+		// fill bytes
+		for marker[1] == 0xff {
+			b, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+
+			buf.WriteByte(b)
+			marker[1] = b
+
+			if buf.Len() > maxFrameSize {
+				return nil, errFrameTooLarge
+			}
+		}
+
+		if marker[1] == 0xd9 {
+			return buf.Bytes(), nil
+		}
+
+		// standalone markers have no length
+		if marker[1] == 0x01 || (marker[1] >= 0xd0 && marker[1] <= 0xd7) {
+			continue
+		}
+
+		if _, err := io.ReadFull(br, marker[2:4]); err != nil {
+			return nil, err
+		}
+		buf.Write(marker[2:4])
+
+		size := int(binary.BigEndian.Uint16(marker[2:4])) - 2
+		if size < 0 {
+			return nil, errors.New("bad jpeg segment length")
+		}
+
+		if buf.Len()+size > maxFrameSize {
+			return nil, errFrameTooLarge
+		}
+
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == 0xda {
+			break
+		}
+	}
+
+	// entropy coded data, possibly followed by more scans
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(b)
+
+		if buf.Len() > maxFrameSize {
+			return nil, errFrameTooLarge
+		}
+
+		if b != 0xff {
+			continue
+		}
+
+		next, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(next)
+
+		if next == 0xd9 {
+			return buf.Bytes(), nil
+		}
+
+		if next == 0xff {
+			// fill byte, look at the following one again
+			br.UnreadByte()
+			buf.Truncate(buf.Len() - 1)
+		}
+	}
+}
+
+// readPNG reads one PNG image: the signature followed by chunks up to IEND.
+func readPNG(br *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if _, err := io.CopyN(&buf, br, 8); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(buf.Bytes(), []byte("\x89PNG\r\n\x1a\n")) {
+		return nil, errors.New("bad png signature")
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, err
+		}
+		buf.Write(header)
+
+		// chunk data and crc
+		size := int64(binary.BigEndian.Uint32(header)) + 4
+		if int64(buf.Len())+size > maxFrameSize {
+			return nil, errFrameTooLarge
+		}
+
+		if _, err := io.CopyN(&buf, br, size); err != nil {
+			return nil, err
+		}
+
+		if string(header[4:8]) == "IEND" {
+			return buf.Bytes(), nil
+		}
+	}
+}
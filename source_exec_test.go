@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameStream returns a JPEG with an EXIF block that contains an end of
+// image marker, a PNG and a JPEG with fill bytes before a marker.
+func frameStream(t *testing.T) [][]byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	// an EXIF string that looks like the end of the image
+	thumb := exifBlock(binary.BigEndian, 1, "2024:05:06 07:08:09\xff\xd9", "", "")
+
+	plain := jpegWithExif(t, img, nil)
+	filled := append(append(append([]byte{}, plain[:2]...), 0xff, 0xff), plain[2:]...)
+
+	return [][]byte{
+		jpegWithExif(t, img, thumb),
+		pngWithExif(t, img, thumb),
+		filled,
+	}
+}
+
+func TestReadFrames(t *testing.T) {
+	frames := frameStream(t)
+
+	var got [][]byte
+	err := readFrames(bytes.NewReader(bytes.Join(frames, nil)), func(data []byte) {
+		got = append(got, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(frames) {
+		t.Fatalf("got %d frames, want %d", len(got), len(frames))
+	}
+
+	for i := range frames {
+		if !bytes.Equal(got[i], frames[i]) {
+			t.Errorf("frame %d: %d bytes, want %d", i, len(got[i]), len(frames[i]))
+		}
+
+		if _, _, err := decodeFrame(got[i]); err != nil {
+			t.Errorf("frame %d: %v", i, err)
+		}
+	}
+
+	stream := bytes.Join(frames, nil)
+	for name, data := range map[string][]byte{
+		"garbage":   append(append([]byte{}, frames[0]...), "garbage"...),
+		"truncated": stream[:len(stream)-10],
+	} {
+		if err := readFrames(bytes.NewReader(data), func([]byte) {}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// zeros is an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+
+	return len(p), nil
+}
+
+func TestReadFramesTooLarge(t *testing.T) {
+	// a PNG chunk announcing more than a frame may hold
+	chunk := make([]byte, 8)
+	binary.BigEndian.PutUint32(chunk, maxFrameSize)
+	copy(chunk[4:], "IDAT")
+
+	png := io.MultiReader(strings.NewReader("\x89PNG\r\n\x1a\n"), bytes.NewReader(chunk), zeros{})
+
+	// a JPEG whose entropy coded data never ends
+	jpeg := io.MultiReader(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xda, 0, 2}), zeros{})
+
+	for name, r := range map[string]io.Reader{"png": png, "jpeg": jpeg} {
+		if err := readFrames(r, func([]byte) { t.Errorf("%s: emitted a frame", name) }); !errors.Is(err, errFrameTooLarge) {
+			t.Errorf("%s: %v, want %v", name, err, errFrameTooLarge)
+		}
+	}
+}
+
+func TestExecSource(t *testing.T) {
+	frames := frameStream(t)
+
+	dir := t.TempDir()
+
+	var args []string
+	for i, frame := range frames {
+		name := filepath.Join(dir, string(rune('a'+i))+".img")
+		if err := os.WriteFile(name, frame, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		args = append(args, name)
+	}
+
+	// the stream drops after every two frames and the source restarts it
+	t.Setenv("FAKE_FFMPEG_INTERVAL", "0")
+	t.Setenv("FAKE_FFMPEG_COUNT", "2")
+
+	es := &execSource{
+		command:      append([]string{"testdata/fake-ffmpeg.sh"}, args...),
+		restartDelay: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	got := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		done <- es.Run(ctx, func(data []byte) {
+			select {
+			case got <- data:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	// two runs: a, b, then a, b again
+	for i, want := range [][]byte{frames[0], frames[1], frames[0], frames[1]} {
+		select {
+		case data := <-got:
+			if !bytes.Equal(data, want) {
+				t.Errorf("frame %d: %d bytes, want %d", i, len(data), len(want))
+			}
+		case <-ctx.Done():
+			t.Fatalf("frame %d did not arrive", i)
+		}
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExecSourceKillsBrokenStream(t *testing.T) {
+	// the command keeps running after writing garbage
+	es := &execSource{command: []string{"sh", "-c", "printf garbage; exec sleep 60"}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- es.runOnce(context.Background(), func([]byte) {})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "unexpected bytes") {
+			t.Errorf("runOnce returned %v, want the stream error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runOnce did not stop the command after a broken stream")
+	}
+}
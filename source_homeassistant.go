@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxSnapshotSize limits a snapshot from the camera proxy.
+const maxSnapshotSize = 32 << 20
+
+// haCameraSource fetches snapshots of a Home Assistant camera entity through
+// /api/camera_proxy, so the camera credentials stay in Home Assistant. It
+// polls every interval and whenever a capture is requested.
+type haCameraSource struct {
+	url      string
+	token    string
+	entity   string
+	interval time.Duration
+	client   *http.Client
+
+	capture chan struct{}
+}
+
+func newHACameraSource(settings *SourceSettings, ha *HomeAssistantSettings) (*haCameraSource, error) {
+	if !strings.HasPrefix(settings.Entity, "camera.") {
+		return nil, fmt.Errorf("homeassistant source: entity %q is not a camera", settings.Entity)
+	}
+
+	if ha.Token == "" {
+		return nil, errors.New("homeassistant source: no home assistant token configured")
+	}
+
+	interval := time.Duration(settings.Interval)
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	return &haCameraSource{
+		url:      ha.URL + "/api/camera_proxy/" + url.PathEscape(settings.Entity),
+		token:    ha.Token,
+		entity:   settings.Entity,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		capture:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Run fetches a snapshot right away and then on every tick or capture.
+// Failed fetches are logged and retried on the next one.
+func (hs *haCameraSource) Run(ctx context.Context, emit func(data []byte)) error {
+	ticker := time.NewTicker(hs.interval)
+	defer ticker.Stop()
+
+	for {
+		data, err := hs.fetch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			fmt.Printf("homeassistant source %s: %s\n", hs.entity, err)
+		} else {
+			emit(data)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-hs.capture:
+		}
+	}
+}
+
+// Capture asks for a snapshot now. Requests made while one is pending are
+// merged.
+func (hs *haCameraSource) Capture() {
+	select {
+	case hs.capture <- struct{}{}:
+	default:
+	}
+}
+
+func (hs *haCameraSource) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+hs.token)
+
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxSnapshotSize {
+		return nil, errors.New("snapshot is too large")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cameraProxy stands in for /api/camera_proxy of Home Assistant: it checks
+// the token and answers with status, the snapshot when it is 200.
+type cameraProxy struct {
+	status atomic.Int32
+	calls  atomic.Int32
+	paths  chan string
+}
+
+func newCameraProxy(t *testing.T, entity string, interval time.Duration) (*cameraProxy, *haCameraSource) {
+	t.Helper()
+
+	proxy := &cameraProxy{paths: make(chan string, 64)}
+	proxy.status.Store(http.StatusOK)
+
+	ts := httptest.NewServer(proxy)
+	t.Cleanup(ts.Close)
+
+	hs, err := newHACameraSource(&SourceSettings{Type: "homeassistant", Entity: entity, Interval: Duration(interval)}, &HomeAssistantSettings{URL: ts.URL, Token: "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return proxy, hs
+}
+
+var snapshot = []byte("\xff\xd8snapshot\xff\xd9")
+
+func (p *cameraProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.calls.Add(1)
+
+	select {
+	case p.paths <- r.URL.EscapedPath():
+	default:
+	}
+
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	if status := int(p.status.Load()); status != http.StatusOK {
+		http.Error(w, "camera is off", status)
+
+		return
+	}
+
+	w.Write(snapshot)
+}
+
+// runSource runs hs until the test ends and passes the frames on.
+func runSource(t *testing.T, hs *haCameraSource) <-chan []byte {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames := make(chan []byte, 64)
+	done := make(chan error)
+
+	go func() {
+		done <- hs.Run(ctx, func(data []byte) {
+			select {
+			case frames <- data:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run returned %v, want context.Canceled", err)
+		}
+	})
+
+	return frames
+}
+
+func nextFrame(t *testing.T, frames <-chan []byte) []byte {
+	t.Helper()
+
+	select {
+	case data := <-frames:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame")
+
+		return nil
+	}
+}
+
+func TestHACameraSourcePolling(t *testing.T) {
+	proxy, hs := newCameraProxy(t, "camera.yard", 20*time.Millisecond)
+	frames := runSource(t, hs)
+
+	// the first snapshot is fetched right away, the next every interval
+	for range 3 {
+		if data := nextFrame(t, frames); !bytes.Equal(data, snapshot) {
+			t.Fatalf("frame %q, want %q", data, snapshot)
+		}
+	}
+
+	if path := <-proxy.paths; path != "/api/camera_proxy/camera.yard" {
+		t.Errorf("path %s", path)
+	}
+}
+
+func TestHACameraSourceCapture(t *testing.T) {
+	proxy, hs := newCameraProxy(t, "camera.yard", time.Hour)
+
+	// requests made before the source runs are merged into one
+	hs.Capture()
+	hs.Capture()
+
+	frames := runSource(t, hs)
+
+	nextFrame(t, frames)
+	nextFrame(t, frames)
+
+	select {
+	case <-frames:
+		t.Error("a second pending capture was not merged")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	hs.Capture()
+	nextFrame(t, frames)
+
+	if n := proxy.calls.Load(); n != 3 {
+		t.Errorf("%d snapshots fetched, want 3", n)
+	}
+}
+
+func TestHACameraSourceErrors(t *testing.T) {
+	proxy, hs := newCameraProxy(t, "camera.yard", time.Hour)
+	proxy.status.Store(http.StatusServiceUnavailable)
+
+	_, err := hs.fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "camera is off") {
+		t.Errorf("fetch of a 503 = %v, want the status and the message", err)
+	}
+
+	// a failed fetch emits nothing and the source keeps running
+	frames := runSource(t, hs)
+	<-proxy.paths
+	<-proxy.paths
+
+	select {
+	case data := <-frames:
+		t.Fatalf("emitted %q for a 503", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	proxy.status.Store(http.StatusOK)
+	hs.Capture()
+
+	if data := nextFrame(t, frames); !bytes.Equal(data, snapshot) {
+		t.Errorf("frame %q after recovering", data)
+	}
+}
+
+func TestHACameraSourceTooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxSnapshotSize+1))
+	}))
+	defer ts.Close()
+
+	hs, err := newHACameraSource(&SourceSettings{Entity: "camera.yard"}, &HomeAssistantSettings{URL: ts.URL, Token: "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := hs.fetch(context.Background()); err == nil {
+		t.Error("a snapshot over the limit was accepted")
+	}
+}
+
+func TestHACameraSourceToken(t *testing.T) {
+	proxy, hs := newCameraProxy(t, "camera.gate 1", time.Hour)
+
+	if _, err := hs.fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if path := <-proxy.paths; path != "/api/camera_proxy/camera.gate%201" {
+		t.Errorf("path %s, want the entity escaped", path)
+	}
+
+	hs.token = "wrong"
+	if _, err := hs.fetch(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("fetch with a wrong token = %v, want 401", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		entity string
+		token  string
+	}{
+		{"no token", "camera.yard", ""},
+		{"not a camera", "sensor.yard", "tok"},
+	} {
+		if _, err := newHACameraSource(&SourceSettings{Entity: tt.entity}, &HomeAssistantSettings{URL: "http://ha", Token: tt.token}); err == nil {
+			t.Errorf("%s: the source was created", tt.name)
+		}
+	}
+
+	// inside the add-on the Supervisor token reaches the core proxy
+	t.Setenv("SUPERVISOR_TOKEN", "supervisor")
+
+	ha := &HomeAssistantSettings{}
+	if err := ha.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := newHACameraSource(&SourceSettings{Entity: "camera.yard"}, ha)
+	if err != nil || hs.token != "supervisor" || hs.url != supervisorCoreURL+"/api/camera_proxy/camera.yard" || hs.interval != 30*time.Second {
+		t.Errorf("add-on source %+v, %v", hs, err)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamHeartbeat = 15 * time.Second
+
+	// streamBuffer is the number of events a client may fall behind before
+	// it is disconnected. It gets a fresh snapshot when it reconnects.
+	streamBuffer = 64
+)
+
+// streamFilter selects what a stream client receives. Empty lists match
+// everything; frame.analyzed is only sent when asked for by events.
+type streamFilter struct {
+	cameras []string
+	zones   []string
+	events  []string
+}
+
+func formList(r *http.Request, name string) []string {
+	var list []string
+	for _, v := range r.Form[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+func (f *streamFilter) camera(id string) bool {
+	return len(f.cameras) == 0 || slices.Contains(f.cameras, id)
+}
+
+func (f *streamFilter) spot(spot *SpotResult) bool {
+	if len(f.zones) == 0 {
+		return true
+	}
+
+	for _, zone := range spot.Zones {
+		if slices.Contains(f.zones, zone) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *streamFilter) match(ev Event) bool {
+	if !f.camera(ev.Camera) {
+		return false
+	}
+
+	if len(f.events) > 0 {
+		if !matchEvent(f.events, ev.Type) {
+			return false
+		}
+	} else if ev.Type == EventFrameAnalyzed {
+		return false
+	}
+
+	if spot, ok := ev.Data.(*SpotResult); ok {
+		return f.spot(spot)
+	}
+
+	return true
+}
+
+// filterAnalysis returns a copy of a with only the spots and zones the
+// client asked for. Low confidence spots are left out in "hide" mode.
+func (s *server) filterAnalysis(a *Analysis, f *streamFilter) *Analysis {
+	filtered := *a
+	filtered.Spots = nil
+	for _, spot := range visibleSpots(a.Spots, &s.settings.Confidence, 0) {
+		if f.spot(spot) {
+			filtered.Spots = append(filtered.Spots, spot)
+		}
+	}
+
+	if len(f.zones) > 0 {
+		filtered.Zones = nil
+		for _, zc := range a.Zones {
+			if slices.Contains(f.zones, zc.ID) {
+				filtered.Zones = append(filtered.Zones, zc)
+			}
+		}
+	}
+
+	return &filtered
+}
+
+// StreamSnapshot is the first message of a stream: the last analysis of
+// every camera and the recent events.
+type StreamSnapshot struct {
+	Type    string               `json:"type"`
+	Time    time.Time            `json:"time"`
+	Cameras map[string]*Analysis `json:"cameras"`
+	Events  []Event              `json:"events"`
+}
+
+func (s *server) snapshot(f *streamFilter) *StreamSnapshot {
+	snap := &StreamSnapshot{
+		Type:    "snapshot",
+		Time:    time.Now(),
+		Cameras: map[string]*Analysis{},
+		Events:  s.events.Recent(f.match),
+	}
+
+	for _, id := range s.cameras.ids() {
+		if !f.camera(id) {
+			continue
+		}
+
+		if a := s.cameras.get(id).lastAnalysis(); a != nil {
+			snap.Cameras[id] = s.filterAnalysis(a, f)
+		}
+	}
+
+	return snap
+}
+
+// streamPing is the heartbeat message, which lets clients notice a dead
+// connection.
+type streamPing struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+// stream pushes a snapshot and then the events of the filter as
+// Server-Sent Events, or over a WebSocket when the client asks for an
+// upgrade. Filters are ?camera=, ?zone= and ?events=, all comma separated;
+// ?heartbeat= sets the ping interval.
+func (s *server) stream(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	f := &streamFilter{
+		cameras: formList(r, "camera"),
+		zones:   formList(r, "zone"),
+		events:  formList(r, "events"),
+	}
+
+	heartbeat := streamHeartbeat
+	if v := r.FormValue("heartbeat"); v != "" {
+		var err error
+		if heartbeat, err = time.ParseDuration(v); err != nil || heartbeat < time.Second {
+			http.Error(w, "heartbeat must be a duration of at least 1s", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	events := make(chan Event, streamBuffer)
+	overflow := make(chan struct{})
+
+	var once sync.Once
+
+	// subscribe before taking the snapshot, so no change gets lost between
+	unsubscribe := s.bus.Subscribe(func(ev Event) {
+		if !f.match(ev) {
+			return
+		}
+
+		if a, ok := ev.Data.(*Analysis); ok {
+			ev.Data = s.filterAnalysis(a, f)
+		}
+
+		select {
+		case events <- ev:
+		default:
+			once.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	if isWebSocket(r) {
+		s.streamWebSocket(w, r, f, events, overflow, heartbeat)
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(name string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+
+		return nil
+	}
+
+	if err := send("snapshot", s.snapshot(f)); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-overflow:
+			fmt.Printf("stream client %s is too slow, disconnecting\n", r.RemoteAddr)
+
+			return
+		case ev := <-events:
+			err = send(ev.Type, ev)
+		case t := <-ticker.C:
+			err = send("ping", streamPing{Type: "ping", Time: t})
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) streamWebSocket(w http.ResponseWriter, r *http.Request, f *streamFilter, events chan Event, overflow chan struct{}, heartbeat time.Duration) {
+	c, err := upgradeWebSocket(w, r, s.originHosts(r)...)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		c.readLoop()
+		close(done)
+	}()
+
+	send := func(v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		return c.write(wsText, data)
+	}
+
+	if err := send(s.snapshot(f)); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-done:
+			return
+		case <-overflow:
+			fmt.Printf("stream client %s is too slow, disconnecting\n", r.RemoteAddr)
+
+			// 1013: try again later
+			c.write(wsClose, binary.BigEndian.AppendUint16(nil, 1013))
+
+			return
+		case ev := <-events:
+			err = send(ev)
+		case t := <-ticker.C:
+			err = send(streamPing{Type: "ping", Time: t})
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
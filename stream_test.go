@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamServer returns a server with analyses of the cameras "yard" and
+// "gate" and a logged problem of each, serving the stream without
+// authentication.
+func streamServer(t *testing.T) (*server, string) {
+	t.Helper()
+
+	s := &server{settings: &Settings{}, bus: &Bus{}, events: &EventLog{}}
+	s.settings.Confidence.init()
+	s.bus.Subscribe(s.events.add)
+
+	for _, camera := range []string{"yard", "gate"} {
+		s.cameras.get(camera).setLast(&Analysis{
+			Spots: []*SpotResult{
+				{ID: "a", Zones: []string{"ev"}, Status: StatusFree, Confidence: 0.9},
+				{ID: "b", Status: StatusOccupied, Confidence: 0.9},
+				{ID: "c", Zones: []string{"ev"}, Status: StatusFree, Confidence: 0.1, LowConfidence: true},
+			},
+			Zones: []*ZoneCount{{ID: "ev", Free: 2, Total: 2}},
+		})
+
+		s.bus.Publish(Event{Type: EventCameraProblem, Camera: camera, Message: "blurred"})
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(s.stream))
+	t.Cleanup(ts.Close)
+
+	return s, ts.URL
+}
+
+// sseEvent reads the next Server-Sent Event.
+func sseEvent(t *testing.T, r *bufio.Reader) (name string, data []byte) {
+	t.Helper()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func openSSE(t *testing.T, url string) *bufio.Reader {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("%s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	return bufio.NewReader(resp.Body)
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	_, url := streamServer(t)
+
+	r := openSSE(t, url+"?camera=yard&zone=ev")
+
+	name, data := sseEvent(t, r)
+	if name != "snapshot" {
+		t.Fatalf("first event %s", name)
+	}
+
+	var snap StreamSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+
+	yard := snap.Cameras["yard"]
+	if snap.Type != "snapshot" || len(snap.Cameras) != 1 || yard == nil {
+		t.Fatalf("snapshot %s", data)
+	}
+
+	// only spots of the zone, low confidence ones are flagged, not hidden
+	if len(yard.Spots) != 2 || yard.Spots[0].ID != "a" || yard.Spots[1].ID != "c" || len(yard.Zones) != 1 {
+		t.Errorf("yard %s", data)
+	}
+
+	if len(snap.Events) != 1 || snap.Events[0].Camera != "yard" || snap.Events[0].Type != EventCameraProblem {
+		t.Errorf("events %+v", snap.Events)
+	}
+}
+
+func TestStreamSnapshotHidden(t *testing.T) {
+	s, url := streamServer(t)
+	s.settings.Confidence.Mode = LowConfidenceHide
+
+	_, data := sseEvent(t, openSSE(t, url))
+
+	var snap StreamSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snap.Cameras) != 2 || len(snap.Cameras["gate"].Spots) != 2 || len(snap.Events) != 2 {
+		t.Errorf("snapshot %s", data)
+	}
+}
+
+func TestStreamFilters(t *testing.T) {
+	s, url := streamServer(t)
+
+	r := openSSE(t, url+"?camera=yard&zone=ev")
+	sseEvent(t, r)
+
+	s.bus.Publish(Event{Type: EventSpotChanged, Camera: "gate", Data: &SpotResult{ID: "a", Zones: []string{"ev"}}})
+	s.bus.Publish(Event{Type: EventSpotChanged, Camera: "yard", Data: &SpotResult{ID: "b"}})
+	s.bus.Publish(Event{Type: EventFrameAnalyzed, Camera: "yard", Data: &Analysis{}})
+	s.bus.Publish(Event{Type: EventSpotChanged, Camera: "yard", Message: "a is free", Data: &SpotResult{ID: "a", Zones: []string{"ev"}}})
+	s.bus.Publish(Event{Type: EventLotFull, Camera: "yard", Message: "full"})
+
+	// the first events were filtered out
+	for _, want := range []string{"a is free", "full"} {
+		name, data := sseEvent(t, r)
+
+		var ev Event
+		if err := json.Unmarshal(data, &ev); err != nil || ev.Message != want || ev.Type != name {
+			t.Errorf("event %s: %s, want %q", name, data, want)
+		}
+	}
+
+	// analyzed frames are only sent when asked for, with the spots of the
+	// zones
+	r = openSSE(t, url+"?events=frame.*&zone=ev")
+	sseEvent(t, r)
+
+	s.bus.Publish(Event{Type: EventLotFull, Camera: "yard"})
+	s.bus.Publish(Event{Type: EventFrameAnalyzed, Camera: "gate", Data: &Analysis{Spots: []*SpotResult{{ID: "a", Zones: []string{"ev"}}, {ID: "b"}}}})
+
+	name, data := sseEvent(t, r)
+
+	var ev struct {
+		Data Analysis `json:"data"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil || name != EventFrameAnalyzed || len(ev.Data.Spots) != 1 {
+		t.Errorf("event %s: %s", name, data)
+	}
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	_, url := streamServer(t)
+
+	r := openSSE(t, url+"?heartbeat=1s")
+	sseEvent(t, r)
+
+	start := time.Now()
+	if name, data := sseEvent(t, r); name != "ping" || !strings.Contains(string(data), `"type":"ping"`) {
+		t.Errorf("event %s: %s", name, data)
+	}
+
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("ping after %s", took)
+	}
+
+	for _, heartbeat := range []string{"500ms", "soon"} {
+		resp, err := http.Get(url + "?heartbeat=" + heartbeat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("heartbeat %s: %s", heartbeat, resp.Status)
+		}
+	}
+}
+
+// stuckWriter is a response writer that stops writing after the first
+// write until released.
+type stuckWriter struct {
+	header   http.Header
+	wrote    chan struct{}
+	released chan struct{}
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (w *stuckWriter) Header() http.Header { return w.header }
+func (w *stuckWriter) WriteHeader(int)     {}
+func (w *stuckWriter) Flush()              {}
+
+func (w *stuckWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.writes++
+	first := w.writes == 1
+	w.mu.Unlock()
+
+	if first {
+		close(w.wrote)
+	} else {
+		<-w.released
+	}
+
+	return len(b), nil
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	s, _ := streamServer(t)
+
+	w := &stuckWriter{header: http.Header{}, wrote: make(chan struct{}), released: make(chan struct{})}
+	done := make(chan struct{})
+
+	go func() {
+		s.stream(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+		close(done)
+	}()
+
+	<-w.wrote
+
+	// one event is being written, the buffer fills up and the rest is too
+	// much
+	for range streamBuffer + 2 {
+		s.bus.Publish(Event{Type: EventLotFull, Camera: "yard"})
+	}
+	close(w.released)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow client is not disconnected")
+	}
+
+	if w.writes > streamBuffer+2 {
+		t.Errorf("%d writes, more than the events", w.writes)
+	}
+
+	if len(s.bus.subs) != 1 {
+		t.Errorf("%d subscribers left, want only the event log", len(s.bus.subs))
+	}
+}
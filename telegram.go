@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramAPI is the Telegram Bot API, replaced by the target's url in tests.
+const telegramAPI = "https://api.telegram.org"
+
+// telegramNotifier sends photos and messages with the Telegram Bot API.
+type telegramNotifier struct {
+	api      string
+	chatID   int64
+	threadID int64
+}
+
+func newTelegramNotifier(t *Target) (*telegramNotifier, error) {
+	if t.Token == "" || t.ChatID == 0 {
+		return nil, errors.New("token and chat_id are required")
+	}
+
+	api := telegramAPI
+	if t.URL != "" {
+		api = t.URL
+	}
+
+	return &telegramNotifier{api: api + "/bot" + t.Token, chatID: t.ChatID, threadID: t.ThreadID}, nil
+}
+
+// telegramError is an error returned by the Bot API.
+type telegramError struct {
+	Status      string
+	Code        int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+func (te *telegramError) Error() string {
+	return fmt.Sprintf("bad status: %s, %s", te.Status, te.Description)
+}
+
+func (tn *telegramNotifier) Notify(n *Notification) error {
+	if n.Image == nil {
+		return tn.sendMessage(n.Text)
+	}
+
+	_, err := tn.sendPhoto(n.Image, n.Text)
+
+	return err
+}
+
+// sendPhoto sends a photo and returns the id of the new message.
+func (tn *telegramNotifier) sendPhoto(img image.Image, caption string) (int64, error) {
+	values := url.Values{}
+	values.Set("chat_id", strconv.FormatInt(tn.chatID, 10))
+	if tn.threadID != 0 {
+		values.Set("message_thread_id", strconv.FormatInt(tn.threadID, 10))
+	}
+
+	if caption != "" {
+		values.Set("caption", caption)
+	}
+
+	resp, err := upload(tn.api+"/sendPhoto?"+values.Encode(), img)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var sent struct {
+		Result struct {
+			MessageID int64 `json:"message_id"`
+		} `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		return 0, err
+	}
+
+	return sent.Result.MessageID, nil
+}
+
+// pin pins a message without notifying the chat.
+func (tn *telegramNotifier) pin(messageID int64) error {
+	values := url.Values{}
+	values.Set("chat_id", strconv.FormatInt(tn.chatID, 10))
+	values.Set("message_id", strconv.FormatInt(messageID, 10))
+	values.Set("disable_notification", "true")
+
+	resp, err := notifyClient.PostForm(tn.api+"/pinChatMessage", values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkTelegramResponse(resp)
+}
+
+// checkTelegramResponse returns a *telegramError for failed calls.
+func checkTelegramResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	te := &telegramError{Status: resp.Status}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err := json.Unmarshal(body, te); err != nil || te.Description == "" {
+		te.Description = strings.TrimSpace(string(body))
+	}
+
+	return te
+}
+
+// update replaces the photo and caption of a message sent before.
+func (tn *telegramNotifier) update(n *Notification, messageID int64) error {
+	media, err := json.Marshal(map[string]string{
+		"type":    "photo",
+		"media":   "attach://photo",
+		"caption": n.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	replyMarkup, err := json.Marshal(map[string]any{
+		"inline_keyboard": [][]map[string]string{
+			{{"text": "Update 🤓", "callback_data": "/camera_update"}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	values := url.Values{}
+	values.Set("chat_id", strconv.FormatInt(tn.chatID, 10))
+	values.Set("message_id", strconv.FormatInt(messageID, 10))
+	values.Set("message_thread_id", strconv.FormatInt(tn.threadID, 10))
+	values.Set("disable_notification", "true")
+	values.Set("media", string(media))
+	values.Set("reply_markup", string(replyMarkup))
+
+	resp, err := upload(tn.api+"/editMessageMedia?"+values.Encode(), n.Image)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.ReadAll(resp.Body)
+
+	return err
+}
+
+func upload(apiURL string, img image.Image) (*http.Response, error) {
+	req, err := http.NewRequest("POST", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	multipartWriter := multipart.NewWriter(&b)
+	fileWriter, err := multipartWriter.CreateFormFile("photo", "photo.jpg")
+	if err != nil {
+		return nil, err
+	}
+	err = jpeg.Encode(fileWriter, img, nil)
+	if err != nil {
+		return nil, err
+	}
+	multipartWriter.Close()
+
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	req.Body = io.NopCloser(&b)
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTelegramResponse(resp); err != nil {
+		resp.Body.Close()
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (tn *telegramNotifier) sendMessage(text string) error {
+	values := url.Values{}
+	values.Set("chat_id", strconv.FormatInt(tn.chatID, 10))
+	values.Set("text", text)
+	if tn.threadID != 0 {
+		values.Set("message_thread_id", strconv.FormatInt(tn.threadID, 10))
+	}
+
+	resp, err := notifyClient.PostForm(tn.api+"/sendMessage", values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkTelegramResponse(resp)
+}
+
+// telegramUpdate is an incoming update of the Bot API; only messages are
+// asked for.
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// getUpdates long polls for updates from offset on, waiting up to timeout
+// for new ones.
+func (tn *telegramNotifier) getUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]telegramUpdate, error) {
+	values := url.Values{}
+	values.Set("offset", strconv.FormatInt(offset, 10))
+	values.Set("timeout", strconv.Itoa(int(timeout.Seconds())))
+	values.Set("allowed_updates", `["message"]`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tn.api+"/getUpdates", strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkTelegramResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var updates struct {
+		Result []telegramUpdate `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&updates); err != nil {
+		return nil, err
+	}
+
+	return updates.Result, nil
+}
+
+// notifyTargets sends events as text messages to the camera's target. Spot
+// alerts go to the alerts target when one is configured; plain status
+// changes are too frequent for a chat and are not sent.
+func (s *server) notifyTargets(ev Event) {
+	if ev.Type == EventSpotChanged || ev.Type == EventFrameAnalyzed {
+		return
+	}
+
+	name := ""
+	if camera, ok := s.settings.Cameras[ev.Camera]; ok {
+		name = camera.Target
+	}
+
+	if strings.HasPrefix(ev.Type, "spot.") && s.settings.Alerts.Target != "" {
+		name = s.settings.Alerts.Target
+	}
+
+	if name == "" {
+		return
+	}
+
+	target := s.settings.Targets[name]
+
+	go func() {
+		if err := target.notify(&Notification{Event: ev, Text: ev.Message}); err != nil {
+			fmt.Printf("could not send %s event to %s: %s\n", ev.Type, name, err)
+		}
+	}()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// readPhoto reads the multipart photo upload of r.
+func readPhoto(t *testing.T, r notifyRequest, field string) *multipart.Part {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr := multipart.NewReader(strings.NewReader(string(r.body)), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("no %s part: %v", field, err)
+		}
+
+		if part.FormName() == field {
+			return part
+		}
+	}
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	api, apiURL := newNotifyReceiver(t, http.StatusOK, `{"ok": true, "result": {"message_id": 42}}`)
+	target := newTestTarget(t, &Target{Token: "123:abc", ChatID: -100, ThreadID: 7, URL: apiURL + "/", Templates: map[string]string{
+		"lot.*": "🅿️ {{.Camera}}: {{.Text}}",
+	}})
+
+	if err := target.notify(&Notification{Event: Event{Type: EventLotFull, Camera: "yard"}, Text: "no free spots"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := api.next(t)
+	form, _ := url.ParseQuery(string(r.body))
+	if r.URL.Path != "/bot123:abc/sendMessage" || form.Get("chat_id") != "-100" || form.Get("message_thread_id") != "7" ||
+		form.Get("text") != "🅿️ yard: no free spots" {
+		t.Errorf("sendMessage %s: %v", r.URL.Path, form)
+	}
+
+	// a photo with the caption in the query
+	if err := target.notify(&Notification{Event: Event{Type: EventFrameAnalyzed}, Text: "2 free", Image: notifyImage()}); err != nil {
+		t.Fatal(err)
+	}
+
+	r = api.next(t)
+	if r.URL.Path != "/bot123:abc/sendPhoto" || r.URL.Query().Get("caption") != "2 free" || r.URL.Query().Get("chat_id") != "-100" {
+		t.Errorf("sendPhoto %s", r.URL)
+	}
+
+	if cfg, err := jpeg.DecodeConfig(readPhoto(t, r, "photo")); err != nil || cfg.Width != 8 {
+		t.Errorf("photo: %+v, %v", cfg, err)
+	}
+
+	id, err := target.notifier.(*telegramNotifier).sendPhoto(notifyImage(), "")
+	if err != nil || id != 42 {
+		t.Errorf("message id %d, %v, want 42", id, err)
+	}
+
+	if r := api.next(t); r.URL.Query().Has("caption") || !r.URL.Query().Has("message_thread_id") {
+		t.Errorf("sendPhoto without caption %s", r.URL)
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	_, apiURL := newNotifyReceiver(t, http.StatusBadRequest, `{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}`)
+	target := newTestTarget(t, &Target{Token: "123:abc", ChatID: -100, URL: apiURL})
+
+	for _, n := range []*Notification{
+		{Text: "text"},
+		{Text: "photo", Image: notifyImage()},
+	} {
+		var te *telegramError
+		if err := target.notify(n); !errors.As(err, &te) || te.Code != 400 || te.Description != "Bad Request: chat not found" {
+			t.Errorf("%s: %v, want the API error", n.Text, err)
+		}
+	}
+
+	// errors that are not JSON keep the body
+	_, apiURL = newNotifyReceiver(t, http.StatusBadGateway, "upstream is down")
+	target = newTestTarget(t, &Target{Token: "123:abc", ChatID: -100, URL: apiURL})
+
+	if err := target.notify(&Notification{Text: "text"}); err == nil || !strings.Contains(err.Error(), "upstream is down") {
+		t.Errorf("502: %v", err)
+	}
+}
@@ -0,0 +1,21 @@
+#!/bin/sh
+# fake-ffmpeg stands in for an ffmpeg image2pipe pipeline: it writes the
+# images given as arguments to stdout over and over, one every
+# FAKE_FFMPEG_INTERVAL seconds (1 by default). With FAKE_FFMPEG_COUNT set it
+# exits after writing that many frames, like a camera stream that drops.
+#
+#	"command": ["testdata/fake-ffmpeg.sh", "frame.jpg"]
+
+n=0
+while :; do
+	for f in "$@"; do
+		cat "$f" || exit 1
+
+		n=$((n + 1))
+		if [ -n "$FAKE_FFMPEG_COUNT" ] && [ "$n" -ge "$FAKE_FFMPEG_COUNT" ]; then
+			exit 0
+		fi
+
+		sleep "${FAKE_FFMPEG_INTERVAL:-1}"
+	done
+done
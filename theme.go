@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// Label parts.
+const (
+	LabelID         = "id"
+	LabelName       = "name"
+	LabelPercentage = "percentage"
+	LabelDwell      = "dwell"
+)
+
+// HexColor is a color written in settings as "#rrggbb" or "#rrggbbaa";
+// "none" means the element is not drawn.
+type HexColor struct {
+	color.NRGBA
+
+	set bool
+}
+
+func (c *HexColor) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	c.set = true
+	if v == "none" || v == "" {
+		c.NRGBA = color.NRGBA{}
+
+		return nil
+	}
+
+	hex := strings.TrimPrefix(v, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return fmt.Errorf("bad color %q, want #rrggbb or #rrggbbaa", v)
+	}
+
+	c.NRGBA = color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}
+
+	return nil
+}
+
+func (c HexColor) MarshalJSON() ([]byte, error) {
+	if c.A == 0 {
+		return json.Marshal("none")
+	}
+
+	return json.Marshal(fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A))
+}
+
+// visible reports whether something should be drawn in this color.
+func (c HexColor) visible() bool {
+	return c.A > 0
+}
+
+func (c *HexColor) fallback(def color.NRGBA) {
+	if !c.set {
+		c.NRGBA = def
+		c.set = true
+	}
+}
+
+// withAlpha returns the color with its opacity scaled by alpha.
+func (c HexColor) withAlpha(alpha float64) color.NRGBA {
+	n := c.NRGBA
+	n.A = uint8(float64(n.A) * alpha)
+
+	return n
+}
+
+// ThemeColors are the spot outline colors. Reserved is used for free spots
+// with a rule; low confidence and violation win over the status color.
+type ThemeColors struct {
+	Free          HexColor `json:"free"`
+	Occupied      HexColor `json:"occupied"`
+	Unknown       HexColor `json:"unknown"`
+	Reserved      HexColor `json:"reserved"`
+	OutOfService  HexColor `json:"out_of_service"`
+	LowConfidence HexColor `json:"low_confidence"`
+	Violation     HexColor `json:"violation"`
+}
+
+// Theme is how analysis results are drawn. The defaults give the classic
+// look: green outlines around free spots and the percentage of spots where
+// edges were found.
+type Theme struct {
+	Colors ThemeColors `json:"colors"`
+
+	// Fill is the opacity, 0-1, of the spot fill in its outline color.
+	Fill      float64 `json:"fill"`
+	LineWidth float64 `json:"line_width"`
+
+	// Label lists the parts of the spot labels: id, name, percentage and
+	// dwell. The percentage of a spot without any edges is left out.
+	Label []string `json:"label"`
+
+	// Font is a TTF file, e.g. with Cyrillic glyphs for spot names.
+	Font     string  `json:"font"`
+	FontSize float64 `json:"font_size"`
+
+	// Timestamp and Banner add the frame time and a "12/40 free" summary
+	// in the top right corner.
+	Timestamp bool `json:"timestamp"`
+	Banner    bool `json:"banner"`
+
+	font *truetype.Font
+}
+
+func (t *Theme) init() error {
+	t.Colors.Free.fallback(color.NRGBA{0, 255, 0, 255})
+	t.Colors.Occupied.fallback(color.NRGBA{})
+	t.Colors.Unknown.fallback(color.NRGBA{})
+	t.Colors.Reserved.fallback(color.NRGBA{})
+	t.Colors.OutOfService.fallback(color.NRGBA{})
+	t.Colors.LowConfidence.fallback(color.NRGBA{255, 200, 0, 255})
+	t.Colors.Violation.fallback(color.NRGBA{255, 0, 0, 255})
+
+	if t.Fill < 0 || t.Fill > 1 {
+		return fmt.Errorf("fill must be within 0-1, got %g", t.Fill)
+	}
+
+	if t.LineWidth <= 0 {
+		t.LineWidth = 5
+	}
+
+	if t.Label == nil {
+		t.Label = []string{LabelPercentage}
+	}
+
+	for _, part := range t.Label {
+		switch part {
+		case LabelID, LabelName, LabelPercentage, LabelDwell:
+		default:
+			return fmt.Errorf("unknown label part %q", part)
+		}
+	}
+
+	if t.FontSize <= 0 {
+		t.FontSize = 18
+	}
+
+	data := goregular.TTF
+	if t.Font != "" {
+		var err error
+		if data, err = os.ReadFile(t.Font); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if t.font, err = truetype.Parse(data); err != nil {
+		return fmt.Errorf("font %s: %w", t.Font, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHexColor(t *testing.T) {
+	tests := []struct {
+		json string
+		want color.NRGBA
+	}{
+		{`"#00ff00"`, color.NRGBA{0, 255, 0, 255}},
+		{`"#FF000080"`, color.NRGBA{255, 0, 0, 128}},
+		{`"123456"`, color.NRGBA{0x12, 0x34, 0x56, 255}},
+		{`"none"`, color.NRGBA{}},
+		{`""`, color.NRGBA{}},
+	}
+
+	for _, tt := range tests {
+		var c HexColor
+		if err := json.Unmarshal([]byte(tt.json), &c); err != nil || c.NRGBA != tt.want || !c.set {
+			t.Errorf("%s: %v, %v", tt.json, c.NRGBA, err)
+		}
+
+		// a color set to none is not replaced by the default
+		c.fallback(color.NRGBA{1, 2, 3, 4})
+		if c.NRGBA != tt.want {
+			t.Errorf("%s: fallback replaced it with %v", tt.json, c.NRGBA)
+		}
+	}
+
+	for _, bad := range []string{`"#fff"`, `"#00ff00f"`, `"#gggggg"`, `"green"`, `"#00ff00ff00"`, `255`} {
+		var c HexColor
+		if err := json.Unmarshal([]byte(bad), &c); err == nil {
+			t.Errorf("%s was accepted as %v", bad, c.NRGBA)
+		}
+	}
+
+	for _, tt := range []struct {
+		c    HexColor
+		want string
+	}{
+		{HexColor{NRGBA: color.NRGBA{0, 255, 0, 255}}, `"#00ff00ff"`},
+		{HexColor{NRGBA: color.NRGBA{255, 255, 255, 0}}, `"none"`},
+	} {
+		if b, err := json.Marshal(tt.c); err != nil || string(b) != tt.want {
+			t.Errorf("%v: %s, %v", tt.c.NRGBA, b, err)
+		}
+	}
+
+	var unset HexColor
+	unset.fallback(color.NRGBA{1, 2, 3, 4})
+	if unset.NRGBA != (color.NRGBA{1, 2, 3, 4}) || !unset.visible() {
+		t.Errorf("fallback %v", unset.NRGBA)
+	}
+
+	if c := (HexColor{NRGBA: color.NRGBA{10, 20, 30, 200}}).withAlpha(0.5); c != (color.NRGBA{10, 20, 30, 100}) {
+		t.Errorf("with alpha %v", c)
+	}
+}
+
+func TestThemeInit(t *testing.T) {
+	theme := &Theme{}
+	if err := theme.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if theme.Colors.Free.NRGBA != (color.NRGBA{0, 255, 0, 255}) || theme.Colors.Occupied.visible() || theme.LineWidth != 5 ||
+		theme.FontSize != 18 || len(theme.Label) != 1 || theme.Label[0] != LabelPercentage || theme.font == nil {
+		t.Errorf("defaults %+v", theme)
+	}
+
+	// an empty label list is kept
+	if theme := (&Theme{Label: []string{}}); theme.init() != nil || len(theme.Label) != 0 {
+		t.Errorf("label %v", theme.Label)
+	}
+
+	notFont := filepath.Join(t.TempDir(), "font.ttf")
+	if err := os.WriteFile(notFont, []byte("not a font"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []*Theme{
+		{Fill: -0.1},
+		{Fill: 1.5},
+		{Label: []string{LabelID, "plate"}},
+		{Font: filepath.Join(t.TempDir(), "missing.ttf")},
+		{Font: notFont},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Time-lapse formats.
+const (
+	TimelapseGIF   = "gif"
+	TimelapseZIP   = "zip"
+	TimelapseMJPEG = "mjpeg"
+)
+
+// maxTimelapseFrames limits the frames of a time-lapse requested over HTTP.
+// ZIP and MJPEG are streamed frame by frame, a GIF is held in memory until
+// all frames are quantized, so it gets a lower limit and a smaller width.
+const (
+	maxTimelapseFrames    = 2000
+	maxTimelapseGIFFrames = 200
+	maxTimelapseGIFWidth  = 1024
+)
+
+// TimelapseOptions select the frames and the output of a time-lapse.
+type TimelapseOptions struct {
+	From, To time.Time
+	Format   string
+
+	// Skip keeps every Skip-th archived frame.
+	Skip int
+
+	// Width of the output; the height keeps the aspect ratio. Zero keeps
+	// the archived size, frames are never scaled up.
+	Width int
+
+	// Delay between GIF frames.
+	Delay time.Duration
+}
+
+func (o *TimelapseOptions) init() error {
+	switch o.Format {
+	case "":
+		o.Format = TimelapseGIF
+	case TimelapseGIF, TimelapseZIP, TimelapseMJPEG:
+	default:
+		return fmt.Errorf("unknown format %q", o.Format)
+	}
+
+	if o.Skip < 1 {
+		o.Skip = 1
+	}
+
+	if o.Width < 0 {
+		return errors.New("width must not be negative")
+	}
+
+	if o.Format == TimelapseGIF {
+		// full size GIFs get huge and slow to quantize
+		if o.Width == 0 {
+			o.Width = 640
+		}
+
+		o.Width = min(o.Width, maxTimelapseGIFWidth)
+	}
+
+	if o.Delay <= 0 {
+		o.Delay = 200 * time.Millisecond
+	}
+
+	if !o.From.Before(o.To) {
+		return errors.New("from must be before to")
+	}
+
+	return nil
+}
+
+func (o *TimelapseOptions) contentType() string {
+	switch o.Format {
+	case TimelapseZIP:
+		return "application/zip"
+	case TimelapseMJPEG:
+		return "video/x-motion-jpeg"
+	}
+
+	return "image/gif"
+}
+
+// selectFrames keeps every skip-th frame.
+func selectFrames(frames []*ArchivedFrame, skip int) []*ArchivedFrame {
+	var selected []*ArchivedFrame
+	for i := 0; i < len(frames); i += skip {
+		selected = append(selected, frames[i])
+	}
+
+	return selected
+}
+
+// timelapseFrame loads an archived frame, scales it and overlays its time
+// and free count.
+func timelapseFrame(frame *ArchivedFrame, width int, font *truetype.Font) (*image.RGBA, error) {
+	f, err := os.Open(frame.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	src, err := jpeg.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", frame.path, err)
+	}
+
+	b := src.Bounds()
+	size := image.Rect(0, 0, b.Dx(), b.Dy())
+	if width > 0 && width < b.Dx() {
+		size = image.Rect(0, 0, width, b.Dy()*width/b.Dx())
+	}
+
+	dst := image.NewRGBA(size)
+	xdraw.ApproxBiLinear.Scale(dst, size, src, b, xdraw.Src, nil)
+
+	imgGG := gg.NewContextForRGBA(dst)
+	fontSize := max(float64(size.Dx())/40, 10)
+	imgGG.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: fontSize}))
+
+	text := fmt.Sprintf("%s   %d/%d free", frame.Time.Local().Format("2006-01-02 15:04:05"), frame.Free, frame.Total)
+	w, h := imgGG.MeasureString(text)
+
+	imgGG.SetColor(color.RGBA{0, 0, 0, 170})
+	imgGG.DrawRectangle(0, float64(size.Dy())-h*2, w+h*2, h*2)
+	imgGG.Fill()
+
+	imgGG.SetColor(color.White)
+	imgGG.DrawStringAnchored(text, h, float64(size.Dy())-h, 0, 0.35)
+
+	return dst, nil
+}
+
+// writeTimelapse encodes frames to w in the format of the options, with the
+// captions in font.
+func writeTimelapse(w io.Writer, frames []*ArchivedFrame, o *TimelapseOptions, font *truetype.Font) error {
+	var anim gif.GIF
+	var zw *zip.Writer
+	if o.Format == TimelapseZIP {
+		zw = zip.NewWriter(w)
+	}
+
+	for i, frame := range frames {
+		img, err := timelapseFrame(frame, o.Width, font)
+		if err != nil {
+			return err
+		}
+
+		switch o.Format {
+		case TimelapseGIF:
+			paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+			xdraw.FloydSteinberg.Draw(paletted, img.Bounds(), img, image.Point{})
+
+			anim.Image = append(anim.Image, paletted)
+			anim.Delay = append(anim.Delay, int(o.Delay/(10*time.Millisecond)))
+		case TimelapseZIP:
+			fw, err := zw.Create(fmt.Sprintf("%05d_%s.jpg", i+1, frame.Time.Local().Format("20060102_150405")))
+			if err != nil {
+				return err
+			}
+
+			if err := jpeg.Encode(fw, img, &jpeg.Options{Quality: 85}); err != nil {
+				return err
+			}
+		case TimelapseMJPEG:
+			// concatenated JPEGs, which ffmpeg and VLC play as MJPEG
+			if err := jpeg.Encode(w, img, &jpeg.Options{Quality: 85}); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch o.Format {
+	case TimelapseGIF:
+		if len(anim.Image) == 0 {
+			return errors.New("no frames")
+		}
+
+		return gif.EncodeAll(w, &anim)
+	case TimelapseZIP:
+		return zw.Close()
+	}
+
+	return nil
+}
+
+// getTimelapse streams a time-lapse of archived frames between ?from= and
+// ?to= as ?format=gif|zip|mjpeg, keeping every ?skip=-th frame scaled to
+// ?width=.
+func (s *server) getTimelapse(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.settings.Cameras[id]; !ok {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if s.archive == nil {
+		http.Error(w, "archive is not configured", http.StatusNotFound)
+
+		return
+	}
+
+	o := &TimelapseOptions{Format: r.FormValue("format")}
+
+	var err error
+	if o.From, o.To, err = heatmapPeriod(r.FormValue("period"), r.FormValue("from"), r.FormValue("to"), time.Now()); err == nil {
+		o.Skip, err = formInt(r, "skip")
+	}
+
+	if err == nil {
+		o.Width, err = formInt(r, "width")
+	}
+
+	if err == nil && r.FormValue("delay") != "" {
+		o.Delay, err = time.ParseDuration(r.FormValue("delay"))
+	}
+
+	if err == nil {
+		err = o.init()
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	frames, err := s.archive.Frames(id, o.From, o.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	frames = selectFrames(frames, o.Skip)
+	if len(frames) == 0 {
+		http.Error(w, "no archived frames in range", http.StatusNotFound)
+
+		return
+	}
+
+	limit := maxTimelapseFrames
+	if o.Format == TimelapseGIF {
+		limit = maxTimelapseGIFFrames
+	}
+
+	if len(frames) > limit {
+		http.Error(w, fmt.Sprintf("%d frames, at most %d allowed for %s, raise skip", len(frames), limit, o.Format), http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", o.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.%s", id, o.From.Local().Format("20060102-1504"), o.Format)))
+
+	if err := writeTimelapse(w, frames, o, s.settings.Theme.font); err != nil {
+		fmt.Printf("could not write time-lapse of %s: %s\n", id, err)
+	}
+}
+
+func formInt(r *http.Request, name string) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", name, v)
+	}
+
+	return n, nil
+}
+
+// timelapseCommand is the "timelapse" subcommand, which writes a time-lapse
+// from the archive configured in the settings file to a file.
+func timelapseCommand(settings *Settings, args []string) error {
+	fs := flag.NewFlagSet("timelapse", flag.ContinueOnError)
+
+	camera := fs.String("camera", "", "camera id")
+	from := fs.String("from", "", "start, RFC 3339 or YYYY-MM-DD")
+	to := fs.String("to", "", "end, RFC 3339 or YYYY-MM-DD; default now")
+	period := fs.String("period", "day", "day, week or month ending now, when -from is not set")
+	out := fs.String("o", "", "output file; default <camera>.<format>")
+
+	o := &TimelapseOptions{}
+	fs.StringVar(&o.Format, "format", TimelapseGIF, "gif, zip or mjpeg")
+	fs.IntVar(&o.Skip, "skip", 1, "keep every n-th frame")
+	fs.IntVar(&o.Width, "width", 0, "output width, at most the archived size; 0 keeps it (640 for gif, at most 1024)")
+	fs.DurationVar(&o.Delay, "delay", 200*time.Millisecond, "delay between gif frames")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if settings.Archive.Dir == "" {
+		return errors.New("archive is not configured")
+	}
+
+	if *camera == "" {
+		return errors.New("-camera is required")
+	}
+
+	var err error
+	if o.From, o.To, err = heatmapPeriod(*period, *from, *to, time.Now()); err != nil {
+		return err
+	}
+
+	if err := o.init(); err != nil {
+		return err
+	}
+
+	frames, err := NewArchive(settings.Archive).Frames(*camera, o.From, o.To)
+	if err != nil {
+		return err
+	}
+
+	frames = selectFrames(frames, o.Skip)
+	if len(frames) == 0 {
+		return errors.New("no archived frames in range")
+	}
+
+	if *out == "" {
+		*out = *camera + "." + o.Format
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTimelapse(f, frames, o, settings.Theme.font); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	fmt.Printf("wrote %d frames to %s\n", len(frames), *out)
+
+	return f.Close()
+}
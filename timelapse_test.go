@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timelapseServer returns a server whose camera "yard" has n archived 64x48
+// frames, one a second during the last hour.
+func timelapseServer(t *testing.T, n int) *server {
+	t.Helper()
+
+	s := &server{
+		settings: &Settings{Cameras: map[string]*Camera{"yard": {}}},
+		archive:  NewArchive(ArchiveSettings{Dir: t.TempDir()}),
+	}
+
+	if err := s.settings.Theme.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	for i := range n {
+		a := &Analysis{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Image:  image.NewRGBA(image.Rect(0, 0, 64, 48)),
+			Counts: ZoneCount{Free: i % 3, Total: 3},
+		}
+
+		if err := s.archive.Store("yard", a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return s
+}
+
+func getTimelapse(s *server, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/cameras/yard/timelapse?"+query, nil)
+	r.SetPathValue("id", "yard")
+
+	w := httptest.NewRecorder()
+	s.getTimelapse(w, r)
+
+	return w
+}
+
+func TestTimelapseFrameWidth(t *testing.T) {
+	s := timelapseServer(t, 1)
+
+	frames, err := s.archive.Frames("yard", time.Now().Add(-2*time.Hour), time.Now())
+	if err != nil || len(frames) != 1 {
+		t.Fatalf("frames = %v, %v", frames, err)
+	}
+
+	for width, want := range map[int]image.Rectangle{
+		0:    image.Rect(0, 0, 64, 48),
+		32:   image.Rect(0, 0, 32, 24),
+		64:   image.Rect(0, 0, 64, 48),
+		5000: image.Rect(0, 0, 64, 48),
+	} {
+		img, err := timelapseFrame(frames[0], width, s.settings.Theme.font)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.Bounds() != want {
+			t.Errorf("width %d: bounds %v, want %v", width, img.Bounds(), want)
+		}
+	}
+}
+
+func TestTimelapseOptionsWidth(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		format string
+		width  int
+		want   int
+	}{
+		{TimelapseGIF, 0, 640},
+		{TimelapseGIF, 320, 320},
+		{TimelapseGIF, 4000, maxTimelapseGIFWidth},
+		{TimelapseZIP, 0, 0},
+		{TimelapseMJPEG, 4000, 4000},
+	}
+
+	for _, tt := range tests {
+		o := &TimelapseOptions{Format: tt.format, Width: tt.width, From: now.Add(-time.Hour), To: now}
+		if err := o.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if o.Width != tt.want {
+			t.Errorf("%s width %d: %d, want %d", tt.format, tt.width, o.Width, tt.want)
+		}
+	}
+}
+
+func TestGetTimelapse(t *testing.T) {
+	s := timelapseServer(t, maxTimelapseGIFFrames+1)
+
+	w := getTimelapse(s, "format=gif")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), fmt.Sprint(maxTimelapseGIFFrames)) {
+		t.Errorf("too many gif frames: status %d: %s", w.Code, w.Body)
+	}
+
+	w = getTimelapse(s, "format=gif&skip=2&width=5000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("gif: status %d: %s", w.Code, w.Body)
+	}
+
+	anim, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(anim.Image) != maxTimelapseGIFFrames/2+1 || anim.Config.Width != 64 {
+		t.Errorf("gif: %d frames %d wide, want %d frames of the archived width", len(anim.Image), anim.Config.Width, maxTimelapseGIFFrames/2+1)
+	}
+
+	// the streamed formats take more frames
+	w = getTimelapse(s, "format=zip&width=5000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("zip: status %d: %s", w.Code, w.Body)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zr.File) != maxTimelapseGIFFrames+1 {
+		t.Errorf("zip: %d frames, want %d", len(zr.File), maxTimelapseGIFFrames+1)
+	}
+
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if cfg, err := jpeg.DecodeConfig(f); err != nil || cfg.Width != 64 {
+		t.Errorf("zip frame: width %d, %v, want the archived width", cfg.Width, err)
+	}
+
+	if w := getTimelapse(s, "width=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("negative width: status %d", w.Code)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// What to do with a watched file once it has been analyzed.
+const (
+	AfterDelete  = "delete"
+	AfterMove    = "move"
+	AfterArchive = "archive"
+)
+
+const defaultWatchPattern = `(?i)\.(jpe?g|png|gif|bmp|tiff?|webp)$`
+
+// WatchSettings configures a drop folder, e.g. the FTP upload directory of
+// cameras that can only push snapshots.
+type WatchSettings struct {
+	Dir string `json:"dir"`
+
+	// Pattern selects the files to process. The named groups "camera" and
+	// "time" take the camera id and the frame time from the file name.
+	Pattern    string `json:"pattern"`
+	TimeLayout string `json:"time_layout"`
+	Camera     string `json:"camera"`
+
+	After      string `json:"after"`
+	MoveDir    string `json:"move_dir"`
+	ArchiveDir string `json:"archive_dir"`
+	FailedDir  string `json:"failed_dir"`
+
+	// Settle is how long size and modification time must stay unchanged
+	// before a file is considered completely written.
+	Settle       Duration `json:"settle"`
+	PollInterval Duration `json:"poll_interval"`
+
+	re *regexp.Regexp
+}
+
+func (ws *WatchSettings) init() error {
+	if ws.Dir == "" {
+		return errors.New("dir is required")
+	}
+
+	if ws.Pattern == "" {
+		ws.Pattern = defaultWatchPattern
+	}
+
+	var err error
+	if ws.re, err = regexp.Compile(ws.Pattern); err != nil {
+		return err
+	}
+
+	if ws.re.SubexpIndex("time") >= 0 && ws.TimeLayout == "" {
+		return errors.New("time_layout is required when pattern has a time group")
+	}
+
+	switch ws.After {
+	case "":
+		ws.After = AfterArchive
+	case AfterDelete, AfterMove, AfterArchive:
+	default:
+		return fmt.Errorf("unknown after action %q", ws.After)
+	}
+
+	if ws.After == AfterMove && ws.MoveDir == "" {
+		return errors.New("move_dir is required")
+	}
+
+	if ws.ArchiveDir == "" {
+		ws.ArchiveDir = filepath.Join(ws.Dir, "archive")
+	}
+
+	if ws.FailedDir == "" {
+		ws.FailedDir = filepath.Join(ws.Dir, "failed")
+	}
+
+	if ws.Settle <= 0 {
+		ws.Settle = Duration(2 * time.Second)
+	}
+
+	if ws.PollInterval <= 0 {
+		ws.PollInterval = Duration(10 * time.Second)
+	}
+
+	return nil
+}
+
+// pendingFile is a file seen in the folder that may still be written to, or
+// one submitted and not yet disposed of.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+
+	submitted bool
+}
+
+// runWatch processes files dropped into ws.Dir. New files are noticed
+// through inotify where available; the folder is also rescanned every poll
+// interval, which is the only mechanism elsewhere. Settled files are
+// submitted to the queue right away, so they are analyzed by all its
+// workers.
+func (s *server) runWatch(ctx context.Context, ws *WatchSettings) {
+	notify, err := watchDir(ctx, ws.Dir)
+	if err != nil {
+		fmt.Printf("watch %s: %s, falling back to polling every %s\n", ws.Dir, err, time.Duration(ws.PollInterval))
+	}
+
+	pending := map[string]*pendingFile{}
+	finished := make(chan string)
+
+	poll := time.NewTicker(time.Duration(ws.PollInterval))
+	defer poll.Stop()
+
+	settle := time.NewTicker(time.Duration(ws.Settle) / 2)
+	defer settle.Stop()
+
+	s.scanDir(ws, pending)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case name, ok := <-notify:
+			if !ok {
+				// inotify failed, keep going with polling only
+				notify = nil
+
+				continue
+			}
+
+			if ws.re.MatchString(name) {
+				if _, ok := pending[name]; !ok {
+					pending[name] = &pendingFile{}
+				}
+			}
+		case <-poll.C:
+			s.scanDir(ws, pending)
+		case name := <-finished:
+			// the file is gone or left for the next scan
+			delete(pending, name)
+		case <-settle.C:
+			for name, p := range pending {
+				if p.submitted {
+					continue
+				}
+
+				info, err := os.Stat(filepath.Join(ws.Dir, name))
+				if err != nil {
+					// gone before it could be processed
+					delete(pending, name)
+
+					continue
+				}
+
+				if !p.settled(info, time.Duration(ws.Settle)) {
+					continue
+				}
+
+				job := s.submitWatched(ws, name)
+				if job == nil {
+					delete(pending, name)
+
+					continue
+				}
+
+				p.submitted = true
+
+				go func() {
+					s.finishWatched(ws, name, job)
+
+					select {
+					case finished <- name:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		}
+	}
+}
+
+// scanDir adds the matching files of the folder to pending.
+func (s *server) scanDir(ws *WatchSettings, pending map[string]*pendingFile) {
+	entries, err := os.ReadDir(ws.Dir)
+	if err != nil {
+		fmt.Printf("watch %s: %s\n", ws.Dir, err)
+
+		return
+	}
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !ws.re.MatchString(e.Name()) {
+			continue
+		}
+
+		if _, ok := pending[e.Name()]; !ok {
+			pending[e.Name()] = &pendingFile{}
+		}
+	}
+}
+
+// settled reports whether the file has not changed for the settle time.
+func (p *pendingFile) settled(info os.FileInfo, settle time.Duration) bool {
+	if info.Size() != p.size || !info.ModTime().Equal(p.modTime) || p.since.IsZero() {
+		p.size = info.Size()
+		p.modTime = info.ModTime()
+		p.since = time.Now()
+
+		return false
+	}
+
+	return p.size > 0 && time.Since(p.since) >= settle
+}
+
+// submitWatched queues the job for a file. It returns nil when the file
+// was not submitted: files that can not be read or do not belong to a
+// camera are moved to the failed folder, and files that do not fit into the
+// queue are left for the next scan.
+func (s *server) submitWatched(ws *WatchSettings, name string) *Job {
+	path := filepath.Join(ws.Dir, name)
+
+	job, err := s.watchJob(ws, name)
+	if err == nil {
+		job.data, err = os.ReadFile(path)
+	}
+
+	if err == nil {
+		err = s.queue.Submit(job)
+	}
+
+	if err == nil {
+		return job
+	}
+
+	fmt.Printf("watch %s: %s: %s\n", ws.Dir, name, err)
+
+	if !errors.Is(err, errQueueFull) {
+		s.failWatched(ws, name)
+	}
+
+	return nil
+}
+
+// finishWatched waits for the job of a file and disposes of the file
+// according to the watch settings. Only files that could not be analyzed go
+// to the failed folder: a frame analyzed fine whose delivery failed is
+// stored in the history and archive like any other.
+func (s *server) finishWatched(ws *WatchSettings, name string, job *Job) {
+	<-job.done
+
+	done, _ := s.queue.Get(job.ID)
+
+	switch {
+	case done.Status == JobDone:
+	case done.Status == JobDropped:
+		// pushed out of a full queue, the next scan submits it again
+		fmt.Printf("watch %s: %s: job %s was dropped\n", ws.Dir, name, job.ID)
+
+		return
+	case done.Result != nil:
+		fmt.Printf("watch %s: %s: analyzed, but could not be delivered: %s\n", ws.Dir, name, done.Error)
+	default:
+		fmt.Printf("watch %s: %s: job %s %s: %s\n", ws.Dir, name, job.ID, done.Status, done.Error)
+		s.failWatched(ws, name)
+
+		return
+	}
+
+	path := filepath.Join(ws.Dir, name)
+
+	var err error
+	switch ws.After {
+	case AfterDelete:
+		err = os.Remove(path)
+	case AfterMove:
+		err = moveFile(path, filepath.Join(ws.MoveDir, name))
+	case AfterArchive:
+		err = moveFile(path, filepath.Join(ws.ArchiveDir, job.Camera, done.Result.Time.Format("2006-01-02"), name))
+	}
+
+	if err != nil {
+		fmt.Printf("watch %s: %s\n", ws.Dir, err)
+	}
+}
+
+// failWatched moves a file that could not be analyzed to the failed folder.
+func (s *server) failWatched(ws *WatchSettings, name string) {
+	if err := moveFile(filepath.Join(ws.Dir, name), filepath.Join(ws.FailedDir, name)); err != nil {
+		fmt.Printf("watch %s: %s\n", ws.Dir, err)
+	}
+}
+
+// watchJob builds the job for a file, taking camera and time from its name.
+func (s *server) watchJob(ws *WatchSettings, name string) (*Job, error) {
+	m := ws.re.FindStringSubmatch(name)
+	if m == nil {
+		return nil, errors.New("name does not match pattern")
+	}
+
+	job := &Job{Camera: ws.Camera}
+
+	if i := ws.re.SubexpIndex("camera"); i >= 0 && m[i] != "" {
+		job.Camera = m[i]
+	}
+
+	if i := ws.re.SubexpIndex("time"); i >= 0 && m[i] != "" {
+		t, err := time.ParseInLocation(ws.TimeLayout, m[i], time.Local)
+		if err != nil {
+			return nil, err
+		}
+
+		job.FrameTime = t
+	}
+
+	camera, ok := s.settings.Cameras[job.Camera]
+	if !ok {
+		return nil, fmt.Errorf("unknown camera %q", job.Camera)
+	}
+
+	job.Target = camera.Target
+	job.Day = !camera.Night
+
+	return job, nil
+}
+
+// moveFile renames src to dst, creating the destination folder. It falls back
+// to copying when they are on different file systems.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// watchDir reports names of files in dir that were closed after writing or
+// moved into it, using inotify.
+func watchDir(ctx context.Context, dir string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+
+		return nil, err
+	}
+
+	// a non-blocking descriptor wrapped in os.File goes through the runtime
+	// poller, so closing it on cancellation unblocks Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	names := make(chan string, 64)
+
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+
+	go func() {
+		defer close(names)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Printf("watch %s: inotify read: %s\n", dir, err)
+				}
+
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				offset = nameEnd
+
+				if event.Len == 0 || nameEnd > n {
+					continue
+				}
+
+				name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+
+				// the periodic rescan catches anything dropped here
+				select {
+				case names <- name:
+				default:
+				}
+			}
+		}
+	}()
+
+	return names, nil
+}
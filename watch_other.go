@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchDir is only implemented with inotify on Linux; elsewhere the folder is
+// polled.
+func watchDir(ctx context.Context, dir string) (<-chan string, error) {
+	return nil, errors.New("file notifications are not supported on this platform")
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchPattern(t *testing.T) {
+	ws := &WatchSettings{Dir: "/drop"}
+	if err := ws.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"a.jpg": true, "b.JPEG": true, "c.png": true, "d.webp": true, "e.tif": true,
+		"f.txt": false, "g.jpg.part": false, ".jpg.swp": false,
+	} {
+		if got := ws.re.MatchString(name); got != want {
+			t.Errorf("default pattern on %s: %v, want %v", name, got, want)
+		}
+	}
+
+	s := &server{settings: &Settings{Cameras: map[string]*Camera{"yard": {Target: "home", Night: true}}}}
+
+	ws = &WatchSettings{Dir: "/drop", Pattern: `^(?P<camera>[a-z]+)_(?P<time>\d{14})\.jpg$`, TimeLayout: "20060102150405"}
+	if err := ws.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := s.watchJob(ws, "yard_20240506070809.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local); job.Camera != "yard" || !job.FrameTime.Equal(want) || job.Target != "home" || job.Day {
+		t.Errorf("job %+v", job)
+	}
+
+	for _, name := range []string{"gate_20240506070809.jpg", "yard_20241306070809.jpg", "yard.jpg"} {
+		if _, err := s.watchJob(ws, name); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// without a camera group the configured camera is used
+	ws = &WatchSettings{Dir: "/drop", Camera: "yard"}
+	if err := ws.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := s.watchJob(ws, "snap.jpg"); err != nil || job.Camera != "yard" || !job.FrameTime.IsZero() {
+		t.Errorf("job %+v, %v", job, err)
+	}
+
+	for _, bad := range []*WatchSettings{
+		{},
+		{Dir: "/drop", Pattern: "(?P<time>\\d+)"},
+		{Dir: "/drop", Pattern: "("},
+		{Dir: "/drop", After: "shred"},
+		{Dir: "/drop", After: AfterMove},
+	} {
+		if err := bad.init(); err == nil {
+			t.Errorf("%+v was accepted", bad)
+		}
+	}
+}
+
+func TestWatchSettle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	settle := 50 * time.Millisecond
+
+	stat := func() os.FileInfo {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return info
+	}
+
+	// an empty file is never done
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pendingFile{}
+	p.settled(stat(), settle)
+	time.Sleep(settle)
+
+	if p.settled(stat(), settle) {
+		t.Error("an empty file settled")
+	}
+
+	if err := os.WriteFile(path, []byte("half"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.settled(stat(), settle) {
+		t.Error("settled right after a change")
+	}
+
+	if p.settled(stat(), settle) {
+		t.Error("settled before the settle time")
+	}
+
+	// still being written
+	time.Sleep(settle / 2)
+	if err := os.WriteFile(path, []byte("half and more"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(settle / 2)
+	if p.settled(stat(), settle) {
+		t.Error("settled while growing")
+	}
+
+	time.Sleep(settle)
+	if !p.settled(stat(), settle) {
+		t.Error("not settled after the settle time")
+	}
+}
+
+// watchServer runs a watch on a new folder with a queue that fails frames
+// holding "bad", analyzes but fails to deliver "undeliverable" ones and
+// analyzes all others.
+func watchServer(t *testing.T, ws *WatchSettings, workers int, handler func(*Job)) *WatchSettings {
+	t.Helper()
+
+	ws.Dir = t.TempDir()
+	ws.Camera = "yard"
+	ws.Settle = Duration(20 * time.Millisecond)
+	ws.PollInterval = Duration(20 * time.Millisecond)
+
+	if err := ws.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{settings: &Settings{Cameras: map[string]*Camera{"yard": {}}}}
+	s.queue = NewQueue(QueueSettings{Workers: workers}, func(job *Job) error {
+		if handler != nil {
+			handler(job)
+		}
+
+		if string(job.data) == "bad" {
+			return errors.New("not an image")
+		}
+
+		s.queue.mu.Lock()
+		job.Result = &Analysis{Time: time.Date(2024, 5, 6, 7, 0, 0, 0, time.Local)}
+		s.queue.mu.Unlock()
+
+		if string(job.data) == "undeliverable" {
+			return errors.New("target is down")
+		}
+
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go s.runWatch(ctx, ws)
+
+	return ws
+}
+
+// waitFile waits until path exists.
+func waitFile(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%s does not exist", path)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func dropFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWatchAfter(t *testing.T) {
+	files := map[string]string{"good.jpg": "good", "bad.jpg": "bad", "undeliverable.jpg": "undeliverable", "notes.txt": "skip"}
+
+	t.Run("archive", func(t *testing.T) {
+		ws := watchServer(t, &WatchSettings{}, 2, nil)
+		dropFiles(t, ws.Dir, files)
+
+		// a delivery error is not a bad image
+		for _, name := range []string{"good.jpg", "undeliverable.jpg"} {
+			waitFile(t, filepath.Join(ws.ArchiveDir, "yard", "2024-05-06", name))
+		}
+
+		waitFile(t, filepath.Join(ws.FailedDir, "bad.jpg"))
+		waitFile(t, filepath.Join(ws.Dir, "notes.txt"))
+
+		if _, err := os.Stat(filepath.Join(ws.FailedDir, "undeliverable.jpg")); err == nil {
+			t.Error("an analyzed frame was moved to the failed folder")
+		}
+	})
+
+	t.Run("move", func(t *testing.T) {
+		moveDir := filepath.Join(t.TempDir(), "done")
+		ws := watchServer(t, &WatchSettings{After: AfterMove, MoveDir: moveDir}, 2, nil)
+		dropFiles(t, ws.Dir, files)
+
+		waitFile(t, filepath.Join(moveDir, "good.jpg"))
+		waitFile(t, filepath.Join(moveDir, "undeliverable.jpg"))
+		waitFile(t, filepath.Join(ws.FailedDir, "bad.jpg"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		ws := watchServer(t, &WatchSettings{After: AfterDelete}, 2, nil)
+		dropFiles(t, ws.Dir, files)
+
+		waitFile(t, filepath.Join(ws.FailedDir, "bad.jpg"))
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			entries, _ := os.ReadDir(ws.Dir)
+
+			// notes.txt and the failed folder are left
+			if len(entries) == 2 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("%d entries left in the folder", len(entries))
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestWatchUsesAllWorkers(t *testing.T) {
+	var running, most atomic.Int32
+	both := make(chan struct{})
+
+	ws := watchServer(t, &WatchSettings{After: AfterDelete}, 2, func(*Job) {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		if n > most.Load() {
+			most.Store(n)
+		}
+
+		if n == 2 {
+			close(both)
+		}
+
+		// hold the first job until the second one runs too
+		select {
+		case <-both:
+		case <-time.After(2 * time.Second):
+		}
+	})
+
+	dropFiles(t, ws.Dir, map[string]string{"a.jpg": "a", "b.jpg": "b"})
+
+	select {
+	case <-both:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("files were processed one at a time, at most %d at once", most.Load())
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookQueueSize is the number of pending deliveries per webhook;
+	// events beyond it go straight to the dead letters.
+	webhookQueueSize = 256
+
+	// maxDeadLetters keeps the dead letter list from growing forever; the
+	// oldest entries are dropped first.
+	maxDeadLetters = 1000
+
+	maxWebhookBackoff = 5 * time.Minute
+)
+
+// WebhookSettings configures outbound webhooks. Deliveries that still fail
+// after all retries are kept in the DeadLetters file, or in memory only
+// when it is not set.
+type WebhookSettings struct {
+	Hooks       []*Webhook `json:"hooks"`
+	DeadLetters string     `json:"dead_letters"`
+}
+
+// Webhook POSTs events as JSON to URL. Events lists the event types to send,
+// e.g. "spot.changed" or "camera.*"; all events are sent when it is empty.
+type Webhook struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	SecretFile string   `json:"secret_file"`
+	Events     []string `json:"events"`
+
+	Timeout Duration `json:"timeout"`
+
+	// Retries is the number of retries after the first attempt, waiting
+	// Backoff before the first one and twice as long before every next.
+	// It is 5 when not set; 0 gives up after the first attempt.
+	Retries *int     `json:"retries"`
+	Backoff Duration `json:"backoff"`
+}
+
+func (ws *WebhookSettings) init() error {
+	names := map[string]bool{}
+	for i, w := range ws.Hooks {
+		if w.Name == "" {
+			w.Name = strconv.Itoa(i + 1)
+		}
+
+		if names[w.Name] {
+			return fmt.Errorf("duplicate webhook %s", w.Name)
+		}
+		names[w.Name] = true
+
+		if err := w.init(); err != nil {
+			return fmt.Errorf("webhook %s: %w", w.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (w *Webhook) init() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad url %q", w.URL)
+	}
+
+	if w.SecretFile != "" {
+		if w.Secret, err = readSecret(w.SecretFile); err != nil {
+			return err
+		}
+	}
+
+	if w.Timeout <= 0 {
+		w.Timeout = Duration(10 * time.Second)
+	}
+
+	if w.Retries == nil {
+		retries := 5
+		w.Retries = &retries
+	}
+
+	if *w.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+
+	if w.Backoff <= 0 {
+		w.Backoff = Duration(time.Second)
+	}
+
+	return nil
+}
+
+// wants reports whether events of type t are sent to w.
+func (w *Webhook) wants(t string) bool {
+	return len(w.Events) == 0 || matchEvent(w.Events, t)
+}
+
+// matchEvent reports whether the event type t matches one of patterns,
+// which are event types or prefixes ending in "*".
+func matchEvent(patterns []string, t string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == t {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the wait before retry n, counted from 1.
+func (w *Webhook) backoff(n int) time.Duration {
+	d := time.Duration(w.Backoff)
+	for i := 1; i < n && d < maxWebhookBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, maxWebhookBackoff)
+}
+
+// Delivery is an event on its way to a webhook, or a dead letter when all
+// attempts failed.
+type Delivery struct {
+	ID       string          `json:"id"`
+	Webhook  string          `json:"webhook"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error,omitempty"`
+	Failed   time.Time       `json:"failed,omitzero"`
+}
+
+// webhookPayload is the body of a delivery: the event with the delivery id,
+// which receivers may use to drop duplicates.
+type webhookPayload struct {
+	ID string `json:"id"`
+	Event
+}
+
+// Webhooks delivers events to the configured webhooks, each one from its
+// own queue so a slow receiver does not hold up the others.
+type Webhooks struct {
+	settings *WebhookSettings
+	queues   map[string]chan *Delivery
+
+	mu   sync.Mutex
+	dead []*Delivery
+
+	// changed wakes up the writer of the dead letters file
+	changed chan struct{}
+}
+
+func NewWebhooks(settings *WebhookSettings) (*Webhooks, error) {
+	wh := &Webhooks{
+		settings: settings,
+		queues:   map[string]chan *Delivery{},
+		changed:  make(chan struct{}, 1),
+	}
+
+	if settings.DeadLetters != "" {
+		data, err := os.ReadFile(settings.DeadLetters)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &wh.dead); err != nil {
+				return nil, fmt.Errorf("%s: %w", settings.DeadLetters, err)
+			}
+		}
+	}
+
+	if settings.DeadLetters != "" {
+		go wh.runSave()
+	}
+
+	for _, hook := range settings.Hooks {
+		queue := make(chan *Delivery, webhookQueueSize)
+		wh.queues[hook.Name] = queue
+
+		go wh.run(hook, queue)
+	}
+
+	return wh, nil
+}
+
+// publish queues ev for every webhook that wants it. It is a bus subscriber
+// and never blocks.
+func (wh *Webhooks) publish(ev Event) {
+	for _, hook := range wh.settings.Hooks {
+		if !hook.wants(ev.Type) {
+			continue
+		}
+
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			fmt.Printf("webhook %s: %s\n", hook.Name, err)
+
+			continue
+		}
+
+		d := &Delivery{ID: hex.EncodeToString(id), Webhook: hook.Name, Event: ev.Type}
+
+		payload, err := json.Marshal(webhookPayload{ID: d.ID, Event: ev})
+		if err != nil {
+			fmt.Printf("webhook %s: could not encode %s event: %s\n", hook.Name, ev.Type, err)
+
+			continue
+		}
+
+		d.Payload = payload
+
+		select {
+		case wh.queues[hook.Name] <- d:
+		default:
+			wh.bury(d, errors.New("queue is full"))
+		}
+	}
+}
+
+// run delivers the queued events of hook one after the other, retrying
+// failed attempts with exponential backoff.
+func (wh *Webhooks) run(hook *Webhook, queue chan *Delivery) {
+	for d := range queue {
+		for {
+			d.Attempts++
+
+			retry, err := wh.deliver(hook, d)
+			if err == nil {
+				break
+			}
+
+			if !retry || d.Attempts > *hook.Retries {
+				wh.bury(d, err)
+
+				break
+			}
+
+			wait := hook.backoff(d.Attempts)
+			fmt.Printf("webhook %s: delivery %s failed, retrying in %s: %s\n", hook.Name, d.ID, wait, err)
+			time.Sleep(wait)
+		}
+	}
+}
+
+// deliver makes a single attempt to post d. Network errors, 5xx and 429
+// responses are worth a retry, other errors are not.
+func (wh *Webhooks) deliver(hook *Webhook, d *Delivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-parking/"+version)
+	req.Header.Set("X-Parking-Event", d.Event)
+	req.Header.Set("X-Parking-Delivery", d.ID)
+	req.Header.Set("X-Parking-Timestamp", timestamp)
+
+	if hook.Secret != "" {
+		req.Header.Set("X-Parking-Signature", "sha256="+webhookSignature(hook.Secret, timestamp, d.Payload))
+	}
+
+	client := &http.Client{Timeout: time.Duration(hook.Timeout)}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// webhookSignature signs "<timestamp>.<body>", so a captured delivery can
+// not be replayed with a fresh timestamp.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bury moves a failed delivery to the dead letters.
+func (wh *Webhooks) bury(d *Delivery, err error) {
+	fmt.Printf("webhook %s: giving up on delivery %s of %s after %d attempts: %s\n", d.Webhook, d.ID, d.Event, d.Attempts, err)
+
+	d.Error = err.Error()
+	d.Failed = time.Now()
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	wh.dead = append(wh.dead, d)
+	if len(wh.dead) > maxDeadLetters {
+		wh.dead = slices.Delete(wh.dead, 0, len(wh.dead)-maxDeadLetters)
+	}
+
+	wh.save()
+}
+
+// save asks runSave to write the dead letters. It never blocks, so bury
+// can be called from publish; changes made while a write is pending are
+// written with it.
+func (wh *Webhooks) save() {
+	select {
+	case wh.changed <- struct{}{}:
+	default:
+	}
+}
+
+// runSave writes the dead letters to their file whenever they change.
+func (wh *Webhooks) runSave() {
+	for range wh.changed {
+		wh.mu.Lock()
+		data, err := json.MarshalIndent(wh.dead, "", "  ")
+		wh.mu.Unlock()
+
+		if err == nil {
+			// write and rename, so a crash never leaves a truncated file
+			tmp := filepath.Join(filepath.Dir(wh.settings.DeadLetters), "."+filepath.Base(wh.settings.DeadLetters)+".tmp")
+			if err = os.WriteFile(tmp, data, 0o600); err == nil {
+				err = os.Rename(tmp, wh.settings.DeadLetters)
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("could not save dead letters: %s\n", err)
+		}
+	}
+}
+
+// DeadLetters returns the failed deliveries, oldest first.
+func (wh *Webhooks) DeadLetters() []*Delivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	return slices.Clone(wh.dead)
+}
+
+// take removes a dead letter from the list and returns it.
+func (wh *Webhooks) take(id string) *Delivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	i := slices.IndexFunc(wh.dead, func(d *Delivery) bool { return d.ID == id })
+	if i < 0 {
+		return nil
+	}
+
+	d := wh.dead[i]
+	wh.dead = slices.Delete(wh.dead, i, i+1)
+	wh.save()
+
+	return d
+}
+
+// Retry queues a dead letter for delivery again.
+func (wh *Webhooks) Retry(id string) (found bool, err error) {
+	d := wh.take(id)
+	if d == nil {
+		return false, nil
+	}
+
+	queue, ok := wh.queues[d.Webhook]
+	if !ok {
+		err = fmt.Errorf("webhook %s is not configured", d.Webhook)
+		wh.bury(d, err)
+
+		return true, err
+	}
+
+	d.Attempts, d.Error, d.Failed = 0, "", time.Time{}
+
+	select {
+	case queue <- d:
+	default:
+		err = errors.New("queue is full")
+		wh.bury(d, err)
+
+		return true, err
+	}
+
+	return true, nil
+}
+
+// getDeadLetters lists the deliveries that failed for good.
+func (s *server) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		writeJSON(w, http.StatusOK, []*Delivery{})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.webhooks.DeadLetters())
+}
+
+// retryDeadLetter queues a dead letter for another round of attempts.
+func (s *server) retryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	found, err := s.webhooks.Retry(r.PathValue("id"))
+	if !found {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deleteDeadLetter drops a dead letter.
+func (s *server) deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil || s.webhooks.take(r.PathValue("id")) == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver answers with the statuses in order, repeating the last
+// one, and passes every request on.
+type webhookReceiver struct {
+	statuses []int
+	calls    atomic.Int32
+	requests chan *http.Request
+	bodies   chan []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, string) {
+	t.Helper()
+
+	wr := &webhookReceiver{statuses: statuses, requests: make(chan *http.Request, 16), bodies: make(chan []byte, 16)}
+
+	ts := httptest.NewServer(wr)
+	t.Cleanup(ts.Close)
+
+	return wr, ts.URL
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(wr.calls.Add(1))
+
+	body, _ := io.ReadAll(r.Body)
+	wr.requests <- r
+	wr.bodies <- body
+
+	w.WriteHeader(wr.statuses[min(n, len(wr.statuses))-1])
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+// newTestWebhooks sets up a single webhook "hook" to url.
+func newTestWebhooks(t *testing.T, hook *Webhook, deadLetters string) *Webhooks {
+	t.Helper()
+
+	hook.Name = "hook"
+	if hook.Backoff == 0 {
+		hook.Backoff = Duration(time.Millisecond)
+	}
+
+	settings := &WebhookSettings{Hooks: []*Webhook{hook}, DeadLetters: deadLetters}
+	if err := settings.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	wh, err := NewWebhooks(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wh
+}
+
+// waitDead waits until there are n dead letters.
+func waitDead(t *testing.T, wh *Webhooks, n int) []*Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if dead := wh.DeadLetters(); len(dead) == n {
+			return dead
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d dead letters, want %d", len(wh.DeadLetters()), n)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	wr, url := newWebhookReceiver(t, http.StatusNoContent)
+	wh := newTestWebhooks(t, &Webhook{URL: url, Secret: "s3cret", Events: []string{"lot.*"}}, "")
+
+	wh.publish(Event{Type: EventSpotChanged, Camera: "yard"})
+	wh.publish(Event{Type: "lot.full", Camera: "yard", Message: "no free spots"})
+
+	r, body := <-wr.requests, <-wr.bodies
+
+	if got := r.Header.Get("X-Parking-Event"); got != "lot.full" {
+		t.Errorf("event header %q, want lot.full: only wanted events are sent", got)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(r.Header.Get("X-Parking-Timestamp") + "."))
+	mac.Write(body)
+
+	if got, want := r.Header.Get("X-Parking-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+
+	var payload struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Camera  string `json:"camera"`
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if payload.ID == "" || payload.ID != r.Header.Get("X-Parking-Delivery") || payload.Type != "lot.full" || payload.Camera != "yard" {
+		t.Errorf("payload %+v, delivery %q", payload, r.Header.Get("X-Parking-Delivery"))
+	}
+
+	// a receiver without a secret gets no signature
+	wr, url = newWebhookReceiver(t, http.StatusOK)
+	newTestWebhooks(t, &Webhook{URL: url}, "").publish(Event{Type: "lot.full"})
+
+	if r := <-wr.requests; r.Header.Get("X-Parking-Signature") != "" {
+		t.Error("signed without a secret")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	hook := &Webhook{Backoff: Duration(time.Second)}
+
+	for n, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		8:  128 * time.Second,
+		9:  256 * time.Second,
+		10: maxWebhookBackoff,
+		// no overflow however many retries
+		100: maxWebhookBackoff,
+	} {
+		if got := hook.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	hook := &Webhook{URL: "http://example.com"}
+	if err := hook.init(); err != nil || *hook.Retries != 5 {
+		t.Errorf("default retries = %d, %v, want 5", *hook.Retries, err)
+	}
+
+	if err := (&Webhook{URL: "http://example.com", Retries: retries(-1)}).init(); err == nil {
+		t.Error("negative retries were accepted")
+	}
+
+	tests := []struct {
+		name     string
+		retries  int
+		statuses []int
+		calls    int
+		dead     bool
+	}{
+		{"recovers", 3, []int{500, 503, 200}, 3, false},
+		{"rate limited", 3, []int{429, 200}, 2, false},
+		{"gives up", 2, []int{500}, 3, true},
+		{"no retries", 0, []int{500}, 1, true},
+		{"not retried", 3, []int{400}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr, url := newWebhookReceiver(t, tt.statuses...)
+			wh := newTestWebhooks(t, &Webhook{URL: url, Retries: retries(tt.retries)}, "")
+
+			wh.publish(Event{Type: "lot.full"})
+
+			for range tt.calls {
+				select {
+				case <-wr.requests:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%d calls, want %d", wr.calls.Load(), tt.calls)
+				}
+			}
+
+			if tt.dead {
+				if d := waitDead(t, wh, 1)[0]; d.Attempts != tt.calls || d.Error == "" || d.Failed.IsZero() {
+					t.Errorf("dead letter %+v", d)
+				}
+			}
+
+			// nothing more arrives
+			time.Sleep(20 * time.Millisecond)
+			if n := int(wr.calls.Load()); n != tt.calls {
+				t.Errorf("%d calls, want %d", n, tt.calls)
+			}
+
+			if !tt.dead && len(wh.DeadLetters()) != 0 {
+				t.Errorf("dead letters %+v", wh.DeadLetters())
+			}
+		})
+	}
+}
+
+// readDeadLetters polls the dead letters file until it holds n entries.
+func readDeadLetters(t *testing.T, path string, n int) []*Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var dead []*Delivery
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &dead) == nil && len(dead) == n {
+			return dead
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%s does not hold %d dead letters", path, n)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	wr, url := newWebhookReceiver(t, 500, 500, 200)
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+
+	s := &server{webhooks: newTestWebhooks(t, &Webhook{URL: url, Retries: retries(0)}, path)}
+
+	s.webhooks.publish(Event{Type: "lot.full"})
+	s.webhooks.publish(Event{Type: "lot.available"})
+
+	waitDead(t, s.webhooks, 2)
+	saved := readDeadLetters(t, path, 2)
+
+	// the file survives a restart
+	reloaded := newTestWebhooks(t, &Webhook{URL: url}, path)
+	if dead := reloaded.DeadLetters(); len(dead) != 2 || dead[0].ID != saved[0].ID {
+		t.Errorf("reloaded dead letters %+v", dead)
+	}
+
+	call := func(method, id string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/webhooks/dead-letters/"+id, nil)
+		r.SetPathValue("id", id)
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w
+	}
+
+	w := call(http.MethodGet, "", s.getDeadLetters)
+
+	var listed []*Delivery
+	if err := json.NewDecoder(w.Body).Decode(&listed); err != nil || len(listed) != 2 {
+		t.Fatalf("GET dead letters: %v, %v", listed, err)
+	}
+
+	// the receiver is fine now: the retried letter is delivered and leaves
+	// the list and the file
+	if w := call(http.MethodPost, listed[0].ID, s.retryDeadLetter); w.Code != http.StatusAccepted {
+		t.Errorf("retry: status %d: %s", w.Code, w.Body)
+	}
+
+	<-wr.requests
+	<-wr.requests
+
+	if r := <-wr.requests; r.Header.Get("X-Parking-Delivery") != listed[0].ID {
+		t.Errorf("retried delivery %s, want %s", r.Header.Get("X-Parking-Delivery"), listed[0].ID)
+	}
+
+	if dead := readDeadLetters(t, path, 1); dead[0].ID != listed[1].ID {
+		t.Errorf("left in the file: %+v", dead)
+	}
+
+	if w := call(http.MethodDelete, listed[1].ID, s.deleteDeadLetter); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d", w.Code)
+	}
+
+	readDeadLetters(t, path, 0)
+
+	for _, tt := range []struct {
+		method  string
+		handler http.HandlerFunc
+	}{
+		{http.MethodPost, s.retryDeadLetter},
+		{http.MethodDelete, s.deleteDeadLetter},
+	} {
+		if w := call(tt.method, listed[1].ID, tt.handler); w.Code != http.StatusNotFound {
+			t.Errorf("%s of a gone letter: status %d", tt.method, w.Code)
+		}
+	}
+
+	if len(s.webhooks.DeadLetters()) != 0 {
+		t.Errorf("dead letters left: %+v", s.webhooks.DeadLetters())
+	}
+}
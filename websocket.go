@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes, RFC 6455 section 5.2.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsMaxPayload limits frames from clients, which only send control
+	// frames and the occasional message that is ignored.
+	wsMaxPayload = 64 << 10
+
+	wsWriteTimeout = 10 * time.Second
+)
+
+// wsConn is the server side of a WebSocket connection. It only sends text
+// messages; messages from the client are read and dropped.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	mu sync.Mutex
+}
+
+// isWebSocket reports whether r asks for a WebSocket upgrade.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && headerHas(r.Header, "Connection", "upgrade")
+}
+
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. Browsers may only connect from a page of the request's host
+// or one of hosts. On error a response has been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, hosts ...string) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+
+		return nil, errors.New("bad handshake")
+	}
+
+	// the cookie of a logged in user is sent along from any page, so a
+	// page of another site could read the stream
+	if origin := r.Header.Get("Origin"); origin != "" && !allowedOrigin(origin, append(hosts, r.Host)) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+
+		return nil, fmt.Errorf("origin %s not allowed", origin)
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+
+		return nil, errors.New("response can not be hijacked")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// allowedOrigin reports whether the host of the Origin header is one of
+// hosts.
+func allowedOrigin(origin string, hosts []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	for _, host := range hosts {
+		if host != "" && strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write sends a single unfragmented frame.
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+
+	return c.rw.Flush()
+}
+
+// readLoop reads frames until the connection is closed, answering pings and
+// close frames. It returns when the client is gone.
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.read()
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case wsPing:
+			c.write(wsPong, payload)
+		case wsClose:
+			// echo the status code, if any, and hang up
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+
+			c.write(wsClose, payload)
+
+			return
+		}
+	}
+}
+
+// read returns the next frame from the client, unmasked.
+func (c *wsConn) read() (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+
+	if n > wsMaxPayload {
+		return 0, nil, errors.New("frame too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
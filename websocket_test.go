@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsDial opens a WebSocket to the stream at url with the extra request
+// headers. It returns the response status and, on success, the connection.
+func wsDial(t *testing.T, url string, header string) (int, net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	host := strings.TrimPrefix(url, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "GET /?camera=yard HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n%s\r\n", host, header)
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the accept key of the example in RFC 6455
+	if resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+
+	return resp.StatusCode, conn, r
+}
+
+// wsReadFrame reads a server frame, which must not be masked.
+func wsReadFrame(t *testing.T, r io.Reader) (opcode byte, payload []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	if header[0]&0x80 == 0 || header[1]&0x80 != 0 {
+		t.Fatalf("header %08b %08b, want a final unmasked frame", header[0], header[1])
+	}
+
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return header[0] & 0x0f, payload
+}
+
+// wsFrame returns a client frame of payload masked with key, or unmasked
+// without a key.
+func wsFrame(opcode byte, payload []byte, key []byte) []byte {
+	frame := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if key == nil {
+		return append(frame, payload...)
+	}
+
+	frame[1] |= 0x80
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+
+	return frame
+}
+
+func TestWebSocketStream(t *testing.T) {
+	s, url := streamServer(t)
+
+	status, conn, r := wsDial(t, url, "")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", status)
+	}
+
+	// the snapshot first, filtered like the event stream
+	opcode, payload := wsReadFrame(t, r)
+
+	var snap StreamSnapshot
+	if err := json.Unmarshal(payload, &snap); err != nil || opcode != wsText || snap.Type != "snapshot" || len(snap.Cameras) != 1 || snap.Cameras["yard"] == nil {
+		t.Fatalf("opcode %d: %s, %v", opcode, payload, err)
+	}
+
+	s.bus.Publish(Event{Type: EventLotFull, Camera: "gate"})
+	s.bus.Publish(Event{Type: EventLotFull, Camera: "yard", Message: strings.Repeat("full ", 100)})
+
+	var ev Event
+	if _, payload := wsReadFrame(t, r); json.Unmarshal(payload, &ev) != nil || ev.Camera != "yard" || len(ev.Message) != 500 {
+		t.Errorf("event %s", payload)
+	}
+
+	// pings are answered with the same payload
+	conn.Write(wsFrame(wsPing, []byte("hello"), []byte{1, 2, 3, 4}))
+	if opcode, payload := wsReadFrame(t, r); opcode != wsPong || string(payload) != "hello" {
+		t.Errorf("pong %d %q", opcode, payload)
+	}
+
+	// the close status is echoed and the connection closed
+	conn.Write(wsFrame(wsClose, []byte{0x03, 0xe8, 'b', 'y', 'e'}, []byte{9, 8, 7, 6}))
+	if opcode, payload := wsReadFrame(t, r); opcode != wsClose || !bytes.Equal(payload, []byte{0x03, 0xe8}) {
+		t.Errorf("close %d %v", opcode, payload)
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open: %v", err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s, url := streamServer(t)
+	s.settings.BaseURL = "https://parking.example.com"
+
+	host := strings.TrimPrefix(url, "http://")
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + host, http.StatusSwitchingProtocols},
+		{"https://parking.example.com", http.StatusSwitchingProtocols},
+		{"https://PARKING.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"https://parking.example.com.evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		header := ""
+		if tt.origin != "" {
+			header = "Origin: " + tt.origin + "\r\n"
+		}
+
+		if status, _, _ := wsDial(t, url, header); status != tt.status {
+			t.Errorf("origin %q: %d, want %d", tt.origin, status, tt.status)
+		}
+	}
+}
+
+func TestOriginHosts(t *testing.T) {
+	s := &server{settings: &Settings{Ingress: true}}
+
+	r := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	r.Header.Set("X-Ingress-Path", "/api/hassio_ingress/abc")
+	r.Header.Set("X-Forwarded-Host", "homeassistant.local:8123")
+
+	// the forwarded host is only trusted from the ingress gateway
+	if hosts := s.originHosts(r); len(hosts) != 0 {
+		t.Errorf("hosts %v from %s", hosts, r.RemoteAddr)
+	}
+
+	r.RemoteAddr = ingressGateway + ":51234"
+	if hosts := s.originHosts(r); len(hosts) != 1 || hosts[0] != "homeassistant.local:8123" {
+		t.Errorf("hosts %v through ingress", hosts)
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		method, version, key string
+	}{
+		{http.MethodPost, "13", "dGhlIHNhbXBsZSBub25jZQ=="},
+		{http.MethodGet, "8", "dGhlIHNhbXBsZSBub25jZQ=="},
+		{http.MethodGet, "13", ""},
+	} {
+		r := httptest.NewRequest(tt.method, "/stream", nil)
+		r.Header.Set("Sec-WebSocket-Version", tt.version)
+		r.Header.Set("Sec-WebSocket-Key", tt.key)
+
+		w := httptest.NewRecorder()
+		if _, err := upgradeWebSocket(w, r); err == nil || w.Code != http.StatusBadRequest || w.Header().Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%+v: %d, %v", tt, w.Code, err)
+		}
+	}
+}
+
+// pipeConn returns the server side of a WebSocket over a pipe and the
+// client end.
+func pipeConn(t *testing.T) (*wsConn, net.Conn) {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	return &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}, client
+}
+
+func TestWebSocketFrameLengths(t *testing.T) {
+	c, client := pipeConn(t)
+
+	// the three length encodings at their limits
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+
+		go c.write(wsText, payload)
+
+		if opcode, got := wsReadFrame(t, client); opcode != wsText || !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: opcode %d, %d bytes", n, opcode, len(got))
+		}
+
+		key := []byte{0xde, 0xad, 0xbe, 0xef}
+		go client.Write(wsFrame(wsText, payload, key))
+
+		if opcode, got, err := c.read(); err != nil || opcode != wsText || !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes from the client: opcode %d, %d bytes, %v", n, opcode, len(got), err)
+		}
+	}
+}
+
+func TestWebSocketBadFrames(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"unmasked":  wsFrame(wsText, []byte("hi"), nil),
+		"too large": wsFrame(wsText, make([]byte, wsMaxPayload+1), []byte{1, 2, 3, 4})[:14],
+		"cut short": wsFrame(wsText, []byte("hello"), []byte{1, 2, 3, 4})[:8],
+	} {
+		c, client := pipeConn(t)
+
+		go func() {
+			client.Write(frame)
+			client.Close()
+		}()
+
+		if _, _, err := c.read(); err == nil {
+			t.Errorf("%s frame was read", name)
+		}
+	}
+}
+
+func TestWebSocketSlowClient(t *testing.T) {
+	s, url := streamServer(t)
+
+	// a snapshot far larger than the socket buffers keeps the server
+	// writing until the client reads
+	s.cameras.get("yard").setLast(&Analysis{Spots: []*SpotResult{{ID: "a", Name: strings.Repeat("x", 32<<20)}}})
+
+	status, _, r := wsDial(t, url, "")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", status)
+	}
+
+	for range streamBuffer + 2 {
+		s.bus.Publish(Event{Type: EventLotFull, Camera: "yard"})
+	}
+
+	if _, payload := wsReadFrame(t, r); len(payload) < 32<<20 {
+		t.Fatalf("snapshot of %d bytes", len(payload))
+	}
+
+	// the events that fit are sent, then the client is told to come back
+	// later
+	for events := 0; ; events++ {
+		opcode, payload := wsReadFrame(t, r)
+		if opcode == wsText {
+			continue
+		}
+
+		if opcode != wsClose || binary.BigEndian.Uint16(payload) != 1013 || events > streamBuffer {
+			t.Errorf("opcode %d %v after %d events", opcode, payload, events)
+		}
+
+		break
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ZoneCount is the number of spots per status in a zone or the whole lot.
+type ZoneCount struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Free     int    `json:"free"`
+	Occupied int    `json:"occupied"`
+	Unknown  int    `json:"unknown"`
+	Total    int    `json:"total"`
+}
+
+func (zc *ZoneCount) add(spot *SpotResult, settings *ConfidenceSettings) {
+	if spot.Status == StatusOutOfService {
+		return
+	}
+
+	zc.Total++
+
+	// hidden spots are not reported as free or occupied anywhere
+	status := spot.Status
+	if spot.LowConfidence && settings.Mode == LowConfidenceHide {
+		status = StatusUnknown
+	}
+
+	switch status {
+	case StatusFree:
+		zc.Free++
+	case StatusOccupied:
+		zc.Occupied++
+	default:
+		zc.Unknown++
+	}
+}
+
+// String returns e.g. "EV charging: 2 free of 4".
+func (zc *ZoneCount) String() string {
+	name := zc.Name
+	if name == "" {
+		name = zc.ID
+	}
+
+	text := fmt.Sprintf("%s: %d free of %d", name, zc.Free, zc.Total)
+	if zc.Unknown > 0 {
+		text += fmt.Sprintf(", %d unknown", zc.Unknown)
+	}
+
+	return text
+}
+
+// countZones sums up the spots of a for the whole lot and for every zone of
+// layout. It must run after the quality and confidence checks.
+func countZones(a *Analysis, layout *Layout, settings *ConfidenceSettings) {
+	a.Counts = ZoneCount{}
+	a.Zones = nil
+
+	for _, id := range layout.ZoneIDs() {
+		a.Zones = append(a.Zones, &ZoneCount{ID: id, Name: layout.ZoneName(id)})
+	}
+
+	for _, spot := range a.Spots {
+		a.Counts.add(spot, settings)
+
+		for _, zc := range a.Zones {
+			if slices.Contains(spot.Zones, zc.ID) {
+				zc.add(spot, settings)
+			}
+		}
+	}
+}
+
+// zoneCaption describes the given zones of a, one per line. Zones that are
+// not in the layout of the analyzed camera are skipped.
+func zoneCaption(a *Analysis, zones []string) string {
+	var lines []string
+	for _, id := range zones {
+		for _, zc := range a.Zones {
+			if zc.ID == id {
+				lines = append(lines, zc.String())
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// checkFull follows whether the lot seen by the camera is full. Frames with
+// unknown spots and no free one leave the state as it is. It returns whether
+// the state has changed.
+func (state *cameraState) checkFull(a *Analysis) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	full := state.full
+	switch {
+	case a.Counts.Free > 0:
+		full = false
+	case a.Counts.Unknown == 0 && a.Counts.Total > 0:
+		full = true
+	}
+
+	changed := full != state.full
+	state.full = full
+
+	return changed
+}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestCountZones(t *testing.T) {
+	layout := &Layout{
+		Spots: []*Spot{
+			{ID: "1", Zones: []string{"ev", "near"}},
+			{ID: "2", Zones: []string{"near"}},
+			{ID: "3", Zones: []string{"ev"}},
+			{ID: "4"},
+			{ID: "5", Zones: []string{"near"}},
+		},
+		Zones: map[string]string{"ev": "EV charging"},
+	}
+
+	a := &Analysis{Spots: []*SpotResult{
+		{ID: "1", Zones: []string{"ev", "near"}, Status: StatusFree},
+		{ID: "2", Zones: []string{"near"}, Status: StatusOccupied},
+		{ID: "3", Zones: []string{"ev"}, Status: StatusFree, LowConfidence: true},
+		{ID: "4", Status: StatusUnknown},
+		{ID: "5", Zones: []string{"near"}, Status: StatusOutOfService},
+	}}
+
+	tests := []struct {
+		mode          string
+		lot, ev, near ZoneCount
+	}{
+		{
+			LowConfidenceFlag,
+			ZoneCount{Free: 2, Occupied: 1, Unknown: 1, Total: 4},
+			ZoneCount{ID: "ev", Name: "EV charging", Free: 2, Total: 2},
+			ZoneCount{ID: "near", Name: "near", Free: 1, Occupied: 1, Total: 2},
+		},
+		// hidden spots count as unknown everywhere
+		{
+			LowConfidenceHide,
+			ZoneCount{Free: 1, Occupied: 1, Unknown: 2, Total: 4},
+			ZoneCount{ID: "ev", Name: "EV charging", Free: 1, Unknown: 1, Total: 2},
+			ZoneCount{ID: "near", Name: "near", Free: 1, Occupied: 1, Total: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		settings := &ConfidenceSettings{Mode: tt.mode}
+		settings.init()
+
+		// counting twice starts over
+		countZones(a, layout, settings)
+		countZones(a, layout, settings)
+
+		if a.Counts != tt.lot {
+			t.Errorf("%s: lot %+v, want %+v", tt.mode, a.Counts, tt.lot)
+		}
+
+		// a spot in two zones counts in both, zones keep the order of use
+		if len(a.Zones) != 2 || *a.Zones[0] != tt.ev || *a.Zones[1] != tt.near {
+			t.Errorf("%s: zones %+v, %+v", tt.mode, a.Zones[0], a.Zones[1])
+		}
+	}
+
+	if caption := zoneCaption(a, []string{"near", "gone", "ev"}); caption != "near: 1 free of 2\nEV charging: 1 free of 2, 1 unknown" {
+		t.Errorf("caption %q", caption)
+	}
+}
+
+func TestCheckFull(t *testing.T) {
+	state := &cameraState{}
+
+	// full and free fire once on every change, frames with unknown spots and
+	// nothing free are no evidence either way
+	for i, tt := range []struct {
+		counts  ZoneCount
+		full    bool
+		changed bool
+	}{
+		{ZoneCount{Free: 1, Occupied: 2, Total: 3}, false, false},
+		{ZoneCount{Occupied: 3, Total: 3}, true, true},
+		{ZoneCount{Occupied: 3, Total: 3}, true, false},
+		{ZoneCount{Occupied: 2, Unknown: 1, Total: 3}, true, false},
+		{ZoneCount{Free: 1, Unknown: 2, Total: 3}, false, true},
+		{ZoneCount{Occupied: 2, Unknown: 1, Total: 3}, false, false},
+		{ZoneCount{Unknown: 3, Total: 3}, false, false},
+		{ZoneCount{}, false, false},
+		{ZoneCount{Occupied: 3, Total: 3}, true, true},
+		{ZoneCount{}, true, false},
+		{ZoneCount{Free: 3, Total: 3}, false, true},
+	} {
+		if changed := state.checkFull(&Analysis{Counts: tt.counts}); changed != tt.changed || state.full != tt.full {
+			t.Errorf("frame %d %+v: full %v, changed %v, want %v, %v", i, tt.counts, state.full, changed, tt.full, tt.changed)
+		}
+	}
+}